@@ -98,6 +98,8 @@ Usage:
 	ergo importdb <database.json> [--conf <filename>] [--quiet]
 	ergo genpasswd [--conf <filename>] [--quiet]
 	ergo mkcerts [--conf <filename>] [--quiet]
+	ergo matrix-registration [--conf <filename>]
+	ergo ctl [--conf <filename>] <command> [<arg>...]
 	ergo defaultconfig
 	ergo run [--conf <filename>] [--quiet] [--smoke]
 	ergo -h | --help
@@ -144,6 +146,9 @@ Options:
 	} else if arguments["mkcerts"].(bool) {
 		doMkcerts(arguments["--conf"].(string), arguments["--quiet"].(bool))
 		return
+	} else if arguments["ctl"].(bool) && arguments["<command>"].(string) == "help" {
+		fmt.Print(irc.ControlUsage())
+		return
 	}
 
 	configfile := arguments["--conf"].(string)
@@ -181,6 +186,17 @@ Options:
 		if err != nil {
 			log.Fatal("Error while importing db:", err.Error())
 		}
+	} else if arguments["matrix-registration"].(bool) {
+		registration, err := irc.MatrixRegistration(config)
+		if err != nil {
+			log.Fatal("Error while generating Matrix registration:", err.Error())
+		}
+		fmt.Print(string(registration))
+	} else if arguments["ctl"].(bool) {
+		err = irc.RunControlCommand(config, arguments["<command>"].(string), arguments["<arg>"].([]string), os.Stdout)
+		if err != nil {
+			log.Fatal("Error: ", err.Error())
+		}
 	} else if arguments["run"].(bool) {
 		if !arguments["--quiet"].(bool) {
 			logman.Info("server", fmt.Sprintf("%s starting", irc.Ver))
@@ -48,6 +48,8 @@ const (
 	keyAccountSuspended        = "account.suspended %s" // client realname stored as string
 	keyAccountPwReset          = "account.pwreset %s"
 	keyAccountEmailChange      = "account.emailchange %s"
+	keyAccountMemos            = "account.memos %s"
+	keyAccountWebPush          = "account.webpush %s" // JSON list of push subscriptions
 	// for an always-on client, a map of channel names they're in to their current modes
 	// (not to be confused with their amodes, which a non-always-on client can have):
 	keyAccountChannelToModes = "account.channeltomodes %s"
@@ -807,14 +809,28 @@ func (am *AccountManager) dispatchMailtoCallback(client *Client, account string,
 	}
 
 	message := email.ComposeMail(config, callbackValue, subject)
-	fmt.Fprintf(&message, client.t("Account: %s"), account)
-	message.WriteString("\r\n")
-	fmt.Fprintf(&message, client.t("Verification code: %s"), code)
-	message.WriteString("\r\n")
-	message.WriteString("\r\n")
-	message.WriteString(client.t("To verify your account, issue the following command:"))
-	message.WriteString("\r\n")
-	fmt.Fprintf(&message, "/MSG NickServ VERIFY %s %s\r\n", account, code)
+	command := fmt.Sprintf("/MSG NickServ VERIFY %s %s", account, code)
+	custom, err := config.WriteVerifyMessage(&message, email.VerifyMessageData{
+		Account: account,
+		Code:    code,
+		Server:  am.server.name,
+		Command: command,
+	})
+	if err != nil {
+		am.server.logger.Error("internal", "Failed to execute verify-message template", err.Error())
+		return
+	}
+	if !custom {
+		fmt.Fprintf(&message, client.t("Account: %s"), account)
+		message.WriteString("\r\n")
+		fmt.Fprintf(&message, client.t("Verification code: %s"), code)
+		message.WriteString("\r\n")
+		message.WriteString("\r\n")
+		message.WriteString(client.t("To verify your account, issue the following command:"))
+		message.WriteString("\r\n")
+		message.WriteString(command)
+		message.WriteString("\r\n")
+	}
 
 	err = email.SendMail(config, callbackValue, message.Bytes())
 	if err != nil {
@@ -1169,11 +1185,34 @@ func (am *AccountManager) NsResetpass(client *Client, accountName, code, passwor
 		return nil
 	})
 
-	if success {
-		return am.setPassword(accountName, password, true)
-	} else {
+	if !success {
 		return errAccountInvalidCredentials
 	}
+	err = am.setPassword(accountName, password, true)
+	if err != nil {
+		return
+	}
+
+	// the reset may be in response to a compromise of the account, so optionally
+	// revoke the other ways that someone else might currently have access to it:
+	resetConfig := am.server.Config().Accounts.Registration.EmailVerification.PasswordReset
+	if resetConfig.ClearCertfps {
+		for _, certfp := range account.Credentials.Certfps {
+			if err := am.addRemoveCertfp(accountName, certfp, false, true); err != nil {
+				am.server.logger.Error("accounts", "couldn't remove certfp after password reset", accountName, err.Error())
+			}
+		}
+	}
+	if resetConfig.LogoutSessions {
+		var others []*Client
+		for _, mcl := range am.AccountToClients(accountName) {
+			if mcl != client {
+				others = append(others, mcl)
+			}
+		}
+		am.killClients(others, "The password for your account was reset")
+	}
+	return nil
 }
 
 type PasswordResetRecord struct {
@@ -1412,7 +1451,7 @@ func (am *AccountManager) AuthenticateByPassphrase(client *Client, accountName s
 	config := am.server.Config()
 	if config.Accounts.AuthScript.Enabled {
 		var output AuthScriptOutput
-		output, err = CheckAuthScript(am.server.semaphores.AuthScript, config.Accounts.AuthScript.ScriptConfig,
+		output, err = CheckAuthScript(am.server.semaphores.AuthScript, config.Accounts.AuthScript,
 			AuthScriptInput{AccountName: accountName, Passphrase: passphrase, IP: client.IP().String()})
 		if err != nil {
 			am.server.logger.Error("internal", "failed shell auth invocation", err.Error())
@@ -1451,12 +1490,7 @@ func (am *AccountManager) AuthenticateByOAuthBearer(client *Client, opts oauth2.
 		return &ThrottleError{remainingTime}
 	}
 
-	var username string
-	if config.Accounts.AuthScript.Enabled && config.Accounts.OAuth2.AuthScript {
-		username, err = am.authenticateByOAuthBearerScript(client, config, opts)
-	} else {
-		username, err = config.Accounts.OAuth2.Introspect(context.Background(), opts.Token)
-	}
+	username, err := am.oauth2Username(config, opts, client.IP().String())
 	if err != nil {
 		return err
 	}
@@ -1468,6 +1502,14 @@ func (am *AccountManager) AuthenticateByOAuthBearer(client *Client, opts oauth2.
 	return err
 }
 
+// oauth2Username validates an OAuth 2.0 bearer token, returning the username
+func (am *AccountManager) oauth2Username(config *Config, opts oauth2.OAuthBearerOptions, ip string) (username string, err error) {
+	if config.Accounts.AuthScript.Enabled && config.Accounts.OAuth2.AuthScript {
+		return am.authenticateByOAuthBearerScript(ip, config, opts)
+	}
+	return config.Accounts.OAuth2.Introspect(context.Background(), opts.Token)
+}
+
 func (am *AccountManager) AuthenticateByJWT(client *Client, token string) (err error) {
 	config := am.server.Config()
 	// enabled check is encapsulated here:
@@ -1483,9 +1525,9 @@ func (am *AccountManager) AuthenticateByJWT(client *Client, token string) (err e
 	return err
 }
 
-func (am *AccountManager) authenticateByOAuthBearerScript(client *Client, config *Config, opts oauth2.OAuthBearerOptions) (username string, err error) {
-	output, err := CheckAuthScript(am.server.semaphores.AuthScript, config.Accounts.AuthScript.ScriptConfig,
-		AuthScriptInput{OAuthBearer: &opts, IP: client.IP().String()})
+func (am *AccountManager) authenticateByOAuthBearerScript(ip string, config *Config, opts oauth2.OAuthBearerOptions) (username string, err error) {
+	output, err := CheckAuthScript(am.server.semaphores.AuthScript, config.Accounts.AuthScript,
+		AuthScriptInput{OAuthBearer: &opts, IP: ip})
 
 	if err != nil {
 		am.server.logger.Error("internal", "failed shell auth invocation", err.Error())
@@ -1724,10 +1766,12 @@ func (am *AccountManager) Suspend(accountName string, duration time.Duration, op
 	return nil
 }
 
-func (am *AccountManager) killClients(clients []*Client) {
+// killClients logs out and disconnects `clients`; `message` is the quit
+// message, which is translated for each client
+func (am *AccountManager) killClients(clients []*Client, message string) {
 	for _, client := range clients {
 		client.Logout()
-		client.Quit(client.t("You are no longer authorized to be on this server"), nil)
+		client.Quit(client.t(message), nil)
 		client.destroy(nil)
 	}
 }
@@ -1843,10 +1887,12 @@ func (am *AccountManager) Unregister(account string, erase bool) error {
 	suspendedKey := fmt.Sprintf(keyAccountSuspended, casefoldedAccount)
 	pwResetKey := fmt.Sprintf(keyAccountPwReset, casefoldedAccount)
 	emailChangeKey := fmt.Sprintf(keyAccountEmailChange, casefoldedAccount)
+	memosKey := fmt.Sprintf(keyAccountMemos, casefoldedAccount)
+	webPushKey := fmt.Sprintf(keyAccountWebPush, casefoldedAccount)
 
 	var clients []*Client
 	defer func() {
-		am.killClients(clients)
+		am.killClients(clients, "You are no longer authorized to be on this server")
 	}()
 
 	// on our way out, unregister all the account's channels and delete them from the db
@@ -1901,6 +1947,8 @@ func (am *AccountManager) Unregister(account string, erase bool) error {
 		tx.Delete(suspendedKey)
 		tx.Delete(pwResetKey)
 		tx.Delete(emailChangeKey)
+		tx.Delete(memosKey)
+		tx.Delete(webPushKey)
 
 		return nil
 	})
@@ -1984,7 +2032,7 @@ func (am *AccountManager) AuthenticateByCertificate(client *Client, certfp strin
 	config := am.server.Config()
 	if config.Accounts.AuthScript.Enabled {
 		var output AuthScriptOutput
-		output, err = CheckAuthScript(am.server.semaphores.AuthScript, config.Accounts.AuthScript.ScriptConfig,
+		output, err = CheckAuthScript(am.server.semaphores.AuthScript, config.Accounts.AuthScript,
 			AuthScriptInput{Certfp: certfp, IP: client.IP().String(), peerCerts: peerCerts})
 		if err != nil {
 			am.server.logger.Error("internal", "failed shell auth invocation", err.Error())
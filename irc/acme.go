@@ -0,0 +1,100 @@
+package irc
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+const (
+	defaultACMECacheDir = "acme-cache"
+)
+
+// ACMEConfig controls automatic provisioning of TLS certificates via ACME
+// (e.g., from Let's Encrypt), for listeners configured with `tls: {acme: true}`.
+type ACMEConfig struct {
+	Enabled   bool
+	Hostnames []string
+	Email     string
+	// where to store the account key and certificates across restarts:
+	CacheDir string `yaml:"cache-dir"`
+	// defaults to Let's Encrypt:
+	DirectoryURL string `yaml:"directory-url"`
+	// HTTP listener for answering http-01 challenges (must be reachable on port 80):
+	HTTPListener string `yaml:"http-listener"`
+}
+
+func (conf *ACMEConfig) prepare() (err error) {
+	if !conf.Enabled {
+		return nil
+	}
+	if len(conf.Hostnames) == 0 {
+		return errors.New("ACME is enabled, but no hostnames are configured")
+	}
+	if conf.HTTPListener == "" {
+		return errors.New("ACME is enabled, but no http-listener is configured for challenges")
+	}
+	if conf.CacheDir == "" {
+		conf.CacheDir = defaultACMECacheDir
+	}
+	return nil
+}
+
+func (conf *ACMEConfig) equals(other *ACMEConfig) bool {
+	return conf.Enabled == other.Enabled && slices.Equal(conf.Hostnames, other.Hostnames) &&
+		conf.Email == other.Email && conf.CacheDir == other.CacheDir &&
+		conf.DirectoryURL == other.DirectoryURL && conf.HTTPListener == other.HTTPListener
+}
+
+// setupACME (re)creates the ACME certificate manager and its challenge listener
+// as necessary, then attaches the manager to the listeners that request it.
+// the challenge listener is bound synchronously, so that (e.g.) a port that is
+// already in use fails the rehash instead of every later certificate request.
+func (server *Server) setupACME(config *Config) (err error) {
+	acmeConfig := config.Server.ACME
+	if server.acmeManager != nil && !server.acmeConfig.equals(&acmeConfig) {
+		server.logger.Info("server", "Stopping ACME challenge listener", server.acmeServer.Addr)
+		server.acmeServer.Close()
+		server.acmeManager, server.acmeServer = nil, nil
+	}
+	server.acmeConfig = acmeConfig
+
+	if acmeConfig.Enabled && server.acmeManager == nil {
+		manager := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			Cache:      autocert.DirCache(acmeConfig.CacheDir),
+			HostPolicy: autocert.HostWhitelist(acmeConfig.Hostnames...),
+			Email:      acmeConfig.Email,
+		}
+		if acmeConfig.DirectoryURL != "" {
+			manager.Client = &acme.Client{DirectoryURL: acmeConfig.DirectoryURL}
+		}
+		listener, err := net.Listen("tcp", acmeConfig.HTTPListener)
+		if err != nil {
+			return fmt.Errorf("couldn't start the ACME challenge listener: %w", err)
+		}
+		challengeServer := &http.Server{
+			Addr:    acmeConfig.HTTPListener,
+			Handler: manager.HTTPHandler(nil),
+		}
+		go func() {
+			if err := challengeServer.Serve(listener); err != nil && err != http.ErrServerClosed {
+				server.logger.Error("server", "ACME challenge listener failed", err.Error())
+			}
+		}()
+		server.acmeManager, server.acmeServer = manager, challengeServer
+		server.logger.Info("server", "Started ACME challenge listener", challengeServer.Addr)
+	}
+
+	for _, lconf := range config.Server.trueListeners {
+		if lconf.ACME {
+			lconf.TLSConfig.GetCertificate = server.acmeManager.GetCertificate
+		}
+	}
+	return nil
+}
@@ -0,0 +1,659 @@
+package irc
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ergochat/irc-go/ircfmt"
+
+	"github.com/ergochat/ergo/irc/custime"
+	"github.com/ergochat/ergo/irc/sno"
+	"github.com/ergochat/ergo/irc/utils"
+)
+
+// the HTTP API is a JSON REST API for administering the server, served on a
+// dedicated listener. requests are authenticated with a bearer token; each
+// token is bound to an oper class, and endpoints that modify state require
+// the same role capability as the corresponding IRC command (e.g., "ban"
+// for K-Lines); reading client and channel information, which includes IPs
+// and secret channels, requires "api-read". actions taken through the API
+// are recorded in the audit log.
+
+const (
+	maxAPIRequestSize = 65536
+)
+
+var (
+	errAPINotFound = errors.New("not found")
+)
+
+type apiSettings struct {
+	listener string
+	cert     string
+	key      string
+}
+
+type apiRequest struct {
+	server *Server
+	// the name of the API token, or "" for requests made over the control socket
+	tokenName string
+	w         http.ResponseWriter
+	r         *http.Request
+}
+
+type apiHandlerFunc func(req *apiRequest)
+
+func (server *Server) setupAPIListener(config *Config) {
+	var settings apiSettings
+	if config.API.Enabled {
+		settings = apiSettings{listener: config.API.Listener, cert: config.API.TLS.Cert, key: config.API.TLS.Key}
+	}
+	if server.apiServer != nil {
+		if settings == server.apiSettings {
+			return
+		}
+		server.logger.Info("server", "Stopping HTTP API listener", server.apiServer.Addr)
+		server.apiServer.Close()
+		server.apiServer = nil
+	}
+	server.apiSettings = settings
+	if settings.listener == "" {
+		return
+	}
+
+	mux := http.NewServeMux()
+	server.registerAPIHandlers(mux, false)
+	as := http.Server{
+		Addr:    settings.listener,
+		Handler: mux,
+	}
+	go func() {
+		var err error
+		if settings.cert != "" {
+			err = as.ListenAndServeTLS(settings.cert, settings.key)
+		} else {
+			err = as.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
+			server.logger.Error("server", "HTTP API listener failed", err.Error())
+		}
+	}()
+	server.apiServer = &as
+	server.logger.Info("server", "Started HTTP API listener", settings.listener)
+}
+
+// registerAPIHandlers registers the API endpoints on `mux`. requests made over
+// the control socket are not authenticated, since access to the socket is
+// restricted by its file permissions.
+func (server *Server) registerAPIHandlers(mux *http.ServeMux, control bool) {
+	handle := func(pattern, capab string, handler apiHandlerFunc) {
+		mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+			server.serveAPI(w, r, control, capab, handler)
+		})
+	}
+	handle("GET /v1/stats", "", apiStatsHandler)
+	handle("GET /v1/clients", "api-read", apiListClientsHandler)
+	handle("GET /v1/clients/{nick}", "api-read", apiClientHandler)
+	handle("POST /v1/clients/{nick}/kill", "kill", apiKillHandler)
+	handle("GET /v1/channels", "api-read", apiListChannelsHandler)
+	handle("GET /v1/channels/{channel}", "api-read", apiChannelHandler)
+	handle("GET /v1/klines", "ban", apiListKlinesHandler)
+	handle("POST /v1/klines", "ban", apiAddKlineHandler)
+	handle("DELETE /v1/klines/{mask}", "ban", apiRemoveKlineHandler)
+	handle("POST /v1/accounts", "accreg", apiRegisterAccountHandler)
+	handle("GET /v1/accounts/{account}", "accreg", apiAccountHandler)
+	handle("DELETE /v1/accounts/{account}", "accreg", apiUnregisterAccountHandler)
+	handle("POST /v1/accounts/{account}/suspend", "accreg", apiSuspendAccountHandler)
+	handle("DELETE /v1/accounts/{account}/suspend", "accreg", apiUnsuspendAccountHandler)
+	handle("POST /v1/broadcast", "massmessage", apiBroadcastHandler)
+	handle("POST /v1/rehash", "rehash", apiRehashHandler)
+	handle("POST /v1/shutdown", "die", apiShutdownHandler)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		writeAPIError(w, http.StatusNotFound, errAPINotFound)
+	})
+}
+
+// lookupAPIToken returns the name and configuration of the API token
+// presented in the request, if there is one
+func lookupAPIToken(config *Config, r *http.Request) (name string, apiToken *APITokenConfig) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return
+	}
+	for tokenName, tokenConfig := range config.API.Tokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(tokenConfig.Token)) == 1 {
+			return tokenName, &tokenConfig
+		}
+	}
+	return
+}
+
+func (server *Server) serveAPI(w http.ResponseWriter, r *http.Request, control bool, capab string, handler apiHandlerFunc) {
+	defer server.HandlePanic()
+
+	if control {
+		handler(&apiRequest{server: server, w: w, r: r})
+		return
+	}
+	config := server.Config()
+	name, apiToken := lookupAPIToken(config, r)
+	if apiToken == nil {
+		writeAPIError(w, http.StatusUnauthorized, errors.New("invalid token"))
+		return
+	}
+	if capab != "" && !apiToken.class.Capabilities.Has(capab) {
+		writeAPIError(w, http.StatusForbidden, fmt.Errorf("this token lacks the %s capability", capab))
+		return
+	}
+	handler(&apiRequest{server: server, tokenName: name, w: w, r: r})
+}
+
+func writeAPIResponse(w http.ResponseWriter, status int, response any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
+
+func writeAPIError(w http.ResponseWriter, status int, err error) {
+	writeAPIResponse(w, status, map[string]string{"error": err.Error()})
+}
+
+// actor describes the client of the API, for the audit log and snomasks
+func (req *apiRequest) actor() string {
+	if req.tokenName == "" {
+		return "[control socket]"
+	}
+	return fmt.Sprintf("API [%s]", req.tokenName)
+}
+
+func (req *apiRequest) audit(action, target, reason string) {
+	req.server.auditAPI(req.actor(), req.tokenName, action, target, reason)
+}
+
+func (req *apiRequest) respond(response any) {
+	writeAPIResponse(req.w, http.StatusOK, response)
+}
+
+func (req *apiRequest) fail(status int, err error) {
+	writeAPIError(req.w, status, err)
+}
+
+// decode parses the JSON request body, if any, into `body`; it responds
+// with an error (and returns false) if the body is invalid
+func (req *apiRequest) decode(body any) bool {
+	if err := json.NewDecoder(io.LimitReader(req.r.Body, maxAPIRequestSize)).Decode(body); err != nil && err != io.EOF {
+		req.fail(http.StatusBadRequest, errors.New("invalid request body"))
+		return false
+	}
+	return true
+}
+
+type apiStats struct {
+	Version   string    `json:"version"`
+	StartTime time.Time `json:"start_time"`
+	Unknown   int       `json:"unknown"`
+	Total     int       `json:"total"`
+	Max       int       `json:"max"`
+	Invisible int       `json:"invisible"`
+	Operators int       `json:"operators"`
+	Channels  int       `json:"channels"`
+}
+
+// GET /v1/stats
+func apiStatsHandler(req *apiRequest) {
+	server := req.server
+	stats := server.stats.GetValues()
+	req.respond(apiStats{
+		Version:   Ver,
+		StartTime: server.ctime,
+		Unknown:   stats.Unknown,
+		Total:     stats.Total,
+		Max:       stats.Max,
+		Invisible: stats.Invisible,
+		Operators: stats.Operators,
+		Channels:  server.channels.Len(),
+	})
+}
+
+type apiClient struct {
+	Nick      string    `json:"nick"`
+	Username  string    `json:"username"`
+	Hostname  string    `json:"hostname"`
+	Realname  string    `json:"realname"`
+	IP        string    `json:"ip"`
+	Account   string    `json:"account,omitempty"`
+	Oper      string    `json:"oper,omitempty"`
+	Away      string    `json:"away,omitempty"`
+	AlwaysOn  bool      `json:"always_on"`
+	Sessions  int       `json:"sessions"`
+	Connected time.Time `json:"connected"`
+	Channels  []string  `json:"channels,omitempty"`
+}
+
+func makeAPIClient(client *Client, includeChannels bool) (result apiClient) {
+	details := client.Details()
+	result = apiClient{
+		Nick:      details.nick,
+		Username:  details.username,
+		Hostname:  details.hostname,
+		Realname:  details.realname,
+		IP:        utils.IPStringToHostname(client.IP().String()),
+		AlwaysOn:  client.AlwaysOn(),
+		Sessions:  len(client.Sessions()),
+		Connected: client.ctime,
+	}
+	if details.accountName != "*" {
+		result.Account = details.accountName
+	}
+	if oper := client.Oper(); oper != nil {
+		result.Oper = oper.Name
+	}
+	if away, message := client.Away(); away {
+		result.Away = message
+	}
+	if includeChannels {
+		for _, channel := range client.Channels() {
+			result.Channels = append(result.Channels, channel.Name())
+		}
+		sort.Strings(result.Channels)
+	}
+	return
+}
+
+// GET /v1/clients
+func apiListClientsHandler(req *apiRequest) {
+	clients := req.server.clients.AllClients()
+	result := make([]apiClient, 0, len(clients))
+	for _, client := range clients {
+		result = append(result, makeAPIClient(client, false))
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Nick < result[j].Nick })
+	req.respond(result)
+}
+
+// GET /v1/clients/{nick}
+func apiClientHandler(req *apiRequest) {
+	client := req.server.clients.Get(req.r.PathValue("nick"))
+	if client == nil {
+		req.fail(http.StatusNotFound, errors.New("no such nick"))
+		return
+	}
+	req.respond(makeAPIClient(client, true))
+}
+
+// POST /v1/clients/{nick}/kill {"reason": "..."}
+func apiKillHandler(req *apiRequest) {
+	var body struct {
+		Reason string `json:"reason"`
+	}
+	if !req.decode(&body) {
+		return
+	}
+	server := req.server
+	target := server.clients.Get(req.r.PathValue("nick"))
+	if target == nil {
+		req.fail(http.StatusNotFound, errors.New("no such nick"))
+		return
+	}
+	quitMsg := "Killed"
+	snoLine := fmt.Sprintf(ircfmt.Unescape("%s was killed by %s"), target.Nick(), req.actor())
+	if body.Reason != "" {
+		quitMsg = fmt.Sprintf("Killed: %s", body.Reason)
+		snoLine = fmt.Sprintf(ircfmt.Unescape("%s $c[grey][$r%s$c[grey]]"), snoLine, body.Reason)
+	}
+	server.snomasks.Send(sno.LocalKills, snoLine)
+	req.audit("KILL", target.Nick(), body.Reason)
+	target.Quit(quitMsg, nil)
+	target.destroy(nil)
+	req.respond(map[string]bool{"success": true})
+}
+
+type apiChannelMember struct {
+	Nick     string `json:"nick"`
+	Prefixes string `json:"prefixes,omitempty"`
+}
+
+type apiChannel struct {
+	Name       string             `json:"name"`
+	Topic      string             `json:"topic"`
+	NumMembers int                `json:"num_members"`
+	Founder    string             `json:"founder,omitempty"`
+	Members    []apiChannelMember `json:"members,omitempty"`
+}
+
+func makeAPIChannel(channel *Channel, includeMembers bool) (result apiChannel) {
+	members := channel.Members()
+	channel.stateMutex.RLock()
+	result = apiChannel{
+		Name:       channel.name,
+		Topic:      channel.topic,
+		NumMembers: len(members),
+		Founder:    channel.registeredFounder,
+	}
+	channel.stateMutex.RUnlock()
+	if includeMembers {
+		result.Members = make([]apiChannelMember, 0, len(members))
+		for _, member := range members {
+			result.Members = append(result.Members, apiChannelMember{
+				Nick:     member.Nick(),
+				Prefixes: channel.ClientPrefixes(member, true),
+			})
+		}
+		sort.Slice(result.Members, func(i, j int) bool { return result.Members[i].Nick < result.Members[j].Nick })
+	}
+	return
+}
+
+// GET /v1/channels
+func apiListChannelsHandler(req *apiRequest) {
+	channels := req.server.channels.Channels()
+	result := make([]apiChannel, 0, len(channels))
+	for _, channel := range channels {
+		result = append(result, makeAPIChannel(channel, false))
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	req.respond(result)
+}
+
+// GET /v1/channels/{channel}
+func apiChannelHandler(req *apiRequest) {
+	channel := req.server.channels.Get(req.r.PathValue("channel"))
+	if channel == nil {
+		req.fail(http.StatusNotFound, errors.New("no such channel"))
+		return
+	}
+	req.respond(makeAPIChannel(channel, true))
+}
+
+type apiKline struct {
+	Mask       string    `json:"mask"`
+	Reason     string    `json:"reason"`
+	OperReason string    `json:"oper_reason,omitempty"`
+	OperName   string    `json:"oper_name"`
+	Created    time.Time `json:"created"`
+	Expires    time.Time `json:"expires,omitempty"`
+}
+
+// GET /v1/klines
+func apiListKlinesHandler(req *apiRequest) {
+	bans := req.server.klines.AllBans()
+	result := make([]apiKline, 0, len(bans))
+	for mask, info := range bans {
+		kline := apiKline{
+			Mask:       mask,
+			Reason:     info.Reason,
+			OperReason: info.OperReason,
+			OperName:   info.OperName,
+			Created:    info.TimeCreated,
+		}
+		if info.Duration != 0 {
+			kline.Expires = info.TimeCreated.Add(info.Duration)
+		}
+		result = append(result, kline)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Mask < result[j].Mask })
+	req.respond(result)
+}
+
+// POST /v1/klines {"mask": "*!*@bad.example", "duration": "1d", "reason": "...",
+// "oper_reason": "...", "kill": true}
+func apiAddKlineHandler(req *apiRequest) {
+	var body struct {
+		Mask       string `json:"mask"`
+		Duration   string `json:"duration"`
+		Reason     string `json:"reason"`
+		OperReason string `json:"oper_reason"`
+		Kill       bool   `json:"kill"`
+	}
+	if !req.decode(&body) {
+		return
+	}
+	var duration time.Duration
+	if body.Duration != "" {
+		parsed, err := custime.ParseDuration(body.Duration)
+		if err != nil {
+			req.fail(http.StatusBadRequest, errors.New("invalid duration"))
+			return
+		}
+		duration = time.Duration(parsed)
+	}
+	mask, err := CanonicalizeMaskWildcard(body.Mask)
+	if err != nil {
+		req.fail(http.StatusBadRequest, errors.New("invalid mask"))
+		return
+	}
+	matcher, err := utils.CompileGlob(mask, false)
+	if err != nil {
+		req.fail(http.StatusBadRequest, errors.New("invalid mask"))
+		return
+	}
+	reason := body.Reason
+	if reason == "" {
+		reason = "No reason given"
+	}
+
+	server := req.server
+	operName := req.actor()
+	if err := server.klines.AddMask(mask, duration, reason, body.OperReason, operName); err != nil {
+		server.logger.Error("server", "couldn't save K-Line", err.Error())
+		req.fail(http.StatusInternalServerError, errors.New("could not save K-Line"))
+		return
+	}
+	server.snomasks.Send(sno.LocalXline, fmt.Sprintf(ircfmt.Unescape("%s$r added K-Line for %s"), operName, mask))
+	req.audit("KLINE", mask, reason)
+
+	killed := []string{}
+	if body.Kill {
+		for _, client := range server.clients.AllClients() {
+			for _, clientMask := range client.AllNickmasks() {
+				if matcher.MatchString(clientMask) {
+					killed = append(killed, client.Nick())
+					client.Quit(fmt.Sprintf(client.t("You have been banned from this server (%s)"), reason), nil)
+					client.destroy(nil)
+					break
+				}
+			}
+		}
+		if len(killed) != 0 {
+			sort.Strings(killed)
+			server.snomasks.Send(sno.LocalKills, fmt.Sprintf(ircfmt.Unescape("%s killed %d clients with a KLINE $c[grey][$r%s$c[grey]]"), operName, len(killed), strings.Join(killed, ", ")))
+		}
+	}
+	req.respond(map[string]any{"mask": mask, "killed": killed})
+}
+
+// DELETE /v1/klines/{mask}
+func apiRemoveKlineHandler(req *apiRequest) {
+	mask, err := CanonicalizeMaskWildcard(req.r.PathValue("mask"))
+	if err != nil {
+		req.fail(http.StatusBadRequest, errors.New("invalid mask"))
+		return
+	}
+	server := req.server
+	if err := server.klines.RemoveMask(mask); err != nil {
+		req.fail(http.StatusNotFound, err)
+		return
+	}
+	server.snomasks.Send(sno.LocalXline, fmt.Sprintf(ircfmt.Unescape("%s$r removed K-Line for %s"), req.actor(), mask))
+	req.audit("UNKLINE", mask, "")
+	req.respond(map[string]bool{"success": true})
+}
+
+type apiAccount struct {
+	Name         string    `json:"name"`
+	RegisteredAt time.Time `json:"registered_at"`
+	Verified     bool      `json:"verified"`
+	Email        string    `json:"email,omitempty"`
+	Suspended    bool      `json:"suspended"`
+	Nicks        []string  `json:"nicks,omitempty"`
+	Channels     []string  `json:"channels,omitempty"`
+}
+
+// GET /v1/accounts/{account}
+func apiAccountHandler(req *apiRequest) {
+	server := req.server
+	account, err := server.accounts.LoadAccount(req.r.PathValue("account"))
+	if err == errAccountDoesNotExist {
+		req.fail(http.StatusNotFound, err)
+		return
+	} else if err != nil {
+		req.fail(http.StatusInternalServerError, err)
+		return
+	}
+	req.respond(apiAccount{
+		Name:         account.Name,
+		RegisteredAt: account.RegisteredAt,
+		Verified:     account.Verified,
+		Email:        account.Settings.Email,
+		Suspended:    account.Suspended != nil,
+		Nicks:        account.AdditionalNicks,
+		Channels:     server.channels.ChannelsForAccount(account.NameCasefolded),
+	})
+}
+
+// POST /v1/accounts {"name": "alice", "passphrase": "..."}
+func apiRegisterAccountHandler(req *apiRequest) {
+	var body struct {
+		Name       string `json:"name"`
+		Passphrase string `json:"passphrase"`
+	}
+	if !req.decode(&body) {
+		return
+	}
+	server := req.server
+	switch err := server.accounts.SARegister(body.Name, body.Passphrase); err {
+	case nil:
+		server.snomasks.Send(sno.LocalAccounts, fmt.Sprintf(ircfmt.Unescape("%s registered account $c[grey][$r%s$c[grey]]"), req.actor(), body.Name))
+		req.audit("NS SAREGISTER", body.Name, "")
+		req.respond(map[string]bool{"success": true})
+	case errAccountAlreadyRegistered, errAccountAlreadyVerified, errNameReserved:
+		req.fail(http.StatusConflict, errors.New("account already exists"))
+	case errAccountBadPassphrase, errAccountCreation:
+		req.fail(http.StatusBadRequest, err)
+	default:
+		server.logger.Error("server", "couldn't register account", body.Name, err.Error())
+		req.fail(http.StatusInternalServerError, errors.New("could not register account"))
+	}
+}
+
+// DELETE /v1/accounts/{account}
+func apiUnregisterAccountHandler(req *apiRequest) {
+	server := req.server
+	accountName := req.r.PathValue("account")
+	switch err := server.accounts.Unregister(accountName, false); err {
+	case nil:
+		server.snomasks.Send(sno.LocalAccounts, fmt.Sprintf(ircfmt.Unescape("%s unregistered account $c[grey][$r%s$c[grey]]"), req.actor(), accountName))
+		req.audit("NS UNREGISTER", accountName, "")
+		req.respond(map[string]bool{"success": true})
+	case errAccountDoesNotExist:
+		req.fail(http.StatusNotFound, err)
+	default:
+		req.fail(http.StatusInternalServerError, err)
+	}
+}
+
+// POST /v1/accounts/{account}/suspend {"duration": "1w", "reason": "..."}
+func apiSuspendAccountHandler(req *apiRequest) {
+	var body struct {
+		Duration string `json:"duration"`
+		Reason   string `json:"reason"`
+	}
+	if !req.decode(&body) {
+		return
+	}
+	var duration time.Duration
+	if body.Duration != "" {
+		parsed, err := custime.ParseDuration(body.Duration)
+		if err != nil {
+			req.fail(http.StatusBadRequest, errors.New("invalid duration"))
+			return
+		}
+		duration = time.Duration(parsed)
+	}
+	server := req.server
+	accountName := req.r.PathValue("account")
+	switch err := server.accounts.Suspend(accountName, duration, req.actor(), body.Reason); err {
+	case nil:
+		req.audit("NS SUSPEND", accountName, body.Reason)
+		req.respond(map[string]bool{"success": true})
+	case errAccountDoesNotExist:
+		req.fail(http.StatusNotFound, err)
+	default:
+		req.fail(http.StatusInternalServerError, err)
+	}
+}
+
+// DELETE /v1/accounts/{account}/suspend
+func apiUnsuspendAccountHandler(req *apiRequest) {
+	server := req.server
+	accountName := req.r.PathValue("account")
+	switch err := server.accounts.Unsuspend(accountName); err {
+	case nil:
+		req.audit("NS UNSUSPEND", accountName, "")
+		req.respond(map[string]bool{"success": true})
+	case errAccountDoesNotExist:
+		req.fail(http.StatusNotFound, err)
+	default:
+		req.fail(http.StatusInternalServerError, err)
+	}
+}
+
+// POST /v1/broadcast {"message": "..."}
+func apiBroadcastHandler(req *apiRequest) {
+	var body struct {
+		Message string `json:"message"`
+	}
+	if !req.decode(&body) {
+		return
+	}
+	message, ok := makeRelayedSplitMessage(body.Message)
+	if !ok {
+		req.fail(http.StatusBadRequest, errors.New("empty message"))
+		return
+	}
+	server := req.server
+	req.audit("BROADCAST", "", body.Message)
+	for _, client := range server.clients.AllClients() {
+		nick := client.Nick()
+		for _, session := range client.Sessions() {
+			session.sendSplitMsgFromClientInternal(false, server.name, "*", false, nil, "NOTICE", nick, message)
+		}
+	}
+	req.respond(map[string]bool{"success": true})
+}
+
+// POST /v1/rehash
+func apiRehashHandler(req *apiRequest) {
+	server := req.server
+	server.logger.Info("server", "Rehash requested by", req.actor())
+	if err := server.rehash(); err != nil {
+		req.fail(http.StatusInternalServerError, err)
+		return
+	}
+	server.snomasks.Send(sno.LocalOpers, fmt.Sprintf(ircfmt.Unescape("%s rehashed the server configuration"), req.actor()))
+	req.audit("REHASH", "", "")
+	req.respond(map[string]bool{"success": true})
+}
+
+// POST /v1/shutdown {"reason": "..."}
+func apiShutdownHandler(req *apiRequest) {
+	var body struct {
+		Reason string `json:"reason"`
+	}
+	if !req.decode(&body) {
+		return
+	}
+	server := req.server
+	server.logger.Info("server", "Shutdown requested by", req.actor(), body.Reason)
+	server.snomasks.Send(sno.LocalOpers, fmt.Sprintf(ircfmt.Unescape("%s is shutting down the server"), req.actor()))
+	req.audit("DIE", "", body.Reason)
+	req.respond(map[string]bool{"success": true})
+	server.Die(body.Reason)
+}
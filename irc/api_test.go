@@ -0,0 +1,75 @@
+package irc
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ergochat/ergo/irc/utils"
+)
+
+func TestAPIAuthorization(t *testing.T) {
+	config := &Config{}
+	config.API.Tokens = map[string]APITokenConfig{
+		"dashboard": {Token: "0123456789abcdef", class: &OperClass{Capabilities: utils.SetLiteral("kill")}},
+		"reader":    {Token: "fedcba9876543210", class: &OperClass{Capabilities: utils.SetLiteral("api-read")}},
+	}
+	server := &Server{}
+	server.config.Store(config)
+	mux := http.NewServeMux()
+	server.registerAPIHandlers(mux, false)
+
+	do := func(method, path, token string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, nil)
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+		return w
+	}
+
+	if w := do("GET", "/v1/stats", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("missing token: expected 401, got %d", w.Code)
+	}
+	if w := do("GET", "/v1/stats", "0123456789abcdeX"); w.Code != http.StatusUnauthorized {
+		t.Errorf("invalid token: expected 401, got %d", w.Code)
+	}
+	w := do("GET", "/v1/stats", "0123456789abcdef")
+	var stats apiStats
+	if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &stats) != nil || stats.Version != Ver {
+		t.Errorf("unexpected stats response %d %s", w.Code, w.Body.String())
+	}
+	// the token's oper class lacks the ban capability
+	if w := do("GET", "/v1/klines", "0123456789abcdef"); w.Code != http.StatusForbidden {
+		t.Errorf("missing capability: expected 403, got %d", w.Code)
+	}
+	// reading clients and channels requires api-read
+	for _, path := range []string{"/v1/clients", "/v1/clients/alice", "/v1/channels", "/v1/channels/%23test"} {
+		if w := do("GET", path, "0123456789abcdef"); w.Code != http.StatusForbidden {
+			t.Errorf("%s without api-read: expected 403, got %d", path, w.Code)
+		}
+	}
+	if w := do("GET", "/v1/clients/alice", "fedcba9876543210"); w.Code != http.StatusNotFound {
+		t.Errorf("nonexistent client with api-read: expected 404, got %d %s", w.Code, w.Body.String())
+	}
+	if w := do("GET", "/v1/nonexistent", "0123456789abcdef"); w.Code != http.StatusNotFound {
+		t.Errorf("unknown endpoint: expected 404, got %d", w.Code)
+	}
+}
+
+func TestControlSocketHandlers(t *testing.T) {
+	server := &Server{klines: &KLineManager{}}
+	server.config.Store(&Config{})
+	mux := http.NewServeMux()
+	server.registerAPIHandlers(mux, true)
+
+	// requests over the control socket don't need a token or capabilities
+	r := httptest.NewRequest("GET", "/v1/klines", nil)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Errorf("expected 200, got %d %s", w.Code, w.Body.String())
+	}
+}
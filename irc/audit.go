@@ -0,0 +1,119 @@
+package irc
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ergochat/ergo/irc/utils"
+)
+
+// privileged operator actions are recorded to an audit log: every entry is
+// logged with the "audit" log type (so it can be routed to a dedicated,
+// append-only file), and the most recent entries are kept in memory,
+// where they can be queried with the AUDIT command.
+
+const (
+	auditLogSize = 512
+)
+
+// AuditEntry is a single privileged action taken by an operator.
+type AuditEntry struct {
+	Time   time.Time
+	Actor  string
+	Action string
+	Target string
+	Reason string
+}
+
+func (entry *AuditEntry) String() string {
+	var buf strings.Builder
+	fmt.Fprintf(&buf, "%s %s ran %s", entry.Time.Format(IRCv3TimestampFormat), entry.Actor, entry.Action)
+	if entry.Target != "" {
+		fmt.Fprintf(&buf, " on %s", entry.Target)
+	}
+	if entry.Reason != "" {
+		fmt.Fprintf(&buf, " [reason: %s]", entry.Reason)
+	}
+	return buf.String()
+}
+
+// AuditLog is a fixed-size ring buffer of the most recent audit entries.
+type AuditLog struct {
+	sync.Mutex
+	entries []AuditEntry
+	next    int
+}
+
+func (log *AuditLog) Add(entry AuditEntry) {
+	log.Lock()
+	defer log.Unlock()
+
+	if len(log.entries) < auditLogSize {
+		log.entries = append(log.entries, entry)
+	} else {
+		log.entries[log.next] = entry
+	}
+	log.next = (log.next + 1) % auditLogSize
+}
+
+// Recent returns up to `limit` of the most recent entries, oldest first.
+func (log *AuditLog) Recent(limit int) (result []AuditEntry) {
+	log.Lock()
+	defer log.Unlock()
+
+	if limit <= 0 || len(log.entries) < limit {
+		limit = len(log.entries)
+	}
+	result = make([]AuditEntry, 0, limit)
+	for i := len(log.entries) - limit; i < len(log.entries); i++ {
+		// when the buffer is full, the oldest entry is at log.next
+		result = append(result, log.entries[(log.next+i)%len(log.entries)])
+	}
+	return
+}
+
+// audit records a privileged action taken by `client`
+func (server *Server) audit(client *Client, action, target, reason string) {
+	actor := client.Nick()
+	var operName string
+	if oper := client.Oper(); oper != nil {
+		operName = oper.Name
+		actor = fmt.Sprintf("%s [%s]", actor, operName)
+	}
+	entry := server.makeAuditEntry(actor, action, target, reason)
+	event := makeWebhookEvent(webhookEventOper, "", client.Details(), utils.SplitMessage{Time: entry.Time})
+	event.Action, event.Oper, event.Target, event.Text = action, operName, target, reason
+	server.recordAudit(entry, event)
+}
+
+// auditAPI records a privileged action taken through the HTTP API or the
+// control socket; `tokenName` is empty for the latter
+func (server *Server) auditAPI(actor, tokenName, action, target, reason string) {
+	entry := server.makeAuditEntry(actor, action, target, reason)
+	server.recordAudit(entry, WebhookEvent{
+		Type:     webhookEventOper,
+		Time:     entry.Time,
+		Action:   action,
+		APIToken: tokenName,
+		Target:   target,
+		Text:     reason,
+	})
+}
+
+func (server *Server) makeAuditEntry(actor, action, target, reason string) AuditEntry {
+	return AuditEntry{
+		Time:   time.Now().UTC(),
+		Actor:  actor,
+		Action: action,
+		Target: target,
+		Reason: reason,
+	}
+}
+
+func (server *Server) recordAudit(entry AuditEntry, event WebhookEvent) {
+	server.auditLog.Add(entry)
+	server.logger.Info("audit", entry.Actor, entry.Action, entry.Target, entry.Reason)
+	server.webhooks.Dispatch(event)
+}
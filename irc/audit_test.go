@@ -0,0 +1,31 @@
+package irc
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestAuditLogRecent(t *testing.T) {
+	var log AuditLog
+	if len(log.Recent(10)) != 0 {
+		t.Errorf("empty log should have no entries")
+	}
+
+	for i := 0; i < auditLogSize+10; i++ {
+		log.Add(AuditEntry{Action: fmt.Sprintf("%d", i)})
+	}
+	recent := log.Recent(3)
+	if len(recent) != 3 {
+		t.Fatalf("expected 3 entries, got %d", len(recent))
+	}
+	for i, entry := range recent {
+		if expected := fmt.Sprintf("%d", auditLogSize+7+i); entry.Action != expected {
+			t.Errorf("expected entry %s, got %s", expected, entry.Action)
+		}
+	}
+
+	all := log.Recent(0)
+	if len(all) != auditLogSize || all[0].Action != "10" {
+		t.Errorf("expected %d entries starting with 10, got %d starting with %s", auditLogSize, len(all), all[0].Action)
+	}
+}
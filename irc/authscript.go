@@ -4,14 +4,34 @@
 package irc
 
 import (
+	"bytes"
+	"context"
 	"crypto/x509"
 	"encoding/json"
 	"encoding/pem"
 	"fmt"
+	"io"
 	"net"
+	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/ergochat/ergo/irc/oauth2"
 	"github.com/ergochat/ergo/irc/utils"
+	"github.com/ergochat/ergo/irc/webhook"
+)
+
+const (
+	maxAuthScriptResponse = 65536
+)
+
+var (
+	// don't follow redirects, which could send the (signed) credentials elsewhere
+	authScriptClient = &http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
 )
 
 // JSON-serializable input and output types for the script
@@ -31,7 +51,7 @@ type AuthScriptOutput struct {
 	Error       string `json:"error"`
 }
 
-func CheckAuthScript(sem utils.Semaphore, config ScriptConfig, input AuthScriptInput) (output AuthScriptOutput, err error) {
+func CheckAuthScript(sem utils.Semaphore, config AuthScriptConfig, input AuthScriptInput) (output AuthScriptOutput, err error) {
 	if sem != nil {
 		sem.Acquire()
 		defer sem.Release()
@@ -49,7 +69,12 @@ func CheckAuthScript(sem utils.Semaphore, config ScriptConfig, input AuthScriptI
 	if err != nil {
 		return
 	}
-	outBytes, err := RunScript(config.Command, config.Args, inputBytes, config.Timeout, config.KillTimeout)
+	var outBytes []byte
+	if config.URL != "" {
+		outBytes, err = postAuthScript(config, inputBytes)
+	} else {
+		outBytes, err = RunScript(config.Command, config.Args, inputBytes, config.Timeout, config.KillTimeout)
+	}
 	if err != nil {
 		return
 	}
@@ -64,6 +89,34 @@ func CheckAuthScript(sem utils.Semaphore, config ScriptConfig, input AuthScriptI
 	return
 }
 
+// postAuthScript sends the input for the authentication script to an HTTP
+// endpoint instead, returning the response body. the request is signed with
+// the configured secret, in the same way as webhook deliveries.
+func postAuthScript(config AuthScriptConfig, input []byte) (output []byte, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), config.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, config.URL, bytes.NewReader(input))
+	if err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "ergo-auth-script")
+	if config.Secret != "" {
+		timestamp := time.Now().Unix()
+		req.Header.Set(webhook.TimestampHeader, strconv.FormatInt(timestamp, 10))
+		req.Header.Set(webhook.SignatureHeader, webhook.Sign(config.Secret, timestamp, input))
+	}
+	resp, err := authScriptClient.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Authentication endpoint returned HTTP status %d", resp.StatusCode)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, maxAuthScriptResponse))
+}
+
 type IPScriptResult uint
 
 const (
@@ -0,0 +1,62 @@
+package irc
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/ergochat/ergo/irc/webhook"
+)
+
+func TestAuthScriptURL(t *testing.T) {
+	var redirected bool
+	elsewhere := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		redirected = true
+		json.NewEncoder(w).Encode(AuthScriptOutput{AccountName: "alice", Success: true})
+	}))
+	defer elsewhere.Close()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, elsewhere.URL, http.StatusTemporaryRedirect)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		timestamp, _ := strconv.ParseInt(r.Header.Get(webhook.TimestampHeader), 10, 64)
+		if !webhook.Verify("secret", timestamp, body, r.Header.Get(webhook.SignatureHeader)) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var input AuthScriptInput
+		json.Unmarshal(body, &input)
+		json.NewEncoder(w).Encode(AuthScriptOutput{
+			AccountName: input.AccountName,
+			Success:     input.Passphrase == "hunter2",
+		})
+	}))
+	defer server.Close()
+
+	config := AuthScriptConfig{URL: server.URL, Secret: "secret"}
+	config.Timeout = time.Second
+	output, err := CheckAuthScript(nil, config, AuthScriptInput{AccountName: "alice", Passphrase: "hunter2"})
+	if err != nil || !output.Success || output.AccountName != "alice" {
+		t.Errorf("unexpected result %v %v", output, err)
+	}
+	output, err = CheckAuthScript(nil, config, AuthScriptInput{AccountName: "alice", Passphrase: "wrong"})
+	if err != nil || output.Success {
+		t.Errorf("unexpected result %v %v", output, err)
+	}
+	config.Secret = "other"
+	if _, err = CheckAuthScript(nil, config, AuthScriptInput{AccountName: "alice", Passphrase: "hunter2"}); err == nil {
+		t.Errorf("expected an error for a rejected request")
+	}
+
+	// redirects are not followed
+	config.URL = server.URL + "/redirect"
+	if output, err = CheckAuthScript(nil, config, AuthScriptInput{AccountName: "alice", Passphrase: "wrong"}); err == nil || output.Success || redirected {
+		t.Errorf("redirect should not have been followed: %v %v", output, err)
+	}
+}
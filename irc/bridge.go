@@ -0,0 +1,119 @@
+package irc
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/ergochat/ergo/irc/utils"
+)
+
+// helpers shared by the built-in bridges (Discord, Matrix), which relay
+// messages from external users into IRC channels in the manner of RELAYMSG
+
+// bridgeRelayNick computes a relaymsg nick of the form <name><separator><suffix>,
+// using the first of `names` that yields a valid nick, or else `fallback`
+func bridgeRelayNick(config *Config, nickSuffix, fallback string, names ...string) string {
+	suffix := config.Server.Relaymsg.Separators[:1] + nickSuffix
+	maxLen := config.Limits.NickLen - len(suffix)
+	for _, name := range names {
+		name = sanitizeRelayName(config, name, maxLen)
+		if name == "" {
+			continue
+		}
+		if _, err := CasefoldName(name + suffix); err == nil {
+			return name + suffix
+		}
+	}
+	// the fallback is derived from an ID on the external service and is always valid
+	return fallback + suffix
+}
+
+// sanitizeRelayName removes characters that can't appear in a relayed nick
+// (including the relaymsg separators, so the nick remains unambiguous)
+func sanitizeRelayName(config *Config, name string, maxLen int) string {
+	var buf strings.Builder
+	for _, r := range name {
+		switch {
+		case unicode.IsSpace(r):
+			r = '_'
+		case !unicode.IsPrint(r) || strings.ContainsRune(protocolBreakingNameCharacters, r) || strings.ContainsRune(config.Server.Relaymsg.Separators, r):
+			continue
+		}
+		if buf.Len() == 0 && strings.ContainsRune("#~&@%+-_", r) {
+			continue
+		}
+		if maxLen < buf.Len()+utf8.RuneLen(r) {
+			break
+		}
+		buf.WriteRune(r)
+	}
+	return buf.String()
+}
+
+// makeRelayedSplitMessage converts a (possibly multiline, possibly long)
+// message from an external service into a SplitMessage
+func makeRelayedSplitMessage(text string) (message utils.SplitMessage, ok bool) {
+	// leave room for the prefix and the rest of the PRIVMSG line
+	const maxChunkLen = 400
+	text = strings.TrimRight(text, "\n")
+	if strings.TrimSpace(text) == "" {
+		return message, false
+	}
+	if len(text) <= maxChunkLen && !strings.Contains(text, "\n") {
+		return utils.MakeMessage(text), true
+	}
+	message.Msgid = utils.GenerateSecretToken()
+	message.SetTime()
+	for _, line := range strings.Split(text, "\n") {
+		concat := false
+		for maxChunkLen < len(line) {
+			split := maxChunkLen
+			for 0 < split && !utf8.RuneStart(line[split]) {
+				split--
+			}
+			message.Append(line[:split], concat)
+			line = line[split:]
+			concat = true
+		}
+		message.Append(line, concat)
+	}
+	return message, true
+}
+
+// bridgeRelayMessage relays a message from an external user into a channel,
+// unless the relay nick is invalid or muted
+func bridgeRelayMessage(channel *Channel, nick, relayer string, message utils.SplitMessage) error {
+	config := channel.server.Config()
+	cfnick, err := CasefoldName(nick)
+	if err != nil {
+		return err
+	}
+	if channel.relayNickMuted(cfnick) {
+		return nil
+	}
+	ident := config.Server.CoerceIdent
+	if ident == "" {
+		ident = "~u"
+	}
+	nuh := fmt.Sprintf("%s!%s@%s", nick, ident, relayer)
+	channel.relayMessage(nuh, relayer, nil, message, nil)
+	return nil
+}
+
+// flattenSplitMessage reassembles the text of a (possibly multiline) message,
+// using newlines to separate lines
+func flattenSplitMessage(message utils.SplitMessage) string {
+	if message.Is512() {
+		return message.Message
+	}
+	var buf strings.Builder
+	for i, messagePair := range message.Split {
+		if i != 0 && !messagePair.Concat {
+			buf.WriteByte('\n')
+		}
+		buf.WriteString(messagePair.Message)
+	}
+	return buf.String()
+}
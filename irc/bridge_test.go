@@ -0,0 +1,28 @@
+package irc
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMakeRelayedSplitMessage(t *testing.T) {
+	if _, ok := makeRelayedSplitMessage(" \n"); ok {
+		t.Errorf("blank message should not be relayed")
+	}
+	message, ok := makeRelayedSplitMessage("hello")
+	if !ok || !message.Is512() || message.Message != "hello" {
+		t.Errorf("unexpected message %#v", message)
+	}
+
+	long := strings.Repeat("é", 300) // 600 bytes
+	message, ok = makeRelayedSplitMessage("first\n" + long)
+	if !ok || message.Is512() || len(message.Split) != 3 || message.Msgid == "" {
+		t.Fatalf("unexpected message %#v", message)
+	}
+	if message.Split[0].Message != "first" || message.Split[1].Concat || !message.Split[2].Concat {
+		t.Errorf("unexpected split %#v", message.Split)
+	}
+	if message.Split[1].Message+message.Split[2].Message != long {
+		t.Errorf("long line was not split on a character boundary")
+	}
+}
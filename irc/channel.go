@@ -22,6 +22,8 @@ import (
 type ChannelSettings struct {
 	History     HistoryStatus
 	QueryCutoff HistoryCutoff
+	// if non-nil, overrides the server default for autoreplay-on-join
+	AutoreplayLines *int
 }
 
 // Channel represents a channel that clients can join.
@@ -41,6 +43,7 @@ type Channel struct {
 	topic             string
 	topicSetBy        string
 	topicSetTime      time.Time
+	lastKnocks        map[string]time.Time // throttle key (account or IP) to time of last KNOCK
 	userLimit         int
 	accountToUMode    map[string]modes.Mode
 	history           history.Buffer
@@ -770,20 +773,20 @@ func (channel *Channel) Join(client *Client, key string, isSajoin bool, rb *Resp
 
 		// #1901: +h and up exempt from all restrictions, but +v additionally exempts from +i:
 		if channel.flags.HasMode(modes.InviteOnly) && persistentMode == 0 &&
-			!channel.lists[modes.InviteMask].Match(details.nickMaskCasefolded) {
+			!channel.lists[modes.InviteMask].MatchClient(&details) {
 			return errInviteOnly, forward
 		}
 
-		if channel.lists[modes.BanMask].Match(details.nickMaskCasefolded) &&
-			!channel.lists[modes.ExceptMask].Match(details.nickMaskCasefolded) &&
-			!channel.lists[modes.InviteMask].Match(details.nickMaskCasefolded) {
+		if channel.lists[modes.BanMask].MatchClient(&details) &&
+			!channel.lists[modes.ExceptMask].MatchClient(&details) &&
+			!channel.lists[modes.InviteMask].MatchClient(&details) {
 			// do not forward people who are banned:
 			return errBanned, ""
 		}
 
 		if details.account == "" &&
 			(channel.flags.HasMode(modes.RegisteredOnly) || channel.server.Defcon() <= 2) &&
-			!channel.lists[modes.InviteMask].Match(details.nickMaskCasefolded) {
+			!channel.lists[modes.InviteMask].MatchClient(&details) {
 			return errRegisteredOnly, forward
 		}
 	}
@@ -834,6 +837,7 @@ func (channel *Channel) Join(client *Client, key string, isSajoin bool, rb *Resp
 		}
 		histItem.Params[0] = details.realname
 		channel.AddHistoryItem(histItem, details.account)
+		channel.server.webhooks.Dispatch(makeWebhookEvent(webhookEventJoin, chname, details, message))
 	}
 
 	if rb == nil {
@@ -906,6 +910,35 @@ func (channel *Channel) Join(client *Client, key string, isSajoin bool, rb *Resp
 	return nil, ""
 }
 
+// effectiveAutoreplayLines computes the number of lines to autoreplay on join:
+// the user's own setting takes precedence over the channel's, which takes
+// precedence over the server default. Custom values are capped at the
+// CHATHISTORY maximum.
+func effectiveAutoreplayLines(config *Config, accountSetting, channelSetting *int) (replayLimit int) {
+	custom := accountSetting
+	if custom == nil {
+		custom = channelSetting
+	}
+	if custom == nil {
+		return config.History.AutoreplayOnJoin
+	}
+	replayLimit = *custom
+	if config.History.ChathistoryMax < replayLimit {
+		replayLimit = config.History.ChathistoryMax
+	}
+	return
+}
+
+// autoreplaySelectors returns the bounds for join autoreplay: the most recent
+// lines, but none older than the autoreplay window. start is after end, so
+// that Between returns the lines closest to start (i.e., the latest ones).
+func autoreplaySelectors(config *Config, now time.Time) (start, end history.Selector) {
+	if window := time.Duration(config.History.AutoreplayWindow); window != 0 {
+		start.Time, end.Time = now, now.Add(-window)
+	}
+	return
+}
+
 func (channel *Channel) autoReplayHistory(client *Client, rb *ResponseBuffer, skipMsgid string) {
 	// autoreplay any messages as necessary
 	var items []history.Item
@@ -929,21 +962,13 @@ func (channel *Channel) autoReplayHistory(client *Client, rb *ResponseBuffer, sk
 			items, _ = seq.Between(history.Selector{Time: start}, history.Selector{Time: end}, zncMax)
 		}
 	} else if !rb.session.HasHistoryCaps() {
-		var replayLimit int
-		customReplayLimit := client.AccountSettings().AutoreplayLines
-		if customReplayLimit != nil {
-			replayLimit = *customReplayLimit
-			maxLimit := channel.server.Config().History.ChathistoryMax
-			if maxLimit < replayLimit {
-				replayLimit = maxLimit
-			}
-		} else {
-			replayLimit = channel.server.Config().History.AutoreplayOnJoin
-		}
+		config := channel.server.Config()
+		replayLimit := effectiveAutoreplayLines(config, client.AccountSettings().AutoreplayLines, channel.Settings().AutoreplayLines)
 		if 0 < replayLimit {
 			_, seq, _ := channel.server.GetHistorySequence(channel, client, "")
 			if seq != nil {
-				items, _ = seq.Between(history.Selector{}, history.Selector{}, replayLimit)
+				start, end := autoreplaySelectors(config, time.Now().UTC())
+				items, _ = seq.Between(start, end, replayLimit)
 			}
 		}
 	}
@@ -1043,6 +1068,7 @@ func (channel *Channel) Part(client *Client, message string, rb *ResponseBuffer)
 			Message:     splitMessage,
 			IsBot:       isBot,
 		}, details.account)
+		channel.server.webhooks.Dispatch(makeWebhookEvent(webhookEventPart, chname, details, splitMessage))
 	}
 
 	client.server.logger.Debug("channels", fmt.Sprintf("%s left channel %s", details.nick, chname))
@@ -1228,6 +1254,7 @@ func (channel *Channel) SetTopic(client *Client, topic string, rb *ResponseBuffe
 		Message:     message,
 		IsBot:       isBot,
 	}, details.account)
+	channel.server.webhooks.Dispatch(makeWebhookEvent(webhookEventTopic, chname, details, message))
 
 	channel.MarkDirty(IncludeTopic)
 }
@@ -1398,7 +1425,13 @@ func (channel *Channel) SendSplitMessage(command string, minPrefixMode modes.Mod
 			continue
 		}
 
-		for _, session := range member.Sessions() {
+		memberSessions := member.Sessions()
+		if len(memberSessions) == 0 && histType == history.Privmsg && member != client && messageHighlights(member.Nick(), message) {
+			// detached always-on client: send a push notification for the highlight
+			channel.server.webPush.Notify(member, makePushMessage(details.nickMask, details.accountName, command, chname, message))
+		}
+
+		for _, session := range memberSessions {
 			if session == rb.session {
 				continue // we already sent echo-message, if applicable
 			}
@@ -1411,8 +1444,17 @@ func (channel *Channel) SendSplitMessage(command string, minPrefixMode modes.Mod
 		}
 	}
 
-	// #959: don't save STATUSMSG (or OpModerated)
+	// #959: don't save (or bridge) STATUSMSG (or OpModerated)
 	if minPrefixMode == modes.Mode(0) {
+		if histType == history.Privmsg {
+			channel.server.discord.RelayFromIRC(channel, details.nick, message)
+			channel.server.matrix.RelayFromIRC(channel, details.nick, message)
+		}
+		if histType != history.Tagmsg {
+			event := makeWebhookEvent(webhookEventMessage, channel.Name(), details, message)
+			event.Command = command
+			channel.server.webhooks.Dispatch(event)
+		}
 		channel.AddHistoryItem(history.Item{
 			Type:        histType,
 			Message:     message,
@@ -1424,6 +1466,52 @@ func (channel *Channel) SendSplitMessage(command string, minPrefixMode modes.Mod
 	}
 }
 
+// relayMessage delivers a message relayed on behalf of `nuh` (e.g., by RELAYMSG
+// or a bridge) to the channel's members; `relayer` is the nick of the relaying
+// client, and `rb` (which may be nil) is the relaying client's response buffer.
+func (channel *Channel) relayMessage(nuh, relayer string, clientOnlyTags map[string]string, message utils.SplitMessage, rb *ResponseBuffer) {
+	channel.AddHistoryItem(history.Item{
+		Type:    history.Privmsg,
+		Message: message,
+		Nick:    nuh,
+	}, "")
+
+	// 3 possibilities for tags:
+	// no tags, the relaymsg tag only, or the relaymsg tag together with all client-only tags
+	relayTag := map[string]string{
+		caps.RelaymsgTagName: relayer,
+	}
+	var fullTags map[string]string
+	if len(clientOnlyTags) == 0 {
+		fullTags = relayTag
+	} else {
+		fullTags = make(map[string]string, 1+len(clientOnlyTags))
+		fullTags[caps.RelaymsgTagName] = relayer
+		for t, v := range clientOnlyTags {
+			fullTags[t] = v
+		}
+	}
+
+	// actually send the message
+	channelName := channel.Name()
+	for _, member := range channel.Members() {
+		for _, session := range member.Sessions() {
+			var tagsToUse map[string]string
+			if session.capabilities.Has(caps.MessageTags) {
+				tagsToUse = fullTags
+			} else if session.capabilities.Has(caps.Relaymsg) {
+				tagsToUse = relayTag
+			}
+
+			if rb != nil && session == rb.session {
+				rb.AddSplitMessageFromClient(nuh, "*", false, tagsToUse, "PRIVMSG", channelName, message)
+			} else {
+				session.sendSplitMsgFromClientInternal(false, nuh, "*", false, tagsToUse, "PRIVMSG", channelName, message)
+			}
+		}
+	}
+}
+
 func (channel *Channel) applyModeToMember(client *Client, change modes.ModeChange, rb *ResponseBuffer) (applied bool, result modes.ModeChange) {
 	target := channel.server.clients.Get(change.Arg)
 	if target == nil {
@@ -1533,6 +1621,9 @@ func (channel *Channel) Kick(client *Client, target *Client, comment string, rb
 	}
 	histItem.Params[0] = targetNick
 	channel.AddHistoryItem(histItem, details.account)
+	event := makeWebhookEvent(webhookEventKick, chname, details, message)
+	event.Target = targetNick
+	channel.server.webhooks.Dispatch(event)
 
 	channel.Quit(target)
 }
@@ -1608,8 +1699,9 @@ func (channel *Channel) Invite(invitee *Client, inviter *Client, rb *ResponseBuf
 		Message: message,
 	}
 
+	notifyAll := channel.server.Config().Channels.InviteNotifyAll
 	for _, member := range channel.Members() {
-		if member == inviter || member == invitee || !channel.ClientIsAtLeast(member, modes.Halfop) {
+		if member == inviter || member == invitee || !(notifyAll || channel.ClientIsAtLeast(member, modes.Halfop)) {
 			continue
 		}
 		for _, session := range member.Sessions() {
@@ -1629,15 +1721,94 @@ func (channel *Channel) Invite(invitee *Client, inviter *Client, rb *ResponseBuf
 	inviter.addHistoryItem(invitee, item, &details, &tDetails, channel.server.Config())
 }
 
+// Knock requests an invitation to an invite-only channel on behalf of a non-member,
+// notifying the channel's operators.
+func (channel *Channel) Knock(client *Client, message string, rb *ResponseBuffer) {
+	details := client.Details()
+	knockDelay := channel.server.Config().Channels.KnockDelay
+
+	channel.stateMutex.Lock()
+	chname := channel.name
+	_, present := channel.members[client]
+	inviteOnly := channel.flags.HasMode(modes.InviteOnly)
+	banned := channel.lists[modes.BanMask].MatchClient(&details) &&
+		!channel.lists[modes.ExceptMask].MatchClient(&details) &&
+		!channel.lists[modes.InviteMask].MatchClient(&details)
+	var throttled bool
+	if !present && inviteOnly && !banned {
+		throttled = channel.checkKnockThrottle(knockThrottleKey(client, &details), time.Now().UTC(), knockDelay)
+	}
+	channel.stateMutex.Unlock()
+
+	if present {
+		rb.Add(nil, client.server.name, ERR_KNOCKONCHAN, details.nick, chname, client.t("You're already on that channel"))
+		return
+	} else if !inviteOnly {
+		rb.Add(nil, client.server.name, ERR_CHANOPEN, details.nick, chname, client.t("Channel is open"))
+		return
+	} else if banned {
+		rb.Add(nil, client.server.name, ERR_BANNEDFROMCHAN, details.nick, chname, client.t("Cannot knock on channel (you're banned)"))
+		return
+	} else if throttled {
+		rb.Add(nil, client.server.name, ERR_TOOMANYKNOCK, details.nick, chname, client.t("Too many KNOCKs (user)"))
+		return
+	}
+
+	if message == "" {
+		message = client.t("has asked for an invite")
+	}
+	for _, member := range channel.Members() {
+		if !channel.ClientIsAtLeast(member, modes.Halfop) {
+			continue
+		}
+		mnick := member.Nick()
+		for _, session := range member.Sessions() {
+			session.Send(nil, client.server.name, RPL_KNOCK, mnick, chname, details.nickMask, message)
+		}
+	}
+	rb.Add(nil, client.server.name, RPL_KNOCKDLVR, details.nick, chname, client.t("Your KNOCK has been delivered"))
+}
+
+// knockThrottleKey identifies the source of a KNOCK for throttling: the account
+// if there is one, otherwise the IP, so that changing nick doesn't evade the limit
+func knockThrottleKey(client *Client, details *ClientDetails) string {
+	if details.account != "" {
+		return "account " + details.account
+	}
+	return "ip " + client.IP().String()
+}
+
+// checkKnockThrottle records a KNOCK from `key`, returning whether it was
+// throttled. requires stateMutex to be held.
+func (channel *Channel) checkKnockThrottle(key string, now time.Time, knockDelay time.Duration) (throttled bool) {
+	if knockDelay == 0 {
+		return false
+	}
+	if last, ok := channel.lastKnocks[key]; ok && now.Sub(last) < knockDelay {
+		return true
+	}
+	if channel.lastKnocks == nil {
+		channel.lastKnocks = make(map[string]time.Time)
+	}
+	// expire stale entries so the map doesn't grow without bound
+	for k, last := range channel.lastKnocks {
+		if now.Sub(last) >= knockDelay {
+			delete(channel.lastKnocks, k)
+		}
+	}
+	channel.lastKnocks[key] = now
+	return false
+}
+
 // Uninvite rescinds a channel invitation, if the inviter can do so.
 func (channel *Channel) Uninvite(invitee *Client, inviter *Client, rb *ResponseBuffer) {
 	if !channel.flags.HasMode(modes.InviteOnly) {
-		rb.Add(nil, channel.server.name, "FAIL", "UNINVITE", "NOT_INVITE_ONLY", channel.Name(), inviter.t("Channel is not invite-only"))
+		rb.Fail("UNINVITE", "NOT_INVITE_ONLY", inviter.t("Channel is not invite-only"), channel.Name())
 		return
 	}
 
 	if !channel.ClientIsAtLeast(inviter, modes.ChannelOperator) {
-		rb.Add(nil, channel.server.name, "FAIL", "UNINVITE", "PRIVS_NEEDED", channel.Name(), inviter.t("You're not a channel operator"))
+		rb.Fail("UNINVITE", "PRIVS_NEEDED", inviter.t("You're not a channel operator"), channel.Name())
 		return
 	}
 
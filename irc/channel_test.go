@@ -0,0 +1,100 @@
+package irc
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ergochat/ergo/irc/custime"
+	"github.com/ergochat/ergo/irc/history"
+	"github.com/ergochat/ergo/irc/utils"
+)
+
+func TestKnockThrottle(t *testing.T) {
+	channel := &Channel{}
+	delay := time.Minute
+	now := time.Now()
+
+	if channel.checkKnockThrottle("ip 10.0.0.1", now, delay) {
+		t.Errorf("first KNOCK should not be throttled")
+	}
+	// a different client can still knock during the first client's window
+	if channel.checkKnockThrottle("account bob", now.Add(time.Second), delay) {
+		t.Errorf("KNOCK from a second client should not be throttled")
+	}
+	if !channel.checkKnockThrottle("ip 10.0.0.1", now.Add(2*time.Second), delay) {
+		t.Errorf("repeated KNOCK should be throttled")
+	}
+	if channel.checkKnockThrottle("ip 10.0.0.1", now.Add(delay+time.Second), delay) {
+		t.Errorf("KNOCK after the delay should not be throttled")
+	}
+	// stale entries are expired
+	if _, ok := channel.lastKnocks["account bob"]; ok {
+		t.Errorf("stale throttle entry was not expired")
+	}
+	// no throttling when the delay is disabled
+	if channel.checkKnockThrottle("ip 10.0.0.1", now.Add(delay+2*time.Second), 0) {
+		t.Errorf("KNOCK should not be throttled with no delay")
+	}
+}
+
+func TestEffectiveAutoreplayLines(t *testing.T) {
+	config := &Config{}
+	config.History.AutoreplayOnJoin = 10
+	config.History.ChathistoryMax = 100
+	intp := func(i int) *int { return &i }
+
+	cases := []struct {
+		account, channel *int
+		expected         int
+	}{
+		{nil, nil, 10},
+		{nil, intp(25), 25},
+		{intp(5), intp(25), 5},
+		// zero is a valid setting (disabling autoreplay), not "unset"
+		{intp(0), intp(25), 0},
+		{nil, intp(0), 0},
+		// custom values are capped at the CHATHISTORY maximum
+		{intp(500), nil, 100},
+		{nil, intp(500), 100},
+	}
+	for _, c := range cases {
+		if result := effectiveAutoreplayLines(config, c.account, c.channel); result != c.expected {
+			t.Errorf("effectiveAutoreplayLines(%v, %v): expected %d, got %d", c.account, c.channel, c.expected, result)
+		}
+	}
+}
+
+func TestAutoreplaySelectors(t *testing.T) {
+	now := time.Now().UTC()
+	buf := history.NewHistoryBuffer(16, 0)
+	for _, age := range []time.Duration{3 * time.Hour, 2 * time.Hour, 30 * time.Minute, 10 * time.Minute, time.Minute} {
+		buf.Add(history.Item{Type: history.Privmsg, Message: utils.SplitMessage{Time: now.Add(-age), Msgid: age.String()}})
+	}
+	seq := buf.MakeSequence("", time.Time{})
+	replay := func(config *Config, limit int) (msgids []string) {
+		start, end := autoreplaySelectors(config, now)
+		items, err := seq.Between(start, end, limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, item := range items {
+			msgids = append(msgids, item.Message.Msgid)
+		}
+		return
+	}
+
+	config := &Config{}
+	// without a window, the most recent lines are replayed, in order
+	if msgids := replay(config, 2); !reflect.DeepEqual(msgids, []string{"10m0s", "1m0s"}) {
+		t.Errorf("unexpected replay without window: %v", msgids)
+	}
+	config.History.AutoreplayWindow = custime.Duration(time.Hour)
+	if msgids := replay(config, 2); !reflect.DeepEqual(msgids, []string{"10m0s", "1m0s"}) {
+		t.Errorf("unexpected replay with window: %v", msgids)
+	}
+	// the window excludes older lines even if the limit would allow them
+	if msgids := replay(config, 10); !reflect.DeepEqual(msgids, []string{"30m0s", "10m0s", "1m0s"}) {
+		t.Errorf("unexpected replay with window: %v", msgids)
+	}
+}
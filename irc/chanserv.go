@@ -8,6 +8,7 @@ import (
 	"regexp"
 	"slices"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -183,6 +184,12 @@ by unprivileged users. Your options are:
                          channel; note that history will be effectively
                          unavailable to clients that are not always-on]
 4. 'default'            [use the server default]`,
+				`$bAUTOREPLAY-LINES$b
+'autoreplay-lines' controls the number of lines of channel history that will
+be replayed automatically to users joining the channel (users can override
+this with NickServ's own AUTOREPLAY-LINES setting). Your options are any
+positive number, 0 to disable the feature, and 'default' to use the server
+default.`,
 			},
 			enabled:   chanregEnabled,
 			minParams: 3,
@@ -580,6 +587,7 @@ func csTransferHandler(service *ircService, server *Server, client *Client, comm
 		message := fmt.Sprintf("Operator %s ran CS TRANSFER on %s to account %s", oper.Name, chname, target)
 		server.snomasks.Send(sno.LocalOpers, message)
 		server.logger.Info("opers", message)
+		server.audit(client, "CS TRANSFER", chname, "")
 	}
 	status, err := channel.Transfer(client, target, hasPrivs)
 	if err == nil {
@@ -696,6 +704,7 @@ func csPurgeAddHandler(service *ircService, client *Client, params []string, ope
 		}
 		service.Notice(rb, fmt.Sprintf(client.t("Successfully purged channel %s from the server"), chname))
 		client.server.snomasks.Send(sno.LocalChannels, fmt.Sprintf("Operator %s purged channel %s [reason: %s]", operName, chname, reason))
+		client.server.audit(client, "CS PURGE", chname, reason)
 	case errInvalidChannelName:
 		service.Notice(rb, fmt.Sprintf(client.t("Can't purge invalid channel %s"), chname))
 	default:
@@ -714,6 +723,7 @@ func csPurgeDelHandler(service *ircService, client *Client, params []string, ope
 	case nil:
 		service.Notice(rb, fmt.Sprintf(client.t("Successfully unpurged channel %s from the server"), chname))
 		client.server.snomasks.Send(sno.LocalChannels, fmt.Sprintf("Operator %s removed purge of channel %s", operName, chname))
+		client.server.audit(client, "CS UNPURGE", chname, "")
 	case errNoSuchChannel:
 		service.Notice(rb, fmt.Sprintf(client.t("Channel %s wasn't previously purged from the server"), chname))
 	default:
@@ -813,6 +823,12 @@ func displayChannelSetting(service *ircService, settingName string, settings Cha
 		}
 		service.Notice(rb, fmt.Sprintf(client.t("The stored channel history query cutoff setting is: %s"), historyCutoffToString(settings.QueryCutoff)))
 		service.Notice(rb, fmt.Sprintf(client.t("Given current server settings, the channel history query cutoff setting is: %s"), historyCutoffToString(effectiveValue)))
+	case "autoreplay-lines":
+		if settings.AutoreplayLines == nil {
+			service.Notice(rb, fmt.Sprintf(client.t("Users joining the channel will receive the server default of %d lines of autoreplayed history"), config.History.AutoreplayOnJoin))
+		} else {
+			service.Notice(rb, fmt.Sprintf(client.t("Users joining the channel will receive %d lines of autoreplayed history"), *settings.AutoreplayLines))
+		}
 	default:
 		service.Notice(rb, client.t("Invalid params"))
 	}
@@ -863,6 +879,19 @@ func csSetHandler(service *ircService, server *Server, client *Client, command s
 			break
 		}
 		channel.SetSettings(settings)
+	case "autoreplay-lines":
+		var newValue *int
+		if strings.ToLower(value) != "default" {
+			val, err_ := strconv.Atoi(value)
+			if err_ != nil || val < 0 {
+				err = errInvalidParams
+				break
+			}
+			newValue = new(int)
+			*newValue = val
+		}
+		settings.AutoreplayLines = newValue
+		channel.SetSettings(settings)
 	}
 
 	switch err {
@@ -52,13 +52,14 @@ const (
 	// RegisterTimeout is how long clients have to register before we disconnect them
 	RegisterTimeout = time.Minute
 	// DefaultIdleTimeout is how long without traffic before we send the client a PING
+	// (unless overridden by server.ping-interval)
 	DefaultIdleTimeout = time.Minute + 30*time.Second
 	// For Tor clients, we send a PING at least every 30 seconds, as a workaround for this bug
 	// (single-onion circuits will close unless the client sends data once every 60 seconds):
 	// https://bugs.torproject.org/29665
 	TorIdleTimeout = time.Second * 30
 	// This is how long a client gets without sending any message, including the PONG to our
-	// PING, before we disconnect them:
+	// PING, before we disconnect them (unless overridden by server.ping-timeout):
 	DefaultTotalTimeout = 2*time.Minute + 30*time.Second
 
 	// round off the ping interval by this much, see below:
@@ -168,6 +169,7 @@ type Session struct {
 	hostnameFinalized bool
 	isTor             bool
 	hideSTS           bool
+	dnsbl             *dnsblLookup // nil if no DNSBL check is needed
 
 	fakelag              Fakelag
 	deferredFakelagCount int
@@ -291,6 +293,8 @@ type WhoWas struct {
 	// technically not required for WHOWAS:
 	account     string
 	accountName string
+	// set when the entry is added to the WHOWAS list:
+	signoff time.Time
 }
 
 // ClientDetails is a standard set of details about a client
@@ -307,6 +311,7 @@ func (server *Server) RunClient(conn IRCConn) {
 	wConn := conn.UnderlyingConn()
 	var isBanned, requireSASL bool
 	var banMsg string
+	var dnsbl *dnsblLookup
 	realIP := utils.AddrToIP(wConn.RemoteAddr())
 	var proxiedIP net.IP
 	if wConn.Tor {
@@ -324,6 +329,10 @@ func (server *Server) RunClient(conn IRCConn) {
 		// otherwise we'll do it in ApplyProxiedIP.
 		checkScripts := proxiedIP != nil || !utils.IPInNets(realIP, config.Server.proxyAllowedFromNets)
 		isBanned, requireSASL, banMsg = server.checkBans(config, ipToCheck, checkScripts)
+		if !isBanned && checkScripts {
+			// the result is collected in tryRegister
+			dnsbl = server.startDNSBLLookup(config, ipToCheck)
+		}
 	}
 
 	if isBanned {
@@ -331,6 +340,8 @@ func (server *Server) RunClient(conn IRCConn) {
 		// but our objective here is just to close the connection out before it has a load impact on us
 		conn.WriteLine([]byte(fmt.Sprintf(errorMsg, banMsg)))
 		conn.Close()
+		server.stats.CountRegistrationFailure()
+		server.snomasks.Send(sno.LocalRejects, fmt.Sprintf("Connection rejected [ip:%s]: %s", realIP.String(), banMsg))
 		return
 	}
 
@@ -338,7 +349,7 @@ func (server *Server) RunClient(conn IRCConn) {
 
 	now := time.Now().UTC()
 	// give them 1k of grace over the limit:
-	socket := NewSocket(conn, config.Server.MaxSendQBytes)
+	socket := NewSocket(conn, config.Server.MaxSendQBytes, &server.stats)
 	client := &Client{
 		lastActive: now,
 		channels:   make(ChannelSet),
@@ -374,6 +385,7 @@ func (server *Server) RunClient(conn IRCConn) {
 		proxiedIP:  proxiedIP,
 		isTor:      wConn.Tor,
 		hideSTS:    wConn.Tor || wConn.HideSTS,
+		dnsbl:      dnsbl,
 	}
 	session.sasl.Initialize()
 	client.sessions = []*Session{session}
@@ -570,6 +582,7 @@ const (
 	authFailPass
 	authFailTorSaslRequired
 	authFailSaslRequired
+	authFailBanned
 )
 
 func (client *Client) isAuthorized(server *Server, config *Config, session *Session, forceRequireSASL bool) AuthOutcome {
@@ -728,8 +741,11 @@ func (client *Client) run(session *Session) {
 		cmd, exists := Commands[msg.Command]
 		if !exists {
 			cmd = unknownCommand
+			client.server.stats.CountCommand(unknownCommandCounter)
 		} else if invalidUtf8 {
 			cmd = invalidUtf8Command
+		} else {
+			client.server.stats.CountCommand(msg.Command)
 		}
 
 		isExiting := cmd.Run(client.server, client, session, msg)
@@ -798,20 +814,24 @@ func (client *Client) updateIdleTimer(session *Session, now time.Time) {
 	session.pingSent = false
 
 	if session.idleTimer == nil {
-		pingTimeout := DefaultIdleTimeout
-		if session.isTor {
-			pingTimeout = TorIdleTimeout
-		}
+		pingTimeout, _ := session.pingTimeouts()
 		session.idleTimer = time.AfterFunc(pingTimeout, session.handleIdleTimeout)
 	}
 }
 
-func (session *Session) handleIdleTimeout() {
-	totalTimeout := DefaultTotalTimeout
-	pingTimeout := DefaultIdleTimeout
-	if session.isTor {
+// pingTimeouts returns how long the session can go without sending anything
+// before we PING it, and before we disconnect it.
+func (session *Session) pingTimeouts() (pingTimeout, totalTimeout time.Duration) {
+	config := session.client.server.Config()
+	pingTimeout, totalTimeout = config.Server.PingInterval, config.Server.PingTimeout
+	if session.isTor && TorIdleTimeout < pingTimeout {
 		pingTimeout = TorIdleTimeout
 	}
+	return
+}
+
+func (session *Session) handleIdleTimeout() {
+	pingTimeout, totalTimeout := session.pingTimeouts()
 
 	session.client.stateMutex.Lock()
 	now := time.Now()
@@ -1046,6 +1066,25 @@ func (client *Client) SetOper(oper *Oper) {
 	client.updateNickMaskNoMutex()
 }
 
+// sendAccountNotify dispatches account-notify for a change of the client's
+// account to `accountName` ("*" for a logout); the session in `rb` gets it
+// as part of the response
+func (client *Client) sendAccountNotify(rb *ResponseBuffer, accountName string) {
+	nickMask := client.NickMaskString()
+	for friend := range client.FriendsMonitors(caps.AccountNotify) {
+		if friend != rb.session {
+			friend.Send(nil, nickMask, "ACCOUNT", accountName)
+		}
+	}
+	if rb.session.capabilities.Has(caps.AccountNotify) {
+		rb.Add(nil, nickMask, "ACCOUNT", accountName)
+	}
+}
+
+// emulated MODE lines for sessions without chghost carry at most this many
+// modes, the default for clients that don't parse the MODES isupport token
+const chghostModesPerLine = 3
+
 // XXX: CHGHOST requires prefix nickmask to have original hostname,
 // this is annoying to do correctly
 func (client *Client) sendChghost(oldNickMask string, vhost string) {
@@ -1054,6 +1093,56 @@ func (client *Client) sendChghost(oldNickMask string, vhost string) {
 	for fClient := range client.FriendsMonitors(caps.ChgHost) {
 		fClient.sendFromClientInternal(false, time.Time{}, "", oldNickMask, details.accountName, isBot, nil, "CHGHOST", details.username, vhost)
 	}
+
+	// channel members who didn't negotiate chghost see a QUIT followed by
+	// a JOIN (and a MODE restoring the client's channel privileges, and an
+	// AWAY if they have away-notify) instead
+	isAway, awayMessage := client.Away()
+	quitSent := make(utils.HashSet[*Session])
+	for _, channel := range client.Channels() {
+		present, _, cModes := channel.ClientStatus(client)
+		if !present {
+			continue
+		}
+		chname := channel.Name()
+		visible := channel.memberIsVisible(client)
+		var modeLines [][]string
+		for i := 0; i < len(cModes); i += chghostModesPerLine {
+			lineModes := cModes[i:min(i+chghostModesPerLine, len(cModes))]
+			line := []string{chname, "+" + lineModes.String()}
+			for range lineModes {
+				line = append(line, details.nick)
+			}
+			modeLines = append(modeLines, line)
+		}
+		for _, member := range channel.Members() {
+			if member == client || !(visible || channel.memberIsVisible(member)) {
+				continue
+			}
+			for _, session := range member.Sessions() {
+				if session.capabilities.Has(caps.ChgHost) {
+					continue
+				}
+				firstJoin := !quitSent.Has(session)
+				if firstJoin {
+					quitSent.Add(session)
+					session.sendFromClientInternal(false, time.Time{}, "", oldNickMask, details.accountName, isBot, nil, "QUIT", member.t("Changing host"))
+				}
+				if session.capabilities.Has(caps.ExtendedJoin) {
+					session.sendFromClientInternal(false, time.Time{}, "", details.nickMask, details.accountName, isBot, nil, "JOIN", chname, details.accountName, details.realname)
+				} else {
+					session.sendFromClientInternal(false, time.Time{}, "", details.nickMask, details.accountName, isBot, nil, "JOIN", chname)
+				}
+				for _, line := range modeLines {
+					session.Send(nil, client.server.name, "MODE", line...)
+				}
+				// the QUIT cleared the client's away status, as far as the session knows
+				if firstJoin && isAway && session.capabilities.Has(caps.AwayNotify) {
+					session.sendFromClientInternal(false, time.Time{}, "", details.nickMask, details.accountName, isBot, nil, "AWAY", awayMessage)
+				}
+			}
+		}
+	}
 }
 
 // choose the correct vhost to display
@@ -1760,6 +1849,7 @@ func (client *Client) privmsgsBetween(startTime, endTime time.Time, targetLimit,
 }
 
 func (client *Client) handleRegisterTimeout() {
+	client.server.stats.CountRegistrationFailure()
 	client.Quit(fmt.Sprintf("Registration timeout: %v", RegisterTimeout), nil)
 	client.destroy(nil)
 }
@@ -5,9 +5,12 @@ package irc
 
 import (
 	"fmt"
+	"reflect"
 	"testing"
 
+	"github.com/ergochat/ergo/irc/caps"
 	"github.com/ergochat/ergo/irc/languages"
+	"github.com/ergochat/ergo/irc/modes"
 	"github.com/ergochat/ergo/irc/utils"
 )
 
@@ -131,3 +134,51 @@ func TestWhoFields(t *testing.T) {
 		t.Error("failed to set and get")
 	}
 }
+
+func TestSendChghostEmulation(t *testing.T) {
+	server, alice, _ := newHandlerTestClient(t)
+	server.monitorManager.Initialize()
+	alice.username, alice.rawHostname, alice.accountName, alice.realname = "a", "new.example.com", "alice", "Alice"
+	alice.updateNickMaskNoMutex()
+	alice.awayMessage = "gone"
+	alice.channels = make(ChannelSet)
+
+	// bob negotiated extended-join and away-notify but not chghost; carol negotiated chghost
+	newMember := func(nick string, capabs ...caps.Capability) (*Client, *testIRCConn) {
+		member := &Client{server: server, nick: nick, nickCasefolded: nick, channels: make(ChannelSet)}
+		conn := &testIRCConn{}
+		session := &Session{client: member, socket: NewSocket(conn, 65536, nil)}
+		session.capabilities.Enable(capabs...)
+		member.sessions = []*Session{session}
+		return member, conn
+	}
+	bob, bobConn := newMember("bob", caps.ExtendedJoin, caps.AwayNotify)
+	carol, carolConn := newMember("carol", caps.ChgHost)
+
+	channel := NewChannel(server, "#test", "#test", false, RegisteredChannel{})
+	for _, member := range []*Client{alice, bob, carol} {
+		channel.members.Add(member)
+		member.channels.Add(channel)
+	}
+	for _, mode := range []modes.Mode{modes.ChannelFounder, modes.ChannelAdmin, modes.ChannelOperator, modes.Halfop, modes.Voice} {
+		channel.members[alice].modes.SetMode(mode, true)
+	}
+	channel.regenerateMembersCache()
+
+	alice.sendChghost("alice!a@old.example.com", "new.example.com")
+
+	expected := []string{
+		":alice!a@old.example.com QUIT :Changing host",
+		":alice!a@new.example.com JOIN #test alice Alice",
+		":ergo.test MODE #test +aho alice alice alice",
+		":ergo.test MODE #test +qv alice alice",
+		":alice!a@new.example.com AWAY gone",
+	}
+	if lines := bobConn.Lines(len(expected)); !reflect.DeepEqual(lines, expected) {
+		t.Errorf("unexpected lines for a session without chghost:\n%q", lines)
+	}
+	expected = []string{":alice!a@old.example.com CHGHOST a new.example.com"}
+	if lines := carolConn.Lines(len(expected) + 1); !reflect.DeepEqual(lines, expected) {
+		t.Errorf("unexpected lines for a session with chghost:\n%q", lines)
+	}
+}
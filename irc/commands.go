@@ -39,7 +39,7 @@ func (cmd *Command) Run(server *Server, client *Client, session *Session, msg ir
 			return false
 		}
 		if session.batch.label != "" && !cmd.allowedInBatch {
-			rb.Add(nil, server.name, "FAIL", "BATCH", "MULTILINE_INVALID", client.t("Command not allowed during a multiline batch"))
+			rb.Fail("BATCH", "MULTILINE_INVALID", client.t("Command not allowed during a multiline batch"))
 			session.EndMultilineBatch("")
 			return false
 		}
@@ -79,10 +79,19 @@ func init() {
 			handler:   acceptHandler,
 			minParams: 1,
 		},
+		"ADMIN": {
+			handler:   adminHandler,
+			minParams: 0,
+		},
 		"AMBIANCE": {
 			handler:   sceneHandler,
 			minParams: 2,
 		},
+		"AUDIT": {
+			handler:   auditHandler,
+			minParams: 0,
+			capabs:    []string{"audit"},
+		},
 		"AUTHENTICATE": {
 			handler:      authenticateHandler,
 			usablePreReg: true,
@@ -120,6 +129,11 @@ func init() {
 			handler:   deoperHandler,
 			minParams: 0,
 		},
+		"DIE": {
+			handler:   dieHandler,
+			minParams: 0,
+			capabs:    []string{"die"},
+		},
 		"DLINE": {
 			handler:   dlineHandler,
 			minParams: 1,
@@ -174,6 +188,10 @@ func init() {
 			minParams: 1,
 			capabs:    []string{"ban"},
 		},
+		"KNOCK": {
+			handler:   knockHandler,
+			minParams: 1,
+		},
 		"LANGUAGE": {
 			handler:      languageHandler,
 			usablePreReg: true,
@@ -275,6 +293,11 @@ func init() {
 			minParams: 1,
 			capabs:    []string{"sajoin"},
 		},
+		"SAPART": {
+			handler:   sapartHandler,
+			minParams: 2,
+			capabs:    []string{"sajoin"},
+		},
 		"SANICK": {
 			handler:   sanickHandler,
 			minParams: 2,
@@ -293,6 +316,10 @@ func init() {
 			handler:   setnameHandler,
 			minParams: 1,
 		},
+		"STATS": {
+			handler:   statsHandler,
+			minParams: 0,
+		},
 		"SUMMON": {
 			handler: summonHandler,
 		},
@@ -362,11 +389,20 @@ func init() {
 			handler:   versionHandler,
 			minParams: 0,
 		},
+		"WALLOPS": {
+			handler:   wallopsHandler,
+			minParams: 1,
+			capabs:    []string{"wallops"},
+		},
 		"WEBIRC": {
 			handler:      webircHandler,
 			usablePreReg: true,
 			minParams:    4,
 		},
+		"WEBPUSH": {
+			handler:   webpushHandler,
+			minParams: 2,
+		},
 		"WHO": {
 			handler:   whoHandler,
 			minParams: 1,
@@ -19,6 +19,7 @@ import (
 	"reflect"
 	"regexp"
 	"runtime"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -36,11 +37,13 @@ import (
 	"github.com/ergochat/ergo/irc/jwt"
 	"github.com/ergochat/ergo/irc/languages"
 	"github.com/ergochat/ergo/irc/logger"
+	"github.com/ergochat/ergo/irc/matrix"
 	"github.com/ergochat/ergo/irc/modes"
 	"github.com/ergochat/ergo/irc/mysql"
 	"github.com/ergochat/ergo/irc/oauth2"
 	"github.com/ergochat/ergo/irc/passwd"
 	"github.com/ergochat/ergo/irc/utils"
+	"github.com/ergochat/ergo/irc/webpush"
 )
 
 // here's how this works: exported (capitalized) members of the config structs
@@ -52,6 +55,7 @@ import (
 type TLSListenConfig struct {
 	Cert  string
 	Key   string
+	ACME  bool // obtain the certificate automatically (see server.acme) instead of Cert/Key
 	Proxy bool // XXX: legacy key: it's preferred to specify this directly in listenerConfigBlock
 }
 
@@ -332,6 +336,7 @@ type AccountConfig struct {
 	Multiclient MulticlientConfig
 	Bouncer     *MulticlientConfig // # handle old name for 'multiclient'
 	VHosts      VHostConfig
+	Memos       MemoConfig
 	AuthScript  AuthScriptConfig          `yaml:"auth-script"`
 	OAuth2      oauth2.OAuth2BearerConfig `yaml:"oauth2"`
 	JWTAuth     jwt.JWTAuthConfig         `yaml:"jwt-auth"`
@@ -348,7 +353,11 @@ type ScriptConfig struct {
 
 type AuthScriptConfig struct {
 	ScriptConfig `yaml:",inline"`
-	Autocreate   bool
+	// alternatively to Command, POST the input to an HTTP endpoint,
+	// signed with Secret as for webhooks:
+	URL        string
+	Secret     string
+	Autocreate bool
 }
 
 type IPCheckScriptConfig struct {
@@ -379,6 +388,12 @@ type VHostConfig struct {
 	validRegexp    *regexp.Regexp
 }
 
+type MemoConfig struct {
+	Enabled           bool
+	MaxMemos          int `yaml:"max-memos"`
+	MaxMemosPerSender int `yaml:"max-memos-per-sender"`
+}
+
 type NickEnforcementMethod int
 
 const (
@@ -560,6 +575,174 @@ type SlowmodeConfig struct {
 	Duration int  `yaml:"duration"`
 }
 
+type DiscordConfig struct {
+	Enabled    bool
+	Token      string
+	NickSuffix string `yaml:"nick-suffix"`
+	// maps IRC channel names to Discord channel IDs
+	Channels     map[string]string
+	ircToDiscord map[string]string // casefolded channel name to Discord channel ID
+	discordToIRC map[string]string
+}
+
+type WebhookEndpointConfig struct {
+	URL    string
+	Secret string
+	// event types to send, and channels to send channel events for (default all)
+	Events   []string
+	Channels []string
+	events   utils.HashSet[string]
+	channels utils.HashSet[string] // casefolded
+}
+
+type IncomingWebhookConfig struct {
+	Token string
+	Nick  string
+	// channels the webhook may post to
+	Channels []string
+	channels utils.HashSet[string] // casefolded
+	nick     string                // relay nick, including the separator and suffix
+}
+
+type WebhooksConfig struct {
+	Enabled    bool
+	Timeout    time.Duration
+	MaxRetries int `yaml:"max-retries"`
+	Endpoints  []WebhookEndpointConfig
+	Incoming   struct {
+		Enabled  bool
+		Listener string
+		TLS      struct {
+			Cert string
+			Key  string
+		}
+		NickSuffix string `yaml:"nick-suffix"`
+		Throttling ThrottleConfig
+		Tokens     map[string]IncomingWebhookConfig
+	}
+}
+
+type APITokenConfig struct {
+	Token string
+	// oper class whose capabilities determine what the token may do
+	Class string
+	class *OperClass
+}
+
+type APIConfig struct {
+	Enabled  bool
+	Listener string
+	TLS      struct {
+		Cert string
+		Key  string
+	}
+	Tokens map[string]APITokenConfig
+}
+
+type MatrixConfig struct {
+	Enabled bool
+	// address the appservice API listens on, and the URL the homeserver uses to reach it
+	Listener        string
+	URL             string
+	Homeserver      string
+	ServerName      string `yaml:"server-name"`
+	ASToken         string `yaml:"as-token"`
+	HSToken         string `yaml:"hs-token"`
+	SenderLocalpart string `yaml:"sender-localpart"`
+	UserPrefix      string `yaml:"user-prefix"`
+	NickSuffix      string `yaml:"nick-suffix"`
+	// maps IRC channel names to Matrix room IDs
+	Rooms       map[string]string
+	ircToMatrix map[string]string // casefolded channel name to room ID
+	matrixToIRC map[string]string
+}
+
+func (conf *MatrixConfig) appserviceConfig() matrix.Config {
+	return matrix.Config{
+		Homeserver:      conf.Homeserver,
+		ServerName:      conf.ServerName,
+		ASToken:         conf.ASToken,
+		HSToken:         conf.HSToken,
+		SenderLocalpart: conf.SenderLocalpart,
+		UserPrefix:      conf.UserPrefix,
+	}
+}
+
+type WebPushConfig struct {
+	Enabled          bool
+	Timeout          time.Duration
+	Subscriber       string
+	MaxSubscriptions int `yaml:"max-subscriptions"`
+	Expiration       custime.Duration
+	Throttling       ThrottleConfig
+	// loaded from the datastore, after the config itself:
+	vapidKeys *webpush.VAPIDKeys
+}
+
+// clientTagDenyList is the parsed form of the client-tag-deny policy,
+// which is advertised to clients as the CLIENTTAGDENY isupport token
+type clientTagDenyList struct {
+	denyAll bool
+	// if denyAll is set, these are the exempted tags; otherwise they're the denied tags
+	tags []string
+}
+
+func parseClientTagDenyList(entries []string) (result clientTagDenyList, err error) {
+	for _, entry := range entries {
+		if entry == "*" {
+			result.denyAll = true
+		}
+	}
+	for _, entry := range entries {
+		if entry == "*" {
+			continue
+		}
+		exempt := strings.HasPrefix(entry, "-")
+		tag := strings.TrimPrefix(strings.TrimPrefix(entry, "-"), "+")
+		if tag == "" || strings.ContainsAny(tag, " ,=;") {
+			return result, fmt.Errorf("invalid tag name %s", entry)
+		}
+		if result.denyAll && !exempt {
+			return result, fmt.Errorf("%s is redundant with *", entry)
+		} else if !result.denyAll && exempt {
+			return result, fmt.Errorf("%s requires * to be denied", entry)
+		}
+		result.tags = append(result.tags, tag)
+	}
+	return
+}
+
+// String returns the value of the CLIENTTAGDENY token (or "" if nothing is denied).
+func (dl *clientTagDenyList) String() string {
+	if !dl.denyAll {
+		return strings.Join(dl.tags, ",")
+	}
+	tokens := make([]string, 0, 1+len(dl.tags))
+	tokens = append(tokens, "*")
+	for _, tag := range dl.tags {
+		tokens = append(tokens, "-"+tag)
+	}
+	return strings.Join(tokens, ",")
+}
+
+// Filter returns the client-only tags that are permitted by the policy.
+func (dl *clientTagDenyList) Filter(tags map[string]string) map[string]string {
+	if len(tags) == 0 || (!dl.denyAll && len(dl.tags) == 0) {
+		return tags
+	}
+	var result map[string]string
+	for name, value := range tags {
+		if dl.denyAll != slices.Contains(dl.tags, strings.TrimPrefix(name, "+")) {
+			continue
+		}
+		if result == nil {
+			result = make(map[string]string, len(tags))
+		}
+		result[name] = value
+	}
+	return result
+}
+
 // Config defines the overall configuration.
 type Config struct {
 	AllowEnvironmentOverrides bool `yaml:"allow-environment-overrides"`
@@ -575,6 +758,7 @@ type Config struct {
 		nameCasefolded string
 		Listeners      map[string]listenerConfigBlock
 		UnixBindMode   os.FileMode        `yaml:"unix-bind-mode"`
+		ControlSocket  string             `yaml:"control-socket"`
 		TorListeners   TorListenersConfig `yaml:"tor-listeners"`
 		WebSockets     struct {
 			AllowedOrigins       []string `yaml:"allowed-origins"`
@@ -591,7 +775,12 @@ type Config struct {
 		MOTD                    string
 		motdLines               []string
 		MOTDFormatting          bool `yaml:"motd-formatting"`
-		Relaymsg                struct {
+		Admin                   struct {
+			Location    string
+			Description string
+			Email       string
+		}
+		Relaymsg struct {
 			Enabled            bool
 			Separators         string
 			AvailableToChanops bool `yaml:"available-to-chanops"`
@@ -620,9 +809,16 @@ type Config struct {
 		EnforceUtf8              bool                `yaml:"enforce-utf8"`
 		OutputPath               string              `yaml:"output-path"`
 		IPCheckScript            IPCheckScriptConfig `yaml:"ip-check-script"`
+		DNSBL                    DNSBLConfig         `yaml:"dnsbl"`
+		ACME                     ACMEConfig          `yaml:"acme"`
 		OverrideServicesHostname string              `yaml:"override-services-hostname"`
 		MaxLineLen               int                 `yaml:"max-line-len"`
 		SuppressLusers           bool                `yaml:"suppress-lusers"`
+		ShutdownTimeout          time.Duration       `yaml:"shutdown-timeout"`
+		PingInterval             time.Duration       `yaml:"ping-interval"`
+		PingTimeout              time.Duration       `yaml:"ping-timeout"`
+		ClientTagDeny            []string            `yaml:"client-tag-deny"`
+		clientTagDeny            clientTagDenyList
 	}
 
 	Roleplay struct {
@@ -668,6 +864,8 @@ type Config struct {
 		}
 		ListDelay        time.Duration    `yaml:"list-delay"`
 		InviteExpiration custime.Duration `yaml:"invite-expiration"`
+		InviteNotifyAll  bool             `yaml:"invite-notify-all-members"`
+		KnockDelay       time.Duration    `yaml:"knock-delay"`
 		AutoJoin         []string         `yaml:"auto-join"`
 	}
 
@@ -685,6 +883,7 @@ type Config struct {
 		RecoverFromErrors *bool `yaml:"recover-from-errors"`
 		recoverFromErrors bool
 		PprofListener     string `yaml:"pprof-listener"`
+		MetricsListener   string `yaml:"metrics-listener"`
 	}
 
 	Limits Limits
@@ -697,6 +896,7 @@ type Config struct {
 		ClientLength     int              `yaml:"client-length"`
 		AutoresizeWindow custime.Duration `yaml:"autoresize-window"`
 		AutoreplayOnJoin int              `yaml:"autoreplay-on-join"`
+		AutoreplayWindow custime.Duration `yaml:"autoreplay-window"`
 		ChathistoryMax   int              `yaml:"chathistory-maxmessages"`
 		ZNCMax           int              `yaml:"znc-maxmessages"`
 		Restrictions     struct {
@@ -724,6 +924,16 @@ type Config struct {
 		} `yaml:"tagmsg-storage"`
 	}
 
+	WebPush WebPushConfig `yaml:"webpush"`
+
+	Webhooks WebhooksConfig
+
+	API APIConfig
+
+	Discord DiscordConfig
+
+	Matrix MatrixConfig
+
 	Filename string
 
 	Automod  AutomodConfig  `yaml:"automod"`
@@ -912,7 +1122,7 @@ func loadTlsConfig(config listenerConfigBlock) (tlsConfig *tls.Config, err error
 			return nil, err
 		}
 		certificates = append(certificates, cert)
-	} else {
+	} else if !config.TLS.ACME {
 		// plaintext!
 		return nil, nil
 	}
@@ -979,6 +1189,15 @@ func (conf *Config) prepareListeners() (err error) {
 		if lconf.STSOnly && !conf.Server.STS.Enabled {
 			return fmt.Errorf("%s is configured as a STS-only listener, but STS is disabled", addr)
 		}
+		if block.TLS.ACME {
+			if !conf.Server.ACME.Enabled {
+				return fmt.Errorf("%s is configured to use ACME, but server.acme is disabled", addr)
+			}
+			if block.TLS.Cert != "" || len(block.TLSCertificates) != 0 {
+				return fmt.Errorf("%s is configured to use both ACME and a certificate file", addr)
+			}
+			lconf.ACME = true
+		}
 		lconf.TLSConfig, err = loadTlsConfig(block)
 		if err != nil {
 			return &CertKeyError{Err: err}
@@ -1180,6 +1399,20 @@ func LoadConfig(filename string) (config *Config, err error) {
 	if config.Limits.RegistrationMessages == 0 {
 		config.Limits.RegistrationMessages = 1024
 	}
+	if config.Server.PingInterval == 0 {
+		config.Server.PingInterval = DefaultIdleTimeout
+	}
+	if config.Server.PingTimeout == 0 {
+		config.Server.PingTimeout = DefaultTotalTimeout
+	}
+	if config.Server.PingTimeout <= config.Server.PingInterval {
+		return nil, errors.New("server.ping-timeout must be longer than server.ping-interval")
+	}
+
+	if config.Server.ShutdownTimeout == 0 {
+		config.Server.ShutdownTimeout = defaultShutdownTimeout
+	}
+
 	if config.Server.MaxLineLen < DefaultMaxLineLen {
 		config.Server.MaxLineLen = DefaultMaxLineLen
 	}
@@ -1204,6 +1437,10 @@ func LoadConfig(filename string) (config *Config, err error) {
 	config.Server.supportedCaps = caps.NewCompleteSet()
 	config.Server.capValues = make(caps.Values)
 
+	if err = config.Server.ACME.prepare(); err != nil {
+		return nil, err
+	}
+
 	err = config.prepareListeners()
 	if err != nil {
 		return nil, fmt.Errorf("failed to prepare listeners: %v", err)
@@ -1404,6 +1641,11 @@ func LoadConfig(filename string) (config *Config, err error) {
 		return nil, fmt.Errorf("Could not parse secure-nets: %v\n", err.Error())
 	}
 
+	config.Server.clientTagDeny, err = parseClientTagDenyList(config.Server.ClientTagDeny)
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse client-tag-deny: %v", err.Error())
+	}
+
 	rawRegexp := config.Accounts.VHosts.ValidRegexpRaw
 	if rawRegexp != "" {
 		regexp, err := regexp.Compile(rawRegexp)
@@ -1416,6 +1658,12 @@ func LoadConfig(filename string) (config *Config, err error) {
 	if config.Accounts.VHosts.validRegexp == nil {
 		config.Accounts.VHosts.validRegexp = defaultValidVhostRegex
 	}
+	if config.Accounts.Memos.MaxMemos <= 0 {
+		config.Accounts.Memos.MaxMemos = defaultMaxMemos
+	}
+	if config.Accounts.Memos.MaxMemosPerSender <= 0 {
+		config.Accounts.Memos.MaxMemosPerSender = defaultMaxMemosPerSender
+	}
 
 	if config.Accounts.AuthenticationEnabled {
 		saslCapValues := []string{"PLAIN", "EXTERNAL"}
@@ -1441,6 +1689,19 @@ func LoadConfig(filename string) (config *Config, err error) {
 		return nil, err
 	}
 
+	if config.Accounts.AuthScript.Enabled {
+		if (config.Accounts.AuthScript.Command == "") == (config.Accounts.AuthScript.URL == "") {
+			return nil, fmt.Errorf("auth-script requires exactly one of command or url")
+		}
+		if config.Accounts.AuthScript.URL != "" {
+			if !strings.HasPrefix(config.Accounts.AuthScript.URL, "https://") && !strings.HasPrefix(config.Accounts.AuthScript.URL, "http://") {
+				return nil, fmt.Errorf("invalid auth-script url: %s", config.Accounts.AuthScript.URL)
+			}
+			if config.Accounts.AuthScript.Timeout == 0 {
+				return nil, fmt.Errorf("auth-script with a url requires a nonzero timeout")
+			}
+		}
+	}
 	if config.Accounts.OAuth2.Enabled && config.Accounts.OAuth2.AuthScript && !config.Accounts.AuthScript.Enabled {
 		return nil, fmt.Errorf("oauth2 is enabled with auth-script, but no auth-script is enabled")
 	}
@@ -1497,6 +1758,185 @@ func LoadConfig(filename string) (config *Config, err error) {
 		config.Server.supportedCaps.Disable(caps.Relaymsg)
 	}
 
+	if config.WebPush.Enabled {
+		if config.WebPush.Subscriber != "" && !(strings.HasPrefix(config.WebPush.Subscriber, "mailto:") || strings.HasPrefix(config.WebPush.Subscriber, "https://")) {
+			return nil, fmt.Errorf("webpush subscriber must be a mailto: or https:// URI")
+		}
+		if config.WebPush.Timeout == 0 {
+			config.WebPush.Timeout = 10 * time.Second
+		}
+		if config.WebPush.MaxSubscriptions == 0 {
+			config.WebPush.MaxSubscriptions = 4
+		}
+		if config.WebPush.Expiration == 0 {
+			config.WebPush.Expiration = custime.Duration(14 * 24 * time.Hour)
+		}
+	} else {
+		config.Server.supportedCaps.Disable(caps.WebPush)
+	}
+
+	if config.Webhooks.Enabled {
+		if config.Webhooks.Timeout == 0 {
+			config.Webhooks.Timeout = 10 * time.Second
+		}
+		if config.Webhooks.MaxRetries == 0 {
+			config.Webhooks.MaxRetries = 3
+		}
+		for i := range config.Webhooks.Endpoints {
+			endpoint := &config.Webhooks.Endpoints[i]
+			if !strings.HasPrefix(endpoint.URL, "https://") && !strings.HasPrefix(endpoint.URL, "http://") {
+				return nil, fmt.Errorf("Invalid webhook URL: %s", endpoint.URL)
+			}
+			if endpoint.Secret == "" {
+				return nil, fmt.Errorf("Webhook %s requires a secret", endpoint.URL)
+			}
+			if len(endpoint.Events) == 0 {
+				endpoint.Events = webhookEventTypes
+			}
+			endpoint.events = make(utils.HashSet[string], len(endpoint.Events))
+			for _, eventType := range endpoint.Events {
+				if !slices.Contains(webhookEventTypes, eventType) {
+					return nil, fmt.Errorf("Invalid event type for webhook %s: %s", endpoint.URL, eventType)
+				}
+				endpoint.events.Add(eventType)
+			}
+			if len(endpoint.Channels) != 0 {
+				endpoint.channels = make(utils.HashSet[string], len(endpoint.Channels))
+				for _, chname := range endpoint.Channels {
+					cfname, err := CasefoldChannel(chname)
+					if err != nil {
+						return nil, fmt.Errorf("Invalid channel name for webhook %s: %s", endpoint.URL, chname)
+					}
+					endpoint.channels.Add(cfname)
+				}
+			}
+		}
+	}
+
+	if config.Webhooks.Incoming.Enabled {
+		incoming := &config.Webhooks.Incoming
+		if incoming.Listener == "" {
+			return nil, errors.New("Incoming webhooks require a listener address")
+		}
+		if (incoming.TLS.Cert == "") != (incoming.TLS.Key == "") {
+			return nil, errors.New("Incoming webhooks require both a TLS certificate and key, or neither")
+		}
+		if !config.Server.Relaymsg.Enabled || config.Server.Relaymsg.Separators == "" {
+			return nil, errors.New("Incoming webhooks require server.relaymsg to be enabled")
+		}
+		if incoming.NickSuffix == "" {
+			incoming.NickSuffix = "bot"
+		}
+		tokens := make(utils.HashSet[string], len(incoming.Tokens))
+		for name, hook := range incoming.Tokens {
+			if len(hook.Token) < 16 {
+				return nil, fmt.Errorf("The token for incoming webhook %s must be at least 16 characters", name)
+			}
+			if tokens.Has(hook.Token) {
+				return nil, fmt.Errorf("Incoming webhook %s has the same token as another webhook", name)
+			}
+			tokens.Add(hook.Token)
+			if hook.Nick == "" {
+				hook.Nick = name
+			}
+			hook.nick = hook.Nick + config.Server.Relaymsg.Separators[:1] + incoming.NickSuffix
+			if _, err := CasefoldName(hook.nick); err != nil {
+				return nil, fmt.Errorf("Invalid nick for incoming webhook %s: %s", name, hook.Nick)
+			}
+			if len(hook.Channels) == 0 {
+				return nil, fmt.Errorf("Incoming webhook %s must be restricted to a list of channels", name)
+			}
+			hook.channels = make(utils.HashSet[string], len(hook.Channels))
+			for _, chname := range hook.Channels {
+				cfname, err := CasefoldChannel(chname)
+				if err != nil {
+					return nil, fmt.Errorf("Invalid channel name for incoming webhook %s: %s", name, chname)
+				}
+				hook.channels.Add(cfname)
+			}
+			incoming.Tokens[name] = hook
+		}
+	}
+
+	if config.Discord.Enabled {
+		if config.Discord.Token == "" {
+			return nil, errors.New("The Discord bridge requires a bot token")
+		}
+		if !config.Server.Relaymsg.Enabled || config.Server.Relaymsg.Separators == "" {
+			return nil, errors.New("The Discord bridge requires server.relaymsg to be enabled")
+		}
+		if config.Discord.NickSuffix == "" {
+			config.Discord.NickSuffix = "d"
+		}
+		config.Discord.ircToDiscord = make(map[string]string, len(config.Discord.Channels))
+		config.Discord.discordToIRC = make(map[string]string, len(config.Discord.Channels))
+		for chname, channelID := range config.Discord.Channels {
+			cfname, err := CasefoldChannel(chname)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid channel name for the Discord bridge: %s", chname)
+			}
+			if channelID == "" || strings.Trim(channelID, "0123456789") != "" {
+				return nil, fmt.Errorf("Invalid Discord channel ID for %s: %s", chname, channelID)
+			}
+			if _, ok := config.Discord.discordToIRC[channelID]; ok {
+				return nil, fmt.Errorf("Discord channel %s is bridged to more than one channel", channelID)
+			}
+			config.Discord.ircToDiscord[cfname] = channelID
+			config.Discord.discordToIRC[channelID] = cfname
+		}
+	}
+
+	if config.Matrix.Enabled {
+		if config.Matrix.Listener == "" {
+			return nil, errors.New("The Matrix bridge requires a listener address")
+		}
+		if config.Matrix.URL == "" {
+			config.Matrix.URL = "http://" + config.Matrix.Listener
+		}
+		if !strings.HasPrefix(config.Matrix.Homeserver, "https://") && !strings.HasPrefix(config.Matrix.Homeserver, "http://") {
+			return nil, errors.New("The Matrix bridge requires the URL of the homeserver")
+		}
+		if config.Matrix.ServerName == "" {
+			return nil, errors.New("The Matrix bridge requires the homeserver's server name")
+		}
+		if config.Matrix.ASToken == "" || config.Matrix.HSToken == "" || config.Matrix.ASToken == config.Matrix.HSToken {
+			return nil, errors.New("The Matrix bridge requires distinct as-token and hs-token values")
+		}
+		if !config.Server.Relaymsg.Enabled || config.Server.Relaymsg.Separators == "" {
+			return nil, errors.New("The Matrix bridge requires server.relaymsg to be enabled")
+		}
+		if config.Matrix.SenderLocalpart == "" {
+			config.Matrix.SenderLocalpart = "ergo"
+		}
+		if config.Matrix.UserPrefix == "" {
+			config.Matrix.UserPrefix = "irc_"
+		}
+		if matrix.EscapeLocalpart(config.Matrix.SenderLocalpart) != config.Matrix.SenderLocalpart ||
+			matrix.EscapeLocalpart(config.Matrix.UserPrefix) != config.Matrix.UserPrefix ||
+			strings.HasPrefix(config.Matrix.SenderLocalpart, config.Matrix.UserPrefix) {
+			return nil, errors.New("Invalid sender-localpart or user-prefix for the Matrix bridge")
+		}
+		if config.Matrix.NickSuffix == "" {
+			config.Matrix.NickSuffix = "m"
+		}
+		config.Matrix.ircToMatrix = make(map[string]string, len(config.Matrix.Rooms))
+		config.Matrix.matrixToIRC = make(map[string]string, len(config.Matrix.Rooms))
+		for chname, roomID := range config.Matrix.Rooms {
+			cfname, err := CasefoldChannel(chname)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid channel name for the Matrix bridge: %s", chname)
+			}
+			if !strings.HasPrefix(roomID, "!") || !strings.Contains(roomID, ":") {
+				return nil, fmt.Errorf("Invalid Matrix room ID for %s: %s", chname, roomID)
+			}
+			if _, ok := config.Matrix.matrixToIRC[roomID]; ok {
+				return nil, fmt.Errorf("Matrix room %s is bridged to more than one channel", roomID)
+			}
+			config.Matrix.ircToMatrix[cfname] = roomID
+			config.Matrix.matrixToIRC[roomID] = cfname
+		}
+	}
+
 	config.Debug.recoverFromErrors = utils.BoolDefaultTrue(config.Debug.RecoverFromErrors)
 
 	// process operator definitions, store them to config.operators
@@ -1510,6 +1950,30 @@ func LoadConfig(filename string) (config *Config, err error) {
 	}
 	config.operators = opers
 
+	if config.API.Enabled {
+		if config.API.Listener == "" {
+			return nil, errors.New("The HTTP API requires a listener address")
+		}
+		if (config.API.TLS.Cert == "") != (config.API.TLS.Key == "") {
+			return nil, errors.New("The HTTP API requires both a TLS certificate and key, or neither")
+		}
+		tokens := make(utils.HashSet[string], len(config.API.Tokens))
+		for name, apiToken := range config.API.Tokens {
+			if len(apiToken.Token) < 16 {
+				return nil, fmt.Errorf("The HTTP API token %s must be at least 16 characters", name)
+			}
+			if tokens.Has(apiToken.Token) {
+				return nil, fmt.Errorf("The HTTP API token %s is the same as another token", name)
+			}
+			tokens.Add(apiToken.Token)
+			apiToken.class = operclasses[apiToken.Class]
+			if apiToken.class == nil {
+				return nil, fmt.Errorf("The HTTP API token %s has an invalid oper class: %s", name, apiToken.Class)
+			}
+			config.API.Tokens[name] = apiToken
+		}
+	}
+
 	// parse default channel modes
 	config.Channels.defaultModes = ParseDefaultChannelModes(config.Channels.DefaultModes)
 
@@ -1579,6 +2043,10 @@ func LoadConfig(filename string) (config *Config, err error) {
 		config.Datastore.MySQL.MaxConns = runtime.NumCPU()
 	}
 
+	if err = config.Server.DNSBL.prepare(); err != nil {
+		return nil, err
+	}
+
 	config.Server.Cloaks.Initialize()
 	if config.Server.Cloaks.Enabled {
 		if !utils.IsHostname(config.Server.Cloaks.Netname) {
@@ -1654,15 +2122,19 @@ func (config *Config) generateISupport() (err error) {
 	}
 	isupport.Add("CHANNELLEN", strconv.Itoa(config.Limits.ChannelLen))
 	isupport.Add("CHANTYPES", chanTypes)
+	if token := config.Server.clientTagDeny.String(); token != "" {
+		isupport.Add("CLIENTTAGDENY", token)
+	}
 	isupport.Add("ELIST", "U")
 	isupport.Add("EXCEPTS", "")
 	if config.Extjwt.Default.Enabled() || len(config.Extjwt.Services) != 0 {
 		isupport.Add("EXTJWT", "1")
 	}
-	isupport.Add("EXTBAN", ",m")
+	isupport.Add("EXTBAN", extbanISupportValue())
 	isupport.Add("FORWARD", "f")
 	isupport.Add("INVEX", "")
 	isupport.Add("KICKLEN", strconv.Itoa(config.Limits.KickLen))
+	isupport.Add("KNOCK", "")
 	isupport.Add("MAXLIST", fmt.Sprintf("beI:%s", strconv.Itoa(config.Limits.ChanListModes)))
 	isupport.Add("MAXTARGETS", maxTargetsString)
 	isupport.Add("MSGREFTYPES", "msgid,timestamp")
@@ -1677,7 +2149,7 @@ func (config *Config) generateISupport() (err error) {
 	}
 	isupport.Add("SAFELIST", "")
 	isupport.Add("STATUSMSG", "~&@%+")
-	isupport.Add("TARGMAX", fmt.Sprintf("NAMES:1,LIST:1,KICK:,WHOIS:1,USERHOST:10,PRIVMSG:%s,TAGMSG:%s,NOTICE:%s,MONITOR:%d", maxTargetsString, maxTargetsString, maxTargetsString, config.Limits.MonitorEntries))
+	isupport.Add("TARGMAX", fmt.Sprintf("NAMES:1,LIST:1,KICK:,WHOIS:1,USERHOST:5,PRIVMSG:%s,TAGMSG:%s,NOTICE:%s,MONITOR:%d", maxTargetsString, maxTargetsString, maxTargetsString, config.Limits.MonitorEntries))
 	isupport.Add("TOPICLEN", strconv.Itoa(config.Limits.TopicLen))
 	if config.Server.Casemapping == CasemappingPRECIS {
 		isupport.Add("UTF8MAPPING", precisUTF8MappingToken)
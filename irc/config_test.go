@@ -4,8 +4,11 @@
 package irc
 
 import (
+	"net"
 	"reflect"
 	"testing"
+
+	"github.com/ergochat/ergo/irc/logger"
 )
 
 func TestEnvironmentOverrides(t *testing.T) {
@@ -99,3 +102,95 @@ func TestEnvironmentOverrideErrors(t *testing.T) {
 		}
 	}
 }
+
+func TestClientTagDenyList(t *testing.T) {
+	tags := map[string]string{"+typing": "active", "+draft/react": "lol", "+example.com/custom": ""}
+
+	dl, err := parseClientTagDenyList(nil)
+	if err != nil || dl.String() != "" || !reflect.DeepEqual(dl.Filter(tags), tags) {
+		t.Errorf("empty policy should allow everything")
+	}
+
+	dl, err = parseClientTagDenyList([]string{"+example.com/custom", "typing"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dl.String() != "example.com/custom,typing" {
+		t.Errorf("unexpected CLIENTTAGDENY: %s", dl.String())
+	}
+	if filtered := dl.Filter(tags); !reflect.DeepEqual(filtered, map[string]string{"+draft/react": "lol"}) {
+		t.Errorf("unexpected filter result: %v", filtered)
+	}
+
+	dl, err = parseClientTagDenyList([]string{"-typing", "*"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dl.String() != "*,-typing" {
+		t.Errorf("unexpected CLIENTTAGDENY: %s", dl.String())
+	}
+	if filtered := dl.Filter(tags); !reflect.DeepEqual(filtered, map[string]string{"+typing": "active"}) {
+		t.Errorf("unexpected filter result: %v", filtered)
+	}
+	if filtered := dl.Filter(map[string]string{"+draft/react": "lol"}); filtered != nil {
+		t.Errorf("unexpected filter result: %v", filtered)
+	}
+
+	if _, err = parseClientTagDenyList([]string{"-typing"}); err == nil {
+		t.Errorf("exemption without * should be rejected")
+	}
+	if _, err = parseClientTagDenyList([]string{"*", "typing"}); err == nil {
+		t.Errorf("redundant denial should be rejected")
+	}
+}
+
+func TestACMEListenerConfig(t *testing.T) {
+	newConfig := func(httpListener string) *Config {
+		var config Config
+		config.Server.ACME = ACMEConfig{Enabled: true, Hostnames: []string{"irc.example.com"}, CacheDir: t.TempDir(), HTTPListener: httpListener}
+		config.Server.Listeners = map[string]listenerConfigBlock{":6697": {TLS: TLSListenConfig{ACME: true}}}
+		return &config
+	}
+
+	config := newConfig("127.0.0.1:0")
+	if err := config.Server.ACME.prepare(); err != nil {
+		t.Fatal(err)
+	}
+	if err := config.prepareListeners(); err != nil {
+		t.Fatal(err)
+	}
+	logger, err := logger.NewManager(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &Server{logger: logger}
+	if err := server.setupACME(config); err != nil {
+		t.Fatal(err)
+	}
+	defer server.acmeServer.Close()
+	if config.Server.trueListeners[":6697"].TLSConfig.GetCertificate == nil {
+		t.Errorf("ACME listener has no GetCertificate")
+	}
+
+	// the challenge listener is bound synchronously, so failures are reported
+	inUse, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer inUse.Close()
+	if err := (&Server{logger: logger}).setupACME(newConfig(inUse.Addr().String())); err == nil {
+		t.Errorf("binding the challenge listener to an address in use should fail")
+	}
+
+	config = newConfig("127.0.0.1:0")
+	config.Server.ACME.Hostnames = nil
+	if err := config.Server.ACME.prepare(); err == nil {
+		t.Errorf("ACME without hostnames should be rejected")
+	}
+
+	config = newConfig("127.0.0.1:0")
+	config.Server.Listeners[":6697"] = listenerConfigBlock{TLS: TLSListenConfig{ACME: true, Cert: "fullchain.pem", Key: "privkey.pem"}}
+	if err := config.prepareListeners(); err == nil || err.Error() != ":6697 is configured to use both ACME and a certificate file" {
+		t.Errorf("ACME together with a certificate file should be rejected, got %v", err)
+	}
+}
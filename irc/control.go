@@ -0,0 +1,239 @@
+package irc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// the control socket is a Unix domain socket that serves the HTTP API
+// without authentication, for `ergo ctl`; access to it is restricted
+// by its file permissions.
+
+const (
+	controlSocketMode = 0600
+	controlTimeout    = 30 * time.Second
+)
+
+func (server *Server) setupControlSocket(config *Config) {
+	path := config.Server.ControlSocket
+	if server.controlServer != nil {
+		if path == server.controlSocket {
+			return
+		}
+		server.logger.Info("server", "Stopping control socket", server.controlSocket)
+		server.controlServer.Close()
+		server.controlServer = nil
+	}
+	server.controlSocket = path
+	if path == "" {
+		return
+	}
+
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		server.logger.Warning("server", "could not delete control socket", path, err.Error())
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		server.logger.Error("server", "could not listen on control socket", path, err.Error())
+		return
+	}
+	if err := os.Chmod(path, controlSocketMode); err != nil {
+		server.logger.Error("server", "could not set permissions on control socket", path, err.Error())
+		listener.Close()
+		return
+	}
+
+	mux := http.NewServeMux()
+	server.registerAPIHandlers(mux, true)
+	cs := http.Server{
+		Handler: mux,
+	}
+	go func() {
+		if err := cs.Serve(listener); err != nil && err != http.ErrServerClosed {
+			server.logger.Error("server", "control socket failed", err.Error())
+		}
+	}()
+	server.controlServer = &cs
+	server.logger.Info("server", "Started control socket", path)
+}
+
+type controlCommand struct {
+	method string
+	path   string
+	// the positional arguments; the last one may contain spaces
+	args    []string
+	minArgs int
+	body    func(args []string) any
+}
+
+var controlCommands = map[string]controlCommand{
+	"status": {
+		method: "GET",
+		path:   "/v1/stats",
+	},
+	"rehash": {
+		method: "POST",
+		path:   "/v1/rehash",
+	},
+	"shutdown": {
+		method: "POST",
+		path:   "/v1/shutdown",
+		args:   []string{"reason"},
+		body: func(args []string) any {
+			return map[string]string{"reason": args[0]}
+		},
+	},
+	"clients": {
+		method: "GET",
+		path:   "/v1/clients",
+	},
+	"whois": {
+		method:  "GET",
+		path:    "/v1/clients/%s",
+		args:    []string{"nick"},
+		minArgs: 1,
+	},
+	"kill": {
+		method:  "POST",
+		path:    "/v1/clients/%s/kill",
+		args:    []string{"nick", "reason"},
+		minArgs: 1,
+		body: func(args []string) any {
+			return map[string]string{"reason": args[1]}
+		},
+	},
+	"channels": {
+		method: "GET",
+		path:   "/v1/channels",
+	},
+	"channel": {
+		method:  "GET",
+		path:    "/v1/channels/%s",
+		args:    []string{"channel"},
+		minArgs: 1,
+	},
+	"klines": {
+		method: "GET",
+		path:   "/v1/klines",
+	},
+	"kline": {
+		method:  "POST",
+		path:    "/v1/klines",
+		args:    []string{"mask", "duration", "reason"},
+		minArgs: 1,
+		body: func(args []string) any {
+			return map[string]any{"mask": args[0], "duration": args[1], "reason": args[2], "kill": true}
+		},
+	},
+	"unkline": {
+		method:  "DELETE",
+		path:    "/v1/klines/%s",
+		args:    []string{"mask"},
+		minArgs: 1,
+	},
+}
+
+// ControlUsage describes the commands accepted by RunControlCommand.
+func ControlUsage() string {
+	var buf strings.Builder
+	buf.WriteString("commands:\n")
+	for _, name := range []string{"status", "rehash", "shutdown", "clients", "whois", "kill", "channels", "channel", "klines", "kline", "unkline"} {
+		buf.WriteString("\t")
+		buf.WriteString(name)
+		for i, arg := range controlCommands[name].args {
+			if i < controlCommands[name].minArgs {
+				fmt.Fprintf(&buf, " <%s>", arg)
+			} else {
+				fmt.Fprintf(&buf, " [<%s>]", arg)
+			}
+		}
+		buf.WriteString("\n")
+	}
+	return buf.String()
+}
+
+// RunControlCommand executes a command against the running server over its
+// control socket, writing the (JSON) result to `out`.
+func RunControlCommand(config *Config, name string, args []string, out io.Writer) error {
+	if config.Server.ControlSocket == "" {
+		return errors.New("The control socket is not enabled (server.control-socket)")
+	}
+	command, ok := controlCommands[name]
+	if !ok {
+		return fmt.Errorf("Unknown command %s\n%s", name, ControlUsage())
+	}
+	if len(args) < command.minArgs || (len(command.args) == 0 && len(args) != 0) {
+		return fmt.Errorf("Wrong number of arguments to %s\n%s", name, ControlUsage())
+	}
+	// fill in omitted optional arguments, and join any excess ones into the last
+	params := make([]string, len(command.args))
+	for i := range params {
+		if i == len(params)-1 && len(args) > i {
+			params[i] = strings.Join(args[i:], " ")
+		} else if i < len(args) {
+			params[i] = args[i]
+		}
+	}
+
+	path := command.path
+	if strings.Contains(path, "%s") {
+		path = fmt.Sprintf(path, url.PathEscape(params[0]))
+	}
+	var body io.Reader
+	if command.body != nil {
+		data, err := json.Marshal(command.body(params))
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(command.method, "http://control"+path, body)
+	if err != nil {
+		return err
+	}
+	socketPath := config.Server.ControlSocket
+	client := http.Client{
+		Timeout: controlTimeout,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, "unix", socketPath)
+			},
+		},
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(data, &apiErr) == nil && apiErr.Error != "" {
+			return errors.New(apiErr.Error)
+		}
+		return fmt.Errorf("HTTP status %d", resp.StatusCode)
+	}
+	var formatted bytes.Buffer
+	if json.Indent(&formatted, data, "", "  ") == nil {
+		data = formatted.Bytes()
+	}
+	_, err = out.Write(data)
+	return err
+}
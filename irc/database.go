@@ -18,6 +18,7 @@ import (
 	"github.com/ergochat/ergo/irc/datastore"
 	"github.com/ergochat/ergo/irc/modes"
 	"github.com/ergochat/ergo/irc/utils"
+	"github.com/ergochat/ergo/irc/webpush"
 
 	"github.com/tidwall/buntdb"
 )
@@ -33,6 +34,7 @@ const (
 var (
 	schemaVersionUUID = utils.UUID{0, 255, 85, 13, 212, 10, 191, 121, 245, 152, 142, 89, 97, 141, 219, 87}    // AP9VDdQKv3n1mI5ZYY3bVw
 	cloakSecretUUID   = utils.UUID{170, 214, 184, 208, 116, 181, 67, 75, 161, 23, 233, 16, 113, 251, 94, 229} // qta40HS1Q0uhF-kQcfte5Q
+	vapidKeysUUID     = utils.UUID{177, 249, 21, 229, 215, 254, 65, 107, 179, 79, 220, 183, 12, 60, 195, 68}  // sfkV5df-QWuzT9y3DDzDRA
 
 	keySchemaVersion = bunt.BuntKey(datastore.TableMetadata, schemaVersionUUID)
 	keyCloakSecret   = bunt.BuntKey(datastore.TableMetadata, cloakSecretUUID)
@@ -233,6 +235,30 @@ func StoreCloakSecret(dstore datastore.Datastore, secret string) {
 	dstore.Set(datastore.TableMetadata, cloakSecretUUID, []byte(secret), time.Time{})
 }
 
+// LoadVAPIDKeys loads the server's VAPID keypair for Web Push,
+// generating and storing a new one if none exists yet.
+func LoadVAPIDKeys(dstore datastore.Datastore) (keys *webpush.VAPIDKeys, err error) {
+	val, err := dstore.Get(datastore.TableMetadata, vapidKeysUUID)
+	if err == nil {
+		keys = new(webpush.VAPIDKeys)
+		err = json.Unmarshal(val, keys)
+		return
+	} else if err != buntdb.ErrNotFound {
+		return
+	}
+
+	keys, err = webpush.GenerateVAPIDKeys()
+	if err != nil {
+		return
+	}
+	val, err = json.Marshal(keys)
+	if err != nil {
+		return
+	}
+	err = dstore.Set(datastore.TableMetadata, vapidKeysUUID, val, time.Time{})
+	return
+}
+
 func schemaChangeV1toV2(config *Config, tx *buntdb.Tx) error {
 	// == version 1 -> 2 ==
 	// account key changes and account.verified key bugfix.
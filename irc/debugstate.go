@@ -0,0 +1,96 @@
+package irc
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"time"
+)
+
+// JSON dump of server state, served alongside pprof by debug.pprof-listener
+
+type debugSessionState struct {
+	ID            int64     `json:"id"`
+	IP            string    `json:"ip"`
+	Hostname      string    `json:"hostname"`
+	Connected     time.Time `json:"connected"`
+	LastActive    time.Time `json:"last-active"`
+	SendQ         int       `json:"sendq"`
+	SentBytes     uint64    `json:"sent-bytes"`
+	ReceivedBytes uint64    `json:"received-bytes"`
+}
+
+type debugClientState struct {
+	Nick       string              `json:"nick"`
+	Account    string              `json:"account,omitempty"`
+	Registered bool                `json:"registered"`
+	AlwaysOn   bool                `json:"always-on"`
+	Channels   int                 `json:"channels"`
+	Sessions   []debugSessionState `json:"sessions"`
+}
+
+type debugChannelState struct {
+	Name       string `json:"name"`
+	Members    int    `json:"members"`
+	Registered bool   `json:"registered"`
+}
+
+type debugServerState struct {
+	Version    string              `json:"version"`
+	Started    time.Time           `json:"started"`
+	Goroutines int                 `json:"goroutines"`
+	Stats      StatsValues         `json:"stats"`
+	Clients    []debugClientState  `json:"clients"`
+	Channels   []debugChannelState `json:"channels"`
+}
+
+func (server *Server) debugState() (result debugServerState) {
+	result.Version = Ver
+	result.Started = server.ctime
+	result.Goroutines = runtime.NumGoroutine()
+	result.Stats = server.stats.GetValues()
+
+	for _, client := range server.clients.AllClients() {
+		details := client.Details()
+		sessions, _ := client.AllSessionData(nil, true)
+		clientState := debugClientState{
+			Nick:       details.nick,
+			Registered: client.Registered(),
+			AlwaysOn:   client.AlwaysOn(),
+			Channels:   client.NumChannels(),
+			Sessions:   make([]debugSessionState, len(sessions)),
+		}
+		if details.account != "" {
+			clientState.Account = details.accountName
+		}
+		for i, session := range sessions {
+			clientState.Sessions[i] = debugSessionState{
+				ID:            session.sessionID,
+				IP:            session.ip.String(),
+				Hostname:      session.hostname,
+				Connected:     session.ctime,
+				LastActive:    session.atime,
+				SendQ:         session.sendQLen,
+				SentBytes:     session.traffic.SentBytes,
+				ReceivedBytes: session.traffic.ReceivedBytes,
+			}
+		}
+		result.Clients = append(result.Clients, clientState)
+	}
+
+	for _, channel := range server.channels.Channels() {
+		result.Channels = append(result.Channels, debugChannelState{
+			Name:       channel.Name(),
+			Members:    len(channel.Members()),
+			Registered: channel.IsRegistered(),
+		})
+	}
+	return
+}
+
+func (server *Server) serveDebugState(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(server.debugState())
+}
@@ -0,0 +1,171 @@
+package irc
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/ergochat/irc-go/ircfmt"
+
+	"github.com/ergochat/ergo/irc/discord"
+	"github.com/ergochat/ergo/irc/utils"
+)
+
+// the Discord bridge relays messages between IRC channels and Discord channels
+// via a Discord bot. messages from Discord are relayed into IRC like RELAYMSG,
+// with a nick of the form <display name><separator><nick-suffix>; messages from
+// IRC are posted by the bot in the form `<nick> message`.
+
+const (
+	discordQueueSize = 256
+	// value of the relaymsg tag for messages relayed from Discord,
+	// and the hostname of their spoofed NUH
+	discordRelayer = "discord"
+)
+
+var (
+	discordMarkdownEscaper = strings.NewReplacer(`\`, `\\`, "*", `\*`, "_", `\_`, "~", `\~`, "|", `\|`, "`", "\\`")
+)
+
+type discordOutgoing struct {
+	channelID string
+	content   string
+}
+
+// DiscordBridge manages the bridge's connection to Discord.
+type DiscordBridge struct {
+	sync.Mutex // tier 1
+
+	server   *Server
+	token    string
+	client   *discord.Client
+	outgoing chan discordOutgoing
+}
+
+// ApplyConfig (re)starts or stops the bridge as necessary; the channel
+// mappings are read from the current config as messages are relayed.
+func (bridge *DiscordBridge) ApplyConfig(server *Server, config *Config) {
+	bridge.Lock()
+	defer bridge.Unlock()
+
+	bridge.server = server
+	var token string
+	if config.Discord.Enabled {
+		token = config.Discord.Token
+	}
+	if token == bridge.token {
+		return
+	}
+
+	if bridge.client != nil {
+		bridge.client.Close()
+		close(bridge.outgoing)
+		bridge.client, bridge.outgoing = nil, nil
+	}
+	bridge.token = token
+	if token == "" {
+		return
+	}
+
+	var client *discord.Client
+	client = discord.NewClient(token, server.logger, func(message *discord.Message) {
+		bridge.relayFromDiscord(client, message)
+	})
+	outgoing := make(chan discordOutgoing, discordQueueSize)
+	bridge.client, bridge.outgoing = client, outgoing
+	go client.Run()
+	go bridge.processOutgoing(client, outgoing)
+}
+
+func (bridge *DiscordBridge) processOutgoing(client *discord.Client, outgoing chan discordOutgoing) {
+	for item := range outgoing {
+		if err := client.SendMessage(item.channelID, item.content); err != nil {
+			bridge.server.logger.Warning("discord", "Couldn't relay message to Discord channel", item.channelID, err.Error())
+		}
+	}
+}
+
+// RelayFromIRC posts a message sent to an IRC channel to its bridged
+// Discord channel, if it has one.
+func (bridge *DiscordBridge) RelayFromIRC(channel *Channel, nick string, message utils.SplitMessage) {
+	if bridge.server == nil {
+		return
+	}
+	config := bridge.server.Config()
+	if !config.Discord.Enabled {
+		return
+	}
+	channelID, ok := config.Discord.ircToDiscord[channel.NameCasefolded()]
+	if !ok {
+		return
+	}
+	content := formatMessageForDiscord(nick, message)
+	if content == "" {
+		return
+	}
+
+	bridge.Lock()
+	defer bridge.Unlock()
+	if bridge.outgoing == nil {
+		return
+	}
+	select {
+	case bridge.outgoing <- discordOutgoing{channelID: channelID, content: content}:
+	default:
+		bridge.server.logger.Warning("discord", "Outgoing queue is full, dropping message for", channel.Name())
+	}
+}
+
+func (bridge *DiscordBridge) relayFromDiscord(client *discord.Client, dMessage *discord.Message) {
+	server := bridge.server
+	defer server.HandlePanic()
+
+	if dMessage.Author.ID == client.UserID() {
+		return // our own message, relayed from IRC
+	}
+	config := server.Config()
+	chname, ok := config.Discord.discordToIRC[dMessage.ChannelID]
+	if !ok {
+		return
+	}
+	channel := server.channels.Get(chname)
+	if channel == nil {
+		return
+	}
+	message, ok := makeRelayedSplitMessage(dMessage.Text())
+	if !ok {
+		return
+	}
+
+	nick := discordRelayNick(config, dMessage)
+	if err := bridgeRelayMessage(channel, nick, discordRelayer, message); err != nil {
+		server.logger.Debug("discord", "Couldn't compute a relay nick for Discord user", dMessage.Author.ID)
+	}
+}
+
+// discordRelayNick computes the relaymsg nick for the author of a Discord message
+func discordRelayNick(config *Config, dMessage *discord.Message) string {
+	return bridgeRelayNick(config, config.Discord.NickSuffix, "u"+dMessage.Author.ID, dMessage.DisplayName(), dMessage.Author.Username)
+}
+
+// formatMessageForDiscord renders an IRC channel message for the bot to post,
+// or returns "" if the message shouldn't be relayed (e.g., non-ACTION CTCP)
+func formatMessageForDiscord(nick string, message utils.SplitMessage) string {
+	text := flattenSplitMessage(message)
+
+	escapedNick := discordMarkdownEscaper.Replace(nick)
+	var content string
+	if strings.HasPrefix(text, "\x01ACTION ") {
+		action := strings.TrimSuffix(strings.TrimPrefix(text, "\x01ACTION "), "\x01")
+		content = fmt.Sprintf("\\* %s %s", escapedNick, ircfmt.Strip(action))
+	} else if strings.HasPrefix(text, "\x01") {
+		return ""
+	} else {
+		content = fmt.Sprintf("<%s> %s", escapedNick, ircfmt.Strip(text))
+	}
+	if discord.MaxContentLen < utf8.RuneCountInString(content) {
+		content = string([]rune(content)[:discord.MaxContentLen])
+	}
+	return content
+}
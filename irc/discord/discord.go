@@ -0,0 +1,464 @@
+// Package discord implements the small subset of the Discord bot API
+// needed to relay messages: a gateway (websocket) client that receives
+// messages, and the REST call that sends them.
+package discord
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/ergochat/ergo/irc/logger"
+)
+
+const (
+	DefaultGatewayURL = "wss://gateway.discord.gg/?v=10&encoding=json"
+	DefaultAPIURL     = "https://discord.com/api/v10"
+
+	// Discord's limit on the length of a message, in characters
+	MaxContentLen = 2000
+
+	// GUILDS, GUILD_MESSAGES, and the privileged MESSAGE_CONTENT intent
+	intents = 1<<0 | 1<<9 | 1<<15
+
+	opDispatch       = 0
+	opHeartbeat      = 1
+	opIdentify       = 2
+	opReconnect      = 7
+	opInvalidSession = 9
+	opHello          = 10
+	opHeartbeatACK   = 11
+
+	handshakeTimeout = 30 * time.Second
+	requestTimeout   = 30 * time.Second
+	minBackoff       = time.Second
+	maxBackoff       = 2 * time.Minute
+	// a session that lasts at least this long resets the reconnection backoff
+	stableSessionDuration = time.Minute
+	maxRateLimitRetries   = 3
+)
+
+var (
+	errNoHello       = errors.New("gateway did not send HELLO")
+	errZombie        = errors.New("gateway stopped acknowledging heartbeats")
+	errReconnect     = errors.New("gateway requested a reconnect")
+	errRateLimited   = errors.New("rate limited by the Discord API")
+	errClientClosed  = errors.New("client closed")
+	mentionRegexp    = regexp.MustCompile(`<@!?([0-9]+)>`)
+	customEmojiRegex = regexp.MustCompile(`<a?(:[A-Za-z0-9_]+:)[0-9]+>`)
+)
+
+// User is a Discord user.
+type User struct {
+	ID         string `json:"id"`
+	Username   string `json:"username"`
+	GlobalName string `json:"global_name"`
+	Bot        bool   `json:"bot"`
+}
+
+// Member is the guild-specific information about a user.
+type Member struct {
+	Nick string `json:"nick"`
+}
+
+// MentionedUser is a user mentioned in a message.
+type MentionedUser struct {
+	User
+	Member *Member `json:"member"`
+}
+
+// Attachment is a file attached to a message.
+type Attachment struct {
+	URL      string `json:"url"`
+	Filename string `json:"filename"`
+}
+
+// Message is a message posted in a Discord channel.
+type Message struct {
+	ID          string          `json:"id"`
+	ChannelID   string          `json:"channel_id"`
+	Content     string          `json:"content"`
+	Author      User            `json:"author"`
+	Member      *Member         `json:"member"`
+	Mentions    []MentionedUser `json:"mentions"`
+	Attachments []Attachment    `json:"attachments"`
+	WebhookID   string          `json:"webhook_id"`
+}
+
+func displayName(user User, member *Member) string {
+	if member != nil && member.Nick != "" {
+		return member.Nick
+	} else if user.GlobalName != "" {
+		return user.GlobalName
+	}
+	return user.Username
+}
+
+// DisplayName returns the name the author is shown with in the guild.
+func (m *Message) DisplayName() string {
+	return displayName(m.Author, m.Member)
+}
+
+// Text returns a plain-text rendering of the message: user mentions and
+// custom emoji are replaced with readable names, and attachments are
+// appended as links.
+func (m *Message) Text() string {
+	text := mentionRegexp.ReplaceAllStringFunc(m.Content, func(mention string) string {
+		id := mentionRegexp.FindStringSubmatch(mention)[1]
+		for _, user := range m.Mentions {
+			if user.ID == id {
+				return "@" + displayName(user.User, user.Member)
+			}
+		}
+		return mention
+	})
+	text = customEmojiRegex.ReplaceAllString(text, "$1")
+	for _, attachment := range m.Attachments {
+		if text != "" {
+			text += "\n"
+		}
+		text += attachment.URL
+	}
+	return text
+}
+
+type payload struct {
+	Op       int             `json:"op"`
+	Data     json.RawMessage `json:"d"`
+	Sequence *int64          `json:"s,omitempty"`
+	Type     string          `json:"t,omitempty"`
+}
+
+// FatalError is a gateway error that reconnecting will not fix,
+// e.g., an invalid token or missing intents.
+type FatalError struct {
+	Code int
+	Text string
+}
+
+func (f *FatalError) Error() string {
+	return fmt.Sprintf("fatal gateway error %d: %s", f.Code, f.Text)
+}
+
+func isFatalCloseCode(code int) bool {
+	switch code {
+	// authentication failed, invalid shard, sharding required,
+	// invalid API version, invalid intents, disallowed intents
+	case 4004, 4010, 4011, 4012, 4013, 4014:
+		return true
+	default:
+		return false
+	}
+}
+
+// Client is a Discord bot client.
+type Client struct {
+	token      string
+	logger     *logger.Manager
+	handler    func(*Message)
+	GatewayURL string
+	APIURL     string
+	httpClient *http.Client
+
+	userID   atomic.Pointer[string]
+	sequence atomic.Int64 // 0 if no dispatch has been received
+
+	stateMutex sync.Mutex
+	conn       *websocket.Conn
+	closed     bool
+	quit       chan struct{}
+}
+
+// NewClient returns a new client; `handler` is called, on the gateway
+// goroutine, for every message received.
+func NewClient(token string, logger *logger.Manager, handler func(*Message)) *Client {
+	return &Client{
+		token:      token,
+		logger:     logger,
+		handler:    handler,
+		GatewayURL: DefaultGatewayURL,
+		APIURL:     DefaultAPIURL,
+		httpClient: &http.Client{Timeout: requestTimeout},
+		quit:       make(chan struct{}),
+	}
+}
+
+// UserID returns the bot's own user ID, once it is known.
+func (c *Client) UserID() string {
+	if id := c.userID.Load(); id != nil {
+		return *id
+	}
+	return ""
+}
+
+// Close disconnects the client and stops Run.
+func (c *Client) Close() {
+	c.stateMutex.Lock()
+	defer c.stateMutex.Unlock()
+	if c.closed {
+		return
+	}
+	c.closed = true
+	close(c.quit)
+	if c.conn != nil {
+		c.conn.Close()
+	}
+}
+
+func (c *Client) setConn(conn *websocket.Conn) error {
+	c.stateMutex.Lock()
+	defer c.stateMutex.Unlock()
+	if c.closed {
+		return errClientClosed
+	}
+	c.conn = conn
+	return nil
+}
+
+// Run connects to the gateway and processes events, reconnecting as
+// necessary, until the client is closed or a fatal error occurs.
+func (c *Client) Run() {
+	backoff := minBackoff
+	for {
+		start := time.Now()
+		err := c.runSession()
+		select {
+		case <-c.quit:
+			return
+		default:
+		}
+		var fatal *FatalError
+		if errors.As(err, &fatal) {
+			c.logger.Error("discord", "Giving up on the gateway connection", err.Error())
+			return
+		}
+		c.logger.Warning("discord", "Gateway connection lost", err.Error())
+
+		if stableSessionDuration < time.Since(start) {
+			backoff = minBackoff
+		}
+		select {
+		case <-time.After(backoff):
+		case <-c.quit:
+			return
+		}
+		backoff = min(2*backoff, maxBackoff)
+	}
+}
+
+func (c *Client) runSession() (err error) {
+	dialer := websocket.Dialer{HandshakeTimeout: handshakeTimeout}
+	conn, _, err := dialer.Dial(c.GatewayURL, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	if err = c.setConn(conn); err != nil {
+		return
+	}
+
+	var writeMutex sync.Mutex
+	send := func(op int, data any) error {
+		rawData, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+		writeMutex.Lock()
+		defer writeMutex.Unlock()
+		conn.SetWriteDeadline(time.Now().Add(handshakeTimeout))
+		return conn.WriteJSON(payload{Op: op, Data: rawData})
+	}
+
+	var hello payload
+	conn.SetReadDeadline(time.Now().Add(handshakeTimeout))
+	if err = conn.ReadJSON(&hello); err != nil {
+		return c.convertError(err)
+	}
+	var helloData struct {
+		HeartbeatInterval int64 `json:"heartbeat_interval"`
+	}
+	if hello.Op != opHello || json.Unmarshal(hello.Data, &helloData) != nil || helloData.HeartbeatInterval <= 0 {
+		return errNoHello
+	}
+	heartbeatInterval := time.Duration(helloData.HeartbeatInterval) * time.Millisecond
+
+	identify := map[string]any{
+		"token":   c.token,
+		"intents": intents,
+		"properties": map[string]string{
+			"os":      "linux",
+			"browser": "ergo",
+			"device":  "ergo",
+		},
+	}
+	if err = send(opIdentify, identify); err != nil {
+		return
+	}
+
+	// heartbeat until the session ends; if the previous heartbeat was never
+	// acknowledged, the connection is a zombie and must be closed
+	var acked atomic.Bool
+	acked.Store(true)
+	var zombie atomic.Bool
+	sessionDone := make(chan struct{})
+	defer close(sessionDone)
+	heartbeat := func() error {
+		var seq *int64
+		if s := c.sequence.Load(); s != 0 {
+			seq = &s
+		}
+		return send(opHeartbeat, seq)
+	}
+	go func() {
+		ticker := time.NewTicker(heartbeatInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if !acked.Swap(false) {
+					zombie.Store(true)
+					conn.Close()
+					return
+				}
+				if heartbeat() != nil {
+					conn.Close()
+					return
+				}
+			case <-sessionDone:
+				return
+			}
+		}
+	}()
+
+	for {
+		var p payload
+		conn.SetReadDeadline(time.Now().Add(2 * heartbeatInterval))
+		if err = conn.ReadJSON(&p); err != nil {
+			if zombie.Load() {
+				return errZombie
+			}
+			return c.convertError(err)
+		}
+		if p.Sequence != nil {
+			c.sequence.Store(*p.Sequence)
+		}
+		switch p.Op {
+		case opDispatch:
+			c.dispatch(p.Type, p.Data)
+		case opHeartbeat:
+			if err = heartbeat(); err != nil {
+				return
+			}
+		case opHeartbeatACK:
+			acked.Store(true)
+		case opReconnect, opInvalidSession:
+			// we don't implement RESUME: start over with a fresh session
+			c.sequence.Store(0)
+			return errReconnect
+		}
+	}
+}
+
+func (c *Client) convertError(err error) error {
+	var closeErr *websocket.CloseError
+	if errors.As(err, &closeErr) && isFatalCloseCode(closeErr.Code) {
+		return &FatalError{Code: closeErr.Code, Text: closeErr.Text}
+	}
+	return err
+}
+
+func (c *Client) dispatch(eventType string, data json.RawMessage) {
+	switch eventType {
+	case "READY":
+		var ready struct {
+			User User `json:"user"`
+		}
+		if err := json.Unmarshal(data, &ready); err != nil {
+			c.logger.Warning("discord", "Invalid READY event", err.Error())
+			return
+		}
+		c.userID.Store(&ready.User.ID)
+		c.logger.Info("discord", "Connected to the gateway as", ready.User.Username)
+	case "MESSAGE_CREATE":
+		var message Message
+		if err := json.Unmarshal(data, &message); err != nil {
+			c.logger.Warning("discord", "Invalid MESSAGE_CREATE event", err.Error())
+			return
+		}
+		c.handler(&message)
+	}
+}
+
+// SendMessage posts `content` to the Discord channel `channelID`.
+// Mentions in the content are never resolved into pings.
+func (c *Client) SendMessage(channelID, content string) (err error) {
+	body, err := json.Marshal(map[string]any{
+		"content": content,
+		"allowed_mentions": map[string]any{
+			"parse": []string{},
+		},
+	})
+	if err != nil {
+		return
+	}
+	endpoint := fmt.Sprintf("%s/channels/%s/messages", c.APIURL, url.PathEscape(channelID))
+
+	for i := 0; i < maxRateLimitRetries; i++ {
+		var retryAfter time.Duration
+		retryAfter, err = c.post(endpoint, body)
+		if err != errRateLimited {
+			return
+		}
+		select {
+		case <-time.After(retryAfter):
+		case <-c.quit:
+			return errClientClosed
+		}
+	}
+	return
+}
+
+func (c *Client) post(endpoint string, body []byte) (retryAfter time.Duration, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return
+	}
+	req.Header.Set("Authorization", "Bot "+c.token)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "DiscordBot (https://ergo.chat, 1)")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 65536))
+
+	switch {
+	case 200 <= resp.StatusCode && resp.StatusCode < 300:
+		return 0, nil
+	case resp.StatusCode == http.StatusTooManyRequests:
+		var rateLimit struct {
+			RetryAfter float64 `json:"retry_after"`
+		}
+		json.Unmarshal(respBody, &rateLimit)
+		retryAfter = time.Duration(rateLimit.RetryAfter * float64(time.Second))
+		return min(max(retryAfter, 100*time.Millisecond), maxBackoff), errRateLimited
+	default:
+		return 0, fmt.Errorf("Discord API returned HTTP status %d: %s", resp.StatusCode, strings.TrimSpace(string(respBody)))
+	}
+}
@@ -0,0 +1,161 @@
+package discord
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/ergochat/ergo/irc/logger"
+)
+
+func newTestLogger(t *testing.T) *logger.Manager {
+	manager, err := logger.NewManager(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return manager
+}
+
+func TestMessageText(t *testing.T) {
+	var message Message
+	err := json.Unmarshal([]byte(`{
+		"content": "hi <@123> and <@!456>, <:blobcat:789> <@999>",
+		"author": {"id": "1", "username": "alice", "global_name": "Alice"},
+		"member": {"nick": "ally"},
+		"mentions": [
+			{"id": "123", "username": "bob"},
+			{"id": "456", "username": "carol", "global_name": "Carol", "member": {"nick": "caz"}}
+		],
+		"attachments": [{"url": "https://cdn.example.com/a.png", "filename": "a.png"}]
+	}`), &message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if message.DisplayName() != "ally" {
+		t.Errorf("unexpected display name %s", message.DisplayName())
+	}
+	expected := "hi @bob and @caz, :blobcat: <@999>\nhttps://cdn.example.com/a.png"
+	if message.Text() != expected {
+		t.Errorf("unexpected text %#v", message.Text())
+	}
+}
+
+func TestGateway(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.WriteJSON(payload{Op: opHello, Data: json.RawMessage(`{"heartbeat_interval": 100}`)})
+		var identify payload
+		if conn.ReadJSON(&identify) != nil || identify.Op != opIdentify || !strings.Contains(string(identify.Data), `"token":"secret"`) {
+			conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(4004, "Authentication failed."))
+			return
+		}
+		seq := int64(1)
+		conn.WriteJSON(payload{Op: opDispatch, Type: "READY", Sequence: &seq, Data: json.RawMessage(`{"user": {"id": "42", "username": "bridge"}}`)})
+		seq = 2
+		conn.WriteJSON(payload{Op: opDispatch, Type: "MESSAGE_CREATE", Sequence: &seq, Data: json.RawMessage(`{"channel_id": "7", "content": "hello", "author": {"id": "1", "username": "alice"}}`)})
+		for {
+			var p payload
+			if conn.ReadJSON(&p) != nil {
+				return
+			}
+			if p.Op == opHeartbeat {
+				conn.WriteJSON(payload{Op: opHeartbeatACK})
+			}
+		}
+	}))
+	defer server.Close()
+	gatewayURL := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	messages := make(chan *Message, 1)
+	client := NewClient("secret", newTestLogger(t), func(m *Message) { messages <- m })
+	client.GatewayURL = gatewayURL
+	done := make(chan struct{})
+	go func() {
+		client.Run()
+		close(done)
+	}()
+	select {
+	case message := <-messages:
+		if message.ChannelID != "7" || message.Text() != "hello" || message.Author.Username != "alice" {
+			t.Errorf("unexpected message %#v", message)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for message")
+	}
+	if client.UserID() != "42" {
+		t.Errorf("unexpected user id %s", client.UserID())
+	}
+	// survive a few heartbeats, then shut down cleanly
+	time.Sleep(300 * time.Millisecond)
+	client.Close()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after Close")
+	}
+
+	// an invalid token is fatal: Run gives up instead of reconnecting
+	client = NewClient("wrong", newTestLogger(t), func(*Message) {})
+	client.GatewayURL = gatewayURL
+	done = make(chan struct{})
+	go func() {
+		client.Run()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		client.Close()
+		t.Fatal("Run did not give up after a fatal error")
+	}
+}
+
+func TestSendMessage(t *testing.T) {
+	var requests atomic.Int32
+	var body map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/channels/7/messages" || r.Header.Get("Authorization") != "Bot secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if requests.Add(1) == 1 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			io.WriteString(w, `{"retry_after": 0.01, "global": false}`)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	client := NewClient("secret", newTestLogger(t), func(*Message) {})
+	client.APIURL = server.URL
+	if err := client.SendMessage("7", "hi @everyone"); err != nil {
+		t.Fatal(err)
+	}
+	if requests.Load() != 2 {
+		t.Errorf("expected a retry after the rate limit, got %d requests", requests.Load())
+	}
+	if body["content"] != "hi @everyone" {
+		t.Errorf("unexpected content %v", body["content"])
+	}
+	if parse := body["allowed_mentions"].(map[string]any)["parse"].([]any); len(parse) != 0 {
+		t.Errorf("mentions should not be parsed: %v", parse)
+	}
+
+	if err := client.SendMessage("8", "hi"); err == nil {
+		t.Errorf("expected an error for an unauthorized request")
+	}
+}
@@ -0,0 +1,58 @@
+package irc
+
+import (
+	"testing"
+
+	"github.com/ergochat/ergo/irc/discord"
+	"github.com/ergochat/ergo/irc/utils"
+)
+
+func TestDiscordRelayNick(t *testing.T) {
+	config := &Config{}
+	config.Server.Relaymsg.Separators = "/"
+	config.Limits.NickLen = 16
+	config.Discord.NickSuffix = "d"
+
+	cases := []struct {
+		displayName string
+		username    string
+		expected    string
+	}{
+		{"Alice", "alice", "Alice/d"},
+		{"  Bob Smith!", "bob", "Bob_Smith/d"},
+		{"a/b@c", "x", "abc/d"},
+		{"#chan", "x", "chan/d"},
+		{"???", "carol", "carol/d"},
+		{"a very long display name", "x", "a_very_long_di/d"},
+		{"", "", "u1234/d"},
+	}
+	for _, c := range cases {
+		message := &discord.Message{Author: discord.User{ID: "1234", Username: c.username, GlobalName: c.displayName}}
+		if nick := discordRelayNick(config, message); nick != c.expected {
+			t.Errorf("%#v: expected %#v, got %#v", c.displayName, c.expected, nick)
+		}
+	}
+}
+
+func TestFormatMessageForDiscord(t *testing.T) {
+	cases := map[string]string{
+		"hello":                     "<a\\_b> hello",
+		"\x02bold\x02 text":         "<a\\_b> bold text",
+		"\x01ACTION waves\x01":      "\\* a\\_b waves",
+		"\x01VERSION\x01":           "",
+		"ping <@123> @everyone now": "<a\\_b> ping <@123> @everyone now",
+	}
+	for text, expected := range cases {
+		if content := formatMessageForDiscord("a_b", utils.MakeMessage(text)); content != expected {
+			t.Errorf("%#v: expected %#v, got %#v", text, expected, content)
+		}
+	}
+
+	var multiline utils.SplitMessage
+	multiline.Append("line one", false)
+	multiline.Append(" continued", true)
+	multiline.Append("line two", false)
+	if content := formatMessageForDiscord("nick", multiline); content != "<nick> line one continued\nline two" {
+		t.Errorf("unexpected multiline rendering %#v", content)
+	}
+}
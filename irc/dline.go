@@ -65,6 +65,9 @@ type DLineManager struct {
 	persistenceMutex sync.Mutex // tier 2
 	// networks that are dlined:
 	networks map[flatip.IPNet]IPBanInfo
+	// number of dlined networks with each prefix length; CheckIP only needs to
+	// look up the masked address for the prefix lengths that are in use
+	prefixLengths [129]uint32
 	// this keeps track of expiration timers for temporary bans
 	expirationTimers map[flatip.IPNet]*time.Timer
 	server           *Server
@@ -129,7 +132,7 @@ func (dm *DLineManager) addNetworkInternal(flatnet flatip.IPNet, info IPBanInfo)
 	dm.Lock()
 	defer dm.Unlock()
 
-	dm.networks[flatnet] = info
+	dm.setNetwork(flatnet, info)
 
 	dm.cancelTimer(flatnet)
 
@@ -145,8 +148,7 @@ func (dm *DLineManager) addNetworkInternal(flatnet flatip.IPNet, info IPBanInfo)
 
 		banInfo, ok := dm.networks[flatnet]
 		if ok && banInfo.TimeCreated.Equal(timeCreated) {
-			delete(dm.networks, flatnet)
-			// TODO(slingamn) here's where we'd remove it from the radix tree
+			dm.deleteNetwork(flatnet)
 			delete(dm.expirationTimers, flatnet)
 		}
 	}
@@ -155,6 +157,22 @@ func (dm *DLineManager) addNetworkInternal(flatnet flatip.IPNet, info IPBanInfo)
 	return
 }
 
+// setNetwork and deleteNetwork must be called with the write lock held
+func (dm *DLineManager) setNetwork(flatnet flatip.IPNet, info IPBanInfo) {
+	if _, ok := dm.networks[flatnet]; !ok {
+		dm.prefixLengths[flatnet.PrefixLen]++
+	}
+	dm.networks[flatnet] = info
+}
+
+func (dm *DLineManager) deleteNetwork(flatnet flatip.IPNet) (present bool) {
+	if _, present = dm.networks[flatnet]; present {
+		delete(dm.networks, flatnet)
+		dm.prefixLengths[flatnet.PrefixLen]--
+	}
+	return
+}
+
 func (dm *DLineManager) cancelTimer(flatnet flatip.IPNet) {
 	oldTimer := dm.expirationTimers[flatnet]
 	if oldTimer != nil {
@@ -206,8 +224,7 @@ func (dm *DLineManager) RemoveNetwork(network flatip.IPNet) error {
 	present := func() bool {
 		dm.Lock()
 		defer dm.Unlock()
-		_, ok := dm.networks[id]
-		delete(dm.networks, id)
+		ok := dm.deleteNetwork(id)
 		dm.cancelTimer(id)
 		return ok
 	}()
@@ -224,10 +241,15 @@ func (dm *DLineManager) CheckIP(addr flatip.IP) (isBanned bool, info IPBanInfo)
 	dm.RLock()
 	defer dm.RUnlock()
 
-	// check networks
-	// TODO(slingamn) use a radix tree as the data plane for this
-	for flatnet, info := range dm.networks {
-		if flatnet.Contains(addr) {
+	// networks are stored masked, so for each prefix length in use, addr is
+	// contained in a dlined network iff the masked addr is a key of the map.
+	// this is at most 129 lookups, regardless of the number of dlines.
+	for prefixLen, count := range dm.prefixLengths {
+		if count == 0 {
+			continue
+		}
+		flatnet := flatip.IPNet{IP: addr.Mask(prefixLen, 128), PrefixLen: uint8(prefixLen)}
+		if info, ok := dm.networks[flatnet]; ok {
 			return true, info
 		}
 	}
@@ -0,0 +1,67 @@
+package irc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ergochat/ergo/irc/flatip"
+)
+
+func newTestDLineManager() *DLineManager {
+	return &DLineManager{
+		networks:         make(map[flatip.IPNet]IPBanInfo),
+		expirationTimers: make(map[flatip.IPNet]*time.Timer),
+	}
+}
+
+func mustParseNet(t *testing.T, netstr string) flatip.IPNet {
+	network, err := flatip.ParseToNormalizedNet(netstr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return network
+}
+
+func mustParseIP(t *testing.T, ipstr string) flatip.IP {
+	ip, err := flatip.ParseIP(ipstr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ip
+}
+
+func TestDLineCheckIP(t *testing.T) {
+	dm := newTestDLineManager()
+	dm.addNetworkInternal(mustParseNet(t, "192.168.0.0/16"), IPBanInfo{Reason: "lan", TimeCreated: time.Now()})
+	dm.addNetworkInternal(mustParseNet(t, "8.8.8.8"), IPBanInfo{Reason: "dns", TimeCreated: time.Now()})
+	dm.addNetworkInternal(mustParseNet(t, "2001:db8::/32"), IPBanInfo{Reason: "doc", TimeCreated: time.Now()})
+
+	cases := map[string]string{
+		"192.168.1.1":   "lan",
+		"192.168.255.0": "lan",
+		"8.8.8.8":       "dns",
+		"2001:db8::1":   "doc",
+		"8.8.4.4":       "",
+		"192.169.0.1":   "",
+		"2001:db9::1":   "",
+	}
+	for ipstr, reason := range cases {
+		banned, info := dm.CheckIP(mustParseIP(t, ipstr))
+		if banned != (reason != "") || info.Reason != reason {
+			t.Errorf("%s: expected ban reason %#v, got %v %#v", ipstr, reason, banned, info.Reason)
+		}
+	}
+
+	// overwriting and then removing a ban must leave no trace in the index
+	dm.addNetworkInternal(mustParseNet(t, "192.168.0.0/16"), IPBanInfo{Reason: "lan2", TimeCreated: time.Now()})
+	if _, info := dm.CheckIP(mustParseIP(t, "192.168.1.1")); info.Reason != "lan2" {
+		t.Errorf("expected updated ban reason, got %#v", info.Reason)
+	}
+	dm.deleteNetwork(mustParseNet(t, "192.168.0.0/16"))
+	if banned, _ := dm.CheckIP(mustParseIP(t, "192.168.1.1")); banned {
+		t.Errorf("removed ban should not match")
+	}
+	if dm.prefixLengths[96+16] != 0 {
+		t.Errorf("prefix length count not decremented: %d", dm.prefixLengths[96+16])
+	}
+}
@@ -0,0 +1,259 @@
+package irc
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultDNSBLTimeout  = 5 * time.Second
+	maxDNSBLCacheEntries = 16384
+)
+
+type dnsblAction uint
+
+const (
+	dnsblReject dnsblAction = iota
+	dnsblRequireSASL
+)
+
+// DNSBLConfig controls checking of new connections against DNS blacklists.
+type DNSBLConfig struct {
+	Enabled               bool
+	Timeout               time.Duration
+	CacheDuration         time.Duration `yaml:"cache-duration"`
+	NegativeCacheDuration time.Duration `yaml:"negative-cache-duration"`
+	Lists                 []DNSBLListConfig
+}
+
+// DNSBLListConfig is a single DNS blacklist zone (e.g., dnsbl.dronebl.org).
+type DNSBLListConfig struct {
+	Host    string
+	Action  string
+	action  dnsblAction
+	Replies []string
+	replies map[string]struct{}
+	Reason  string
+}
+
+func (conf *DNSBLConfig) prepare() (err error) {
+	if !conf.Enabled {
+		return nil
+	}
+	if conf.Timeout == 0 {
+		conf.Timeout = defaultDNSBLTimeout
+	}
+	for i := range conf.Lists {
+		list := &conf.Lists[i]
+		list.Host = strings.TrimSuffix(strings.TrimSpace(list.Host), ".")
+		if list.Host == "" {
+			return fmt.Errorf("DNSBL entry %d has no host", i)
+		}
+		switch strings.ToLower(list.Action) {
+		case "", "reject":
+			list.action = dnsblReject
+		case "require-sasl":
+			list.action = dnsblRequireSASL
+		default:
+			return fmt.Errorf("invalid action for DNSBL %s: %s", list.Host, list.Action)
+		}
+		if len(list.Replies) != 0 {
+			list.replies = make(map[string]struct{}, len(list.Replies))
+			for _, reply := range list.Replies {
+				replyIP := net.ParseIP(reply)
+				if replyIP == nil {
+					return fmt.Errorf("invalid reply for DNSBL %s: %s", list.Host, reply)
+				}
+				list.replies[replyIP.String()] = struct{}{}
+			}
+		}
+		if list.Reason == "" {
+			list.Reason = fmt.Sprintf("Your IP address is listed in %s", list.Host)
+		}
+	}
+	return nil
+}
+
+// dnsblQueryName returns the name to look up to check `ip` against the DNSBL `zone`:
+// the octets (IPv4) or nibbles (IPv6) of the address in reverse order, prepended to the zone.
+func dnsblQueryName(ip net.IP, zone string) string {
+	var buf strings.Builder
+	if ip4 := ip.To4(); ip4 != nil {
+		for i := len(ip4) - 1; i >= 0; i-- {
+			fmt.Fprintf(&buf, "%d.", ip4[i])
+		}
+	} else {
+		const hexDigits = "0123456789abcdef"
+		ip16 := ip.To16()
+		for i := len(ip16) - 1; i >= 0; i-- {
+			buf.WriteByte(hexDigits[ip16[i]&0xf])
+			buf.WriteByte('.')
+			buf.WriteByte(hexDigits[ip16[i]>>4])
+			buf.WriteByte('.')
+		}
+	}
+	buf.WriteString(zone)
+	return buf.String()
+}
+
+// listed returns whether the DNS answers for a query indicate that the IP is listed
+func (list *DNSBLListConfig) listed(answers []string) bool {
+	for _, answer := range answers {
+		answerIP := net.ParseIP(answer)
+		if answerIP == nil || !answerIP.IsLoopback() {
+			// DNSBLs answer in 127.0.0.0/8; anything else (e.g., a wildcard record) is bogus
+			continue
+		}
+		if list.replies == nil {
+			return true
+		}
+		if _, ok := list.replies[answerIP.String()]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// dnsblResult is the outcome of checking an IP against the DNSBLs: the list
+// with the most severe action that the IP is listed in, or "" if none
+type dnsblResult struct {
+	host   string
+	action dnsblAction
+	reason string
+}
+
+// checkDNSBLs queries all configured DNSBLs in parallel.
+func checkDNSBLs(config *DNSBLConfig, ip net.IP) dnsblResult {
+	ctx, cancel := context.WithTimeout(context.Background(), config.Timeout)
+	defer cancel()
+
+	var result *DNSBLListConfig
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	for i := range config.Lists {
+		list := &config.Lists[i]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			answers, err := net.DefaultResolver.LookupHost(ctx, dnsblQueryName(ip, list.Host))
+			if err != nil || !list.listed(answers) {
+				return
+			}
+			mutex.Lock()
+			defer mutex.Unlock()
+			if result == nil || list.action < result.action {
+				result = list
+			}
+		}()
+	}
+	wg.Wait()
+	if result == nil {
+		return dnsblResult{}
+	}
+	return dnsblResult{host: result.Host, action: result.action, reason: result.Reason}
+}
+
+type dnsblCacheEntry struct {
+	result  dnsblResult
+	expires time.Time
+}
+
+// DNSBLCache is a bounded in-memory cache of DNSBL results, positive and negative.
+type DNSBLCache struct {
+	sync.Mutex // tier 1
+
+	entries map[string]dnsblCacheEntry
+}
+
+func (cache *DNSBLCache) Get(key string, now time.Time) (result dnsblResult, ok bool) {
+	cache.Lock()
+	defer cache.Unlock()
+
+	entry, ok := cache.entries[key]
+	if !ok {
+		return
+	}
+	if now.After(entry.expires) {
+		delete(cache.entries, key)
+		return result, false
+	}
+	return entry.result, true
+}
+
+func (cache *DNSBLCache) Add(key string, result dnsblResult, duration time.Duration, now time.Time) {
+	cache.Lock()
+	defer cache.Unlock()
+
+	if cache.entries == nil {
+		cache.entries = make(map[string]dnsblCacheEntry)
+	}
+	if _, ok := cache.entries[key]; !ok && len(cache.entries) >= maxDNSBLCacheEntries {
+		for k, entry := range cache.entries {
+			if now.After(entry.expires) {
+				delete(cache.entries, k)
+			}
+		}
+		// still full: evict an arbitrary entry
+		for k := range cache.entries {
+			if len(cache.entries) < maxDNSBLCacheEntries {
+				break
+			}
+			delete(cache.entries, k)
+		}
+	}
+	cache.entries[key] = dnsblCacheEntry{result: result, expires: now.Add(duration)}
+}
+
+// Clear discards all cached results, e.g., because the lists changed.
+func (cache *DNSBLCache) Clear() {
+	cache.Lock()
+	defer cache.Unlock()
+
+	cache.entries = nil
+}
+
+// dnsblLookup is a DNSBL check that runs concurrently with registration.
+type dnsblLookup struct {
+	done   chan struct{}
+	result dnsblResult
+}
+
+// Wait returns the result of the lookup, blocking until it is complete.
+func (lookup *dnsblLookup) Wait() dnsblResult {
+	<-lookup.done
+	return lookup.result
+}
+
+// startDNSBLLookup starts checking `ip` against the DNSBLs when a connection
+// is accepted; the result is collected in tryRegister.
+func (server *Server) startDNSBLLookup(config *Config, ip net.IP) *dnsblLookup {
+	if !config.Server.DNSBL.Enabled || ip.IsLoopback() {
+		return nil
+	}
+	lookup := &dnsblLookup{done: make(chan struct{})}
+	key := ip.String()
+	if result, ok := server.dnsblCache.Get(key, time.Now()); ok {
+		lookup.result = result
+		close(lookup.done)
+		return lookup
+	}
+	go func() {
+		defer close(lookup.done)
+		defer server.HandlePanic()
+
+		dnsblConfig := &config.Server.DNSBL
+		lookup.result = checkDNSBLs(dnsblConfig, ip)
+		duration := dnsblConfig.NegativeCacheDuration
+		if lookup.result.host != "" {
+			duration = dnsblConfig.CacheDuration
+		}
+		if duration != 0 {
+			server.dnsblCache.Add(key, lookup.result, duration, time.Now())
+		}
+	}()
+	return lookup
+}
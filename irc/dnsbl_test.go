@@ -0,0 +1,84 @@
+package irc
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestDNSBLQueryName(t *testing.T) {
+	if name := dnsblQueryName(net.ParseIP("192.0.2.4"), "dnsbl.example.org"); name != "4.2.0.192.dnsbl.example.org" {
+		t.Errorf("unexpected IPv4 query name: %s", name)
+	}
+	expected := "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.dnsbl.example.org"
+	if name := dnsblQueryName(net.ParseIP("2001:db8::1"), "dnsbl.example.org"); name != expected {
+		t.Errorf("unexpected IPv6 query name: %s", name)
+	}
+}
+
+func TestDNSBLListed(t *testing.T) {
+	config := DNSBLConfig{
+		Enabled: true,
+		Lists: []DNSBLListConfig{
+			{Host: "any.example.org"},
+			{Host: "some.example.org.", Action: "require-sasl", Replies: []string{"127.0.0.3"}},
+		},
+	}
+	if err := config.prepare(); err != nil {
+		t.Fatal(err)
+	}
+	anyList, someList := &config.Lists[0], &config.Lists[1]
+	if someList.Host != "some.example.org" || someList.action != dnsblRequireSASL {
+		t.Errorf("unexpected prepared config: %#v", someList)
+	}
+
+	if !anyList.listed([]string{"127.0.0.2"}) {
+		t.Errorf("any loopback answer should be a listing")
+	}
+	if anyList.listed([]string{"203.0.113.1"}) {
+		t.Errorf("non-loopback answers should not be a listing")
+	}
+	if someList.listed([]string{"127.0.0.2"}) || !someList.listed([]string{"127.0.0.2", "127.0.0.3"}) {
+		t.Errorf("replies filter not applied correctly")
+	}
+
+	config.Lists = []DNSBLListConfig{{Host: "bad.example.org", Action: "kill"}}
+	if err := config.prepare(); err == nil {
+		t.Errorf("invalid action should be rejected")
+	}
+}
+
+func TestDNSBLCache(t *testing.T) {
+	var cache DNSBLCache
+	now := time.Now()
+	listed := dnsblResult{host: "dnsbl.example.org", action: dnsblRequireSASL, reason: "listed"}
+	cache.Add("192.0.2.1", listed, time.Hour, now)
+	cache.Add("192.0.2.2", dnsblResult{}, time.Minute, now)
+
+	if result, ok := cache.Get("192.0.2.1", now); !ok || result != listed {
+		t.Errorf("expected cached positive result, got %v %v", result, ok)
+	}
+	if result, ok := cache.Get("192.0.2.2", now); !ok || result.host != "" {
+		t.Errorf("expected cached negative result, got %v %v", result, ok)
+	}
+	if _, ok := cache.Get("192.0.2.2", now.Add(2*time.Minute)); ok {
+		t.Errorf("negative result should have expired")
+	}
+	if _, ok := cache.Get("192.0.2.3", now); ok {
+		t.Errorf("unexpected result for uncached IP")
+	}
+
+	// the cache is bounded
+	for i := 0; i < maxDNSBLCacheEntries+10; i++ {
+		cache.Add(fmt.Sprintf("key%d", i), dnsblResult{}, time.Hour, now)
+	}
+	if len(cache.entries) > maxDNSBLCacheEntries {
+		t.Errorf("cache exceeded its bound: %d entries", len(cache.entries))
+	}
+
+	cache.Clear()
+	if _, ok := cache.Get("192.0.2.1", now); ok {
+		t.Errorf("cache should be empty after Clear")
+	}
+}
@@ -13,6 +13,7 @@ import (
 	"os"
 	"regexp"
 	"strings"
+	"text/template"
 	"time"
 
 	"github.com/ergochat/ergo/irc/custime"
@@ -79,6 +80,8 @@ type MailtoConfig struct {
 	LocalAddress           string `yaml:"local-address"`
 	localAddress           net.Addr
 	VerifyMessageSubject   string `yaml:"verify-message-subject"`
+	VerifyMessage          string `yaml:"verify-message"`
+	verifyMessageTemplate  *template.Template
 	DKIM                   DKIMConfig
 	MTAReal                MTAConfig       `yaml:"mta"`
 	AddressBlacklist       []string        `yaml:"address-blacklist"`
@@ -90,6 +93,10 @@ type MailtoConfig struct {
 		Enabled  bool
 		Cooldown custime.Duration
 		Timeout  custime.Duration
+		// after a successful reset, disconnect the account's existing sessions:
+		LogoutSessions bool `yaml:"logout-sessions"`
+		// after a successful reset, remove the account's certificate fingerprints:
+		ClearCertfps bool `yaml:"clear-certfps"`
 	} `yaml:"password-reset"`
 }
 
@@ -162,6 +169,17 @@ func (config *MailtoConfig) Postprocess(heloDomain string) (err error) {
 		}
 	}
 
+	if config.VerifyMessage != "" {
+		config.verifyMessageTemplate, err = template.New("verify-message").Option("missingkey=error").Parse(config.VerifyMessage)
+		if err == nil {
+			// catch references to nonexistent fields now, rather than at send time
+			err = config.verifyMessageTemplate.Execute(io.Discard, VerifyMessageData{})
+		}
+		if err != nil {
+			return fmt.Errorf("Invalid verify-message template: %w", err)
+		}
+	}
+
 	config.Protocol = strings.ToLower(config.Protocol)
 	if config.Protocol == "" {
 		config.Protocol = "tcp"
@@ -225,6 +243,34 @@ func ComposeMail(config MailtoConfig, recipient, subject string) (message bytes.
 	return message
 }
 
+// VerifyMessageData is the data available to the verify-message template.
+type VerifyMessageData struct {
+	Account string
+	Code    string
+	Server  string
+	// the command the user must issue to complete verification
+	Command string
+}
+
+// WriteVerifyMessage writes the body of a verification email to `message`,
+// using the configured template; it returns false if no template is configured.
+func (config *MailtoConfig) WriteVerifyMessage(message *bytes.Buffer, data VerifyMessageData) (ok bool, err error) {
+	if config.verifyMessageTemplate == nil {
+		return false, nil
+	}
+	var body strings.Builder
+	err = config.verifyMessageTemplate.Execute(&body, data)
+	if err != nil {
+		return false, err
+	}
+	// the template comes from YAML with bare newlines, but SMTP requires CRLF
+	for _, line := range strings.Split(strings.TrimRight(body.String(), "\r\n"), "\n") {
+		message.WriteString(strings.TrimSuffix(line, "\r"))
+		message.WriteString("\r\n")
+	}
+	return true, nil
+}
+
 func SendMail(config MailtoConfig, recipient string, msg []byte) (err error) {
 	recipientLower := strings.ToLower(recipient)
 	for _, reg := range config.blacklistRegexes {
@@ -0,0 +1,35 @@
+package email
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestVerifyMessageTemplate(t *testing.T) {
+	config := MailtoConfig{
+		Sender:        "admin@my.network",
+		VerifyMessage: "Hello {{.Account}},\nrun: {{.Command}}\n",
+	}
+	if err := config.Postprocess("my.network"); err != nil {
+		t.Fatal(err)
+	}
+	var message bytes.Buffer
+	ok, err := config.WriteVerifyMessage(&message, VerifyMessageData{Account: "alice", Command: "/MSG NickServ VERIFY alice 1234"})
+	if !ok || err != nil {
+		t.Fatalf("expected template to be used, got %v %v", ok, err)
+	}
+	if expected := "Hello alice,\r\nrun: /MSG NickServ VERIFY alice 1234\r\n"; message.String() != expected {
+		t.Errorf("expected %q, got %q", expected, message.String())
+	}
+
+	config = MailtoConfig{Sender: "admin@my.network", VerifyMessage: "{{.Password}}"}
+	if err := config.Postprocess("my.network"); err == nil {
+		t.Errorf("template referencing an unknown field should be rejected")
+	}
+
+	config = MailtoConfig{Sender: "admin@my.network"}
+	config.Postprocess("my.network")
+	if ok, _ := config.WriteVerifyMessage(&message, VerifyMessageData{}); ok {
+		t.Errorf("no template configured, should use the default message")
+	}
+}
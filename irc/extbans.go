@@ -0,0 +1,84 @@
+package irc
+
+import (
+	"sort"
+	"strings"
+)
+
+// extbanType is a kind of extended ban mask (`<letter>:<pattern>`) that matches
+// on some property of the client other than its nick!user@host. The mute
+// extban (m:) is handled separately, since it restricts speech rather than joins.
+type extbanType struct {
+	// canonicalize returns the form of the pattern that is stored and compiled
+	canonicalize func(pattern string) (string, error)
+	// property returns the (casefolded) value the compiled pattern is matched against;
+	// an empty value never matches
+	property func(details *ClientDetails) string
+}
+
+var extbanTypes = map[byte]extbanType{
+	// a:<account>, matches logged-in clients by account name
+	'a': {
+		canonicalize: canonicalizeAccountPattern,
+		property: func(details *ClientDetails) string {
+			return details.account
+		},
+	},
+	// r:<realname>, matches clients by realname, case-insensitively
+	'r': {
+		canonicalize: func(pattern string) (string, error) {
+			return strings.ToLower(pattern), nil
+		},
+		property: func(details *ClientDetails) string {
+			return strings.ToLower(details.realname)
+		},
+	},
+}
+
+func canonicalizeAccountPattern(pattern string) (string, error) {
+	// XXX as with nicknames, wildcards are only accepted with ASCII account names
+	if strings.ContainsAny(pattern, "*?") {
+		return foldASCII(pattern)
+	}
+	return CasefoldName(pattern)
+}
+
+// splitExtban returns the extban type and pattern of a mask of the form
+// `<letter>:<pattern>`, if the letter is a registered extban type.
+func splitExtban(mask string) (letter byte, pattern string, ok bool) {
+	if len(mask) < 3 || mask[1] != ':' {
+		return
+	}
+	if _, ok = extbanTypes[mask[0]]; !ok {
+		return
+	}
+	return mask[0], mask[2:], true
+}
+
+// canonicalizeListMask canonicalizes an entry for a channel ban, exception,
+// or invite-exception list, which may be an extban.
+func canonicalizeListMask(mask string) (string, error) {
+	mask = strings.TrimSpace(mask)
+	if letter, pattern, ok := splitExtban(mask); ok {
+		canonical, err := extbanTypes[letter].canonicalize(pattern)
+		if err != nil {
+			return "", err
+		}
+		if canonical == "" {
+			return "", errInvalidParams
+		}
+		return mask[:2] + canonical, nil
+	}
+	return CanonicalizeMaskWildcard(mask)
+}
+
+// extbanISupportValue returns the value of the EXTBAN ISUPPORT token:
+// we don't use a prefix, and m: (mute) is supported in addition to extbanTypes.
+func extbanISupportValue() string {
+	letters := []byte{'m'}
+	for letter := range extbanTypes {
+		letters = append(letters, letter)
+	}
+	sort.Slice(letters, func(i, j int) bool { return letters[i] < letters[j] })
+	return "," + string(letters)
+}
@@ -7,10 +7,12 @@ package irc
 
 import (
 	"errors"
+	"fmt"
 	"net"
 
 	"github.com/ergochat/ergo/irc/flatip"
 	"github.com/ergochat/ergo/irc/modes"
+	"github.com/ergochat/ergo/irc/sno"
 	"github.com/ergochat/ergo/irc/utils"
 )
 
@@ -79,8 +81,10 @@ func (client *Client) ApplyProxiedIP(session *Session, proxiedIP net.IP, tls boo
 	}
 	proxiedIP = proxiedIP.To16()
 
-	isBanned, requireSASL, banMsg := client.server.checkBans(client.server.Config(), proxiedIP, true)
+	config := client.server.Config()
+	isBanned, requireSASL, banMsg := client.server.checkBans(config, proxiedIP, true)
 	if isBanned {
+		client.server.snomasks.Send(sno.LocalRejects, fmt.Sprintf("Connection rejected [ip:%s] [proxied:%s]: %s", session.realIP.String(), proxiedIP.String(), banMsg))
 		return errBanned, banMsg
 	}
 	client.requireSASL = requireSASL
@@ -103,6 +107,7 @@ func (client *Client) ApplyProxiedIP(session *Session, proxiedIP net.IP, tls boo
 	session.certfp = ""
 	session.peerCerts = nil
 	client.SetMode(modes.TLS, tls)
+	session.dnsbl = client.server.startDNSBLLookup(config, proxiedIP)
 
 	return nil, ""
 }
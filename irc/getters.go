@@ -56,6 +56,8 @@ type SessionData struct {
 	connInfo  string
 	sessionID int64
 	caps      []string
+	sendQLen  int
+	traffic   SocketStats
 }
 
 func (client *Client) AllSessionData(currentSession *Session, hasPrivs bool) (data []SessionData, currentIndex int) {
@@ -75,6 +77,8 @@ func (client *Client) AllSessionData(currentSession *Session, hasPrivs bool) (da
 			certfp:    session.certfp,
 			deviceID:  session.deviceID,
 			sessionID: session.sessionID,
+			sendQLen:  session.socket.SendQLen(),
+			traffic:   session.socket.Stats(),
 		}
 		if session.proxiedIP != nil {
 			data[i].ip = session.proxiedIP
@@ -18,6 +18,7 @@ import (
 	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/ergochat/irc-go/ircfmt"
 	"github.com/ergochat/irc-go/ircmsg"
@@ -110,16 +111,9 @@ func sendSuccessfulAccountAuth(service *ircService, client *Client, rb *Response
 	}
 
 	if client.Registered() {
-		// dispatch account-notify
-		for friend := range client.FriendsMonitors(caps.AccountNotify) {
-			if friend != rb.session {
-				friend.Send(nil, details.nickMask, "ACCOUNT", details.accountName)
-			}
-		}
-		if rb.session.capabilities.Has(caps.AccountNotify) {
-			rb.Add(nil, details.nickMask, "ACCOUNT", details.accountName)
-		}
+		client.sendAccountNotify(rb, details.accountName)
 		client.server.sendLoginSnomask(details.nickMask, details.accountName)
+		notifyUnreadMemos(client, rb)
 	}
 
 	// #1479: for Tor clients, replace the hostname with the always-on cloak here
@@ -156,7 +150,7 @@ func acceptHandler(server *Server, client *Client, msg ircmsg.Message, rb *Respo
 
 		target := server.clients.Get(tNick)
 		if target == nil {
-			rb.Add(nil, server.name, "FAIL", "ACCEPT", "INVALID_USER", utils.SafeErrorParam(tNick), client.t("No such user"))
+			rb.Fail("ACCEPT", "INVALID_USER", client.t("No such user"), utils.SafeErrorParam(tNick))
 			continue
 		}
 
@@ -182,6 +176,47 @@ const (
 	saslMaxResponseLength = 8192 // implementation-defined sanity check, long enough for bearer tokens
 )
 
+// ADMIN [<server>]
+func adminHandler(server *Server, client *Client, msg ircmsg.Message, rb *ResponseBuffer) bool {
+	nick := client.Nick()
+	admin := server.Config().Server.Admin
+	if admin.Location == "" && admin.Description == "" && admin.Email == "" {
+		rb.Add(nil, server.name, ERR_NOADMININFO, nick, server.name, client.t("No administrative info available"))
+		return false
+	}
+	rb.Add(nil, server.name, RPL_ADMINME, nick, server.name, client.t("Administrative info"))
+	// omit the fields that aren't configured, rather than sending empty params
+	for _, field := range []struct{ numeric, value string }{
+		{RPL_ADMINLOC1, admin.Location},
+		{RPL_ADMINLOC2, admin.Description},
+		{RPL_ADMINEMAIL, admin.Email},
+	} {
+		if field.value != "" {
+			rb.Add(nil, server.name, field.numeric, nick, field.value)
+		}
+	}
+	return false
+}
+
+// AUDIT [limit]
+func auditHandler(server *Server, client *Client, msg ircmsg.Message, rb *ResponseBuffer) bool {
+	limit := 25
+	if len(msg.Params) > 0 {
+		var err error
+		limit, err = strconv.Atoi(msg.Params[0])
+		if err != nil || limit < 0 {
+			rb.Add(nil, server.name, ERR_UNKNOWNERROR, client.Nick(), msg.Command, client.t("Invalid limit"))
+			return false
+		}
+	}
+	entries := server.auditLog.Recent(limit)
+	rb.Notice(fmt.Sprintf(client.t("Showing %d recent audit log entries"), len(entries)))
+	for i := range entries {
+		rb.Notice(entries[i].String())
+	}
+	return false
+}
+
 // AUTHENTICATE [<mechanism>|<data>|*]
 func authenticateHandler(server *Server, client *Client, msg ircmsg.Message, rb *ResponseBuffer) bool {
 	session := rb.session
@@ -331,7 +366,7 @@ func sendAuthErrorResponse(client *Client, rb *ResponseBuffer, err error) {
 	msg := authErrorToMessage(client.server, err)
 	rb.Add(nil, client.server.name, ERR_SASLFAIL, client.nick, fmt.Sprintf("%s: %s", client.t("SASL authentication failed"), client.t(msg)))
 	if err == errAccountUnverified {
-		rb.Add(nil, client.server.name, "NOTE", "AUTHENTICATE", "VERIFICATION_REQUIRED", "*", client.t(err.Error()))
+		rb.Note("AUTHENTICATE", "VERIFICATION_REQUIRED", client.t(err.Error()), "*")
 	}
 }
 
@@ -551,7 +586,7 @@ func batchHandler(server *Server, client *Client, msg ircmsg.Message, rb *Respon
 		if len(msg.Params) < 3 || msg.Params[1] != caps.MultilineBatchType {
 			fail = true
 		} else {
-			err := rb.session.StartMultilineBatch(tag[1:], msg.Params[2], rb.Label, msg.ClientOnlyTags())
+			err := rb.session.StartMultilineBatch(tag[1:], msg.Params[2], rb.Label, server.Config().Server.clientTagDeny.Filter(msg.ClientOnlyTags()))
 			fail = (err != nil)
 			if !fail {
 				// suppress ACK for the initial BATCH message (we'll apply the stored label later)
@@ -577,7 +612,7 @@ func batchHandler(server *Server, client *Client, msg ircmsg.Message, rb *Respon
 	if fail {
 		rb.session.EndMultilineBatch("")
 		if sendErrors {
-			rb.Add(nil, server.name, "FAIL", "BATCH", "MULTILINE_INVALID", client.t("Invalid multiline batch"))
+			rb.Fail("BATCH", "MULTILINE_INVALID", client.t("Invalid multiline batch"))
 		}
 	}
 
@@ -705,11 +740,11 @@ func chathistoryHandler(server *Server, client *Client, msg ircmsg.Message, rb *
 	defer func() {
 		// errors are sent either without a batch, or in a draft/labeled-response batch as usual
 		if err == utils.ErrInvalidParams {
-			rb.Add(nil, server.name, "FAIL", "CHATHISTORY", "INVALID_PARAMS", msg.Params[0], client.t("Invalid parameters"))
+			rb.Fail("CHATHISTORY", "INVALID_PARAMS", client.t("Invalid parameters"), msg.Params[0])
 		} else if !listTargets && sequence == nil {
-			rb.Add(nil, server.name, "FAIL", "CHATHISTORY", "INVALID_TARGET", msg.Params[0], utils.SafeErrorParam(target), client.t("Messages could not be retrieved"))
+			rb.Fail("CHATHISTORY", "INVALID_TARGET", client.t("Messages could not be retrieved"), msg.Params[0], utils.SafeErrorParam(target))
 		} else if err != nil {
-			rb.Add(nil, server.name, "FAIL", "CHATHISTORY", "MESSAGE_ERROR", msg.Params[0], client.t("Messages could not be retrieved"))
+			rb.Fail("CHATHISTORY", "MESSAGE_ERROR", client.t("Messages could not be retrieved"), msg.Params[0])
 		} else {
 			// successful responses are sent as a chathistory or history batch
 			if listTargets {
@@ -927,6 +962,7 @@ func defconHandler(server *Server, client *Client, msg ircmsg.Message, rb *Respo
 		if err == nil && 1 <= level && level <= 5 {
 			server.SetDefcon(uint32(level))
 			server.snomasks.Send(sno.LocalAnnouncements, fmt.Sprintf("%s [%s] set DEFCON level to %d", client.Nick(), client.Oper().Name, level))
+			server.audit(client, "DEFCON", strconv.Itoa(level), "")
 		} else {
 			rb.Add(nil, server.name, ERR_UNKNOWNERROR, client.Nick(), msg.Command, client.t("Invalid DEFCON parameter"))
 			return false
@@ -936,6 +972,21 @@ func defconHandler(server *Server, client *Client, msg ircmsg.Message, rb *Respo
 	return false
 }
 
+// DIE [reason]
+func dieHandler(server *Server, client *Client, msg ircmsg.Message, rb *ResponseBuffer) bool {
+	var reason string
+	if len(msg.Params) > 0 {
+		reason = msg.Params[0]
+	}
+	nick := client.Nick()
+	server.logger.Info("server", "DIE command used by", nick, reason)
+	server.audit(client, "DIE", "", reason)
+	server.snomasks.Send(sno.LocalOpers, fmt.Sprintf(ircfmt.Unescape("Operator $c[grey][$r%s$c[grey]] is shutting down the server"), nick))
+	rb.Notice(client.t("Shutting down the server"))
+	server.Die(reason)
+	return false
+}
+
 // helper for parsing the reason args to DLINE and KLINE
 func getReasonsFromParams(params []string, currentArg int) (reason, operReason string) {
 	reason = "No reason given"
@@ -1067,6 +1118,7 @@ func dlineHandler(server *Server, client *Client, msg ircmsg.Message, rb *Respon
 		snoDescription = fmt.Sprintf(ircfmt.Unescape("%s [%s]$r added D-Line for %s"), client.nick, operName, hostString)
 	}
 	server.snomasks.Send(sno.LocalXline, snoDescription)
+	server.audit(client, "DLINE", hostString, reason)
 
 	var killClient bool
 	if andKill {
@@ -1122,7 +1174,7 @@ func extjwtHandler(server *Server, client *Client, msg ircmsg.Message, rb *Respo
 	if msg.Params[0] != "*" {
 		channel := server.channels.Get(msg.Params[0])
 		if channel == nil {
-			rb.Add(nil, server.name, "FAIL", "EXTJWT", "NO_SUCH_CHANNEL", client.t("No such channel"))
+			rb.Fail("EXTJWT", "NO_SUCH_CHANNEL", client.t("No such channel"))
 			return false
 		}
 
@@ -1151,7 +1203,7 @@ func extjwtHandler(server *Server, client *Client, msg ircmsg.Message, rb *Respo
 	}
 
 	if !sConfig.Enabled() {
-		rb.Add(nil, server.name, "FAIL", "EXTJWT", "NO_SUCH_SERVICE", client.t("No such service"))
+		rb.Fail("EXTJWT", "NO_SUCH_SERVICE", client.t("No such service"))
 		return false
 	}
 
@@ -1166,7 +1218,7 @@ func extjwtHandler(server *Server, client *Client, msg ircmsg.Message, rb *Respo
 		}
 		rb.Add(nil, server.name, "EXTJWT", msg.Params[0], serviceName, tokenString)
 	} else {
-		rb.Add(nil, server.name, "FAIL", "EXTJWT", "UNKNOWN_ERROR", client.t("Could not generate EXTJWT token"))
+		rb.Fail("EXTJWT", "UNKNOWN_ERROR", client.t("Could not generate EXTJWT token"))
 	}
 
 	return false
@@ -1430,6 +1482,7 @@ func sajoinHandler(server *Server, client *Client, msg ircmsg.Message, rb *Respo
 	message := fmt.Sprintf("Operator %s ran SAJOIN %s", client.Oper().Name, strings.Join(msg.Params, " "))
 	server.snomasks.Send(sno.LocalOpers, message)
 	server.logger.Info("opers", message)
+	server.audit(client, "SAJOIN", strings.Join(msg.Params, " "), "")
 
 	channels := strings.Split(channelString, ",")
 	for _, chname := range channels {
@@ -1536,12 +1589,29 @@ func killHandler(server *Server, client *Client, msg ircmsg.Message, rb *Respons
 		snoLine = fmt.Sprintf(ircfmt.Unescape("%s was killed by %s $c[grey][$r%s$c[grey]]"), target.Nick(), client.Nick(), comment)
 	}
 	server.snomasks.Send(sno.LocalKills, snoLine)
+	server.audit(client, "KILL", target.Nick(), comment)
 
 	target.Quit(quitMsg, nil)
 	target.destroy(nil)
 	return false
 }
 
+// KNOCK <channel> [<message>]
+func knockHandler(server *Server, client *Client, msg ircmsg.Message, rb *ResponseBuffer) bool {
+	channel := server.channels.Get(msg.Params[0])
+	if channel == nil {
+		rb.Add(nil, server.name, ERR_NOSUCHCHANNEL, client.Nick(), utils.SafeErrorParam(msg.Params[0]), client.t("No such channel"))
+		return false
+	}
+
+	var message string
+	if len(msg.Params) > 1 {
+		message = msg.Params[1]
+	}
+	channel.Knock(client, message, rb)
+	return false
+}
+
 // KLINE [ANDKILL] [MYSELF] [duration] <mask> [ON <server>] [reason [| oper reason]]
 // KLINE LIST
 func klineHandler(server *Server, client *Client, msg ircmsg.Message, rb *ResponseBuffer) bool {
@@ -1651,6 +1721,7 @@ func klineHandler(server *Server, client *Client, msg ircmsg.Message, rb *Respon
 		snoDescription = fmt.Sprintf(ircfmt.Unescape("%s [%s]$r added K-Line for %s"), details.nick, operName, mask)
 	}
 	server.snomasks.Send(sno.LocalXline, snoDescription)
+	server.audit(client, "KLINE", mask, reason)
 
 	var killClient bool
 	if andKill {
@@ -1868,6 +1939,7 @@ func cmodeHandler(server *Server, client *Client, msg ircmsg.Message, rb *Respon
 		message := fmt.Sprintf("Operator %s ran SAMODE %s", client.Oper().Name, strings.Join(msg.Params, " "))
 		server.snomasks.Send(sno.LocalOpers, message)
 		server.logger.Info("opers", message)
+		server.audit(client, "SAMODE", strings.Join(msg.Params, " "), "")
 	}
 
 	// process mode changes, include list operations (an empty set of changes does a list)
@@ -1931,6 +2003,7 @@ func umodeHandler(server *Server, client *Client, msg ircmsg.Message, rb *Respon
 		message := fmt.Sprintf("Operator %s ran SAMODE %s", client.Oper().Name, strings.Join(msg.Params, " "))
 		server.snomasks.Send(sno.LocalOpers, message)
 		server.logger.Info("opers", message)
+		server.audit(client, "SAMODE", strings.Join(msg.Params, " "), "")
 	}
 
 	// applied mode changes
@@ -2217,10 +2290,9 @@ func absorbBatchedMessage(server *Server, client *Client, msg ircmsg.Message, ba
 	defer func() {
 		if failParams != nil {
 			if histType != history.Notice {
-				params := make([]string, 1+len(failParams))
-				params[0] = "BATCH"
-				copy(params[1:], failParams)
-				rb.Add(nil, server.name, "FAIL", params...)
+				// failParams is the code, followed by any context, followed by the description
+				last := len(failParams) - 1
+				rb.Fail("BATCH", failParams[0], failParams[last], failParams[1:last]...)
 			}
 			rb.session.EndMultilineBatch("")
 		}
@@ -2274,7 +2346,7 @@ func messageHandler(server *Server, client *Client, msg ircmsg.Message, rb *Resp
 		return false
 	}
 
-	clientOnlyTags := msg.ClientOnlyTags()
+	clientOnlyTags := server.Config().Server.clientTagDeny.Filter(msg.ClientOnlyTags())
 	if histType == history.Tagmsg && len(clientOnlyTags) == 0 {
 		// nothing to do
 		return false
@@ -2421,7 +2493,8 @@ func dispatchMessageToTarget(client *Client, tags map[string]string, histType hi
 		nickMaskString := details.nickMask
 		accountName := details.accountName
 		var deliverySessions []*Session
-		deliverySessions = append(deliverySessions, user.Sessions()...)
+		userSessions := user.Sessions()
+		deliverySessions = append(deliverySessions, userSessions...)
 		// all sessions of the sender, except the originating session, get a copy as well:
 		if client != user {
 			for _, session := range client.Sessions() {
@@ -2446,6 +2519,11 @@ func dispatchMessageToTarget(client *Client, tags map[string]string, histType hi
 			}
 		}
 
+		// a detached always-on client may get a push notification instead:
+		if len(userSessions) == 0 && histType == history.Privmsg && client != user {
+			server.webPush.Notify(user, makePushMessage(nickMaskString, accountName, command, tnick, message))
+		}
+
 		// the originating session may get an echo message:
 		rb.addEchoMessage(tags, nickMaskString, accountName, command, tnick, message)
 		if histType == history.Privmsg {
@@ -2691,7 +2769,7 @@ func passHandler(server *Server, client *Client, msg ircmsg.Message, rb *Respons
 func persistenceHandler(server *Server, client *Client, msg ircmsg.Message, rb *ResponseBuffer) bool {
 	account := client.Account()
 	if account == "" {
-		rb.Add(nil, server.name, "FAIL", "PERSISTENCE", "ACCOUNT_REQUIRED", client.t("You're not logged into an account"))
+		rb.Fail("PERSISTENCE", "ACCOUNT_REQUIRED", client.t("You're not logged into an account"))
 		return false
 	}
 
@@ -2724,7 +2802,7 @@ func persistenceHandler(server *Server, client *Client, msg ircmsg.Message, rb *
 			})
 		if err != nil {
 			server.logger.Error("internal", "couldn't modify persistence setting", err.Error())
-			rb.Add(nil, server.name, "FAIL", "PERSISTENCE", "UNKNOWN_ERROR", client.t("An error occurred"))
+			rb.Fail("PERSISTENCE", "UNKNOWN_ERROR", client.t("An error occurred"))
 			return false
 		}
 
@@ -2737,7 +2815,7 @@ func persistenceHandler(server *Server, client *Client, msg ircmsg.Message, rb *
 	return false
 
 fail:
-	rb.Add(nil, server.name, "FAIL", "PERSISTENCE", "INVALID_PARAMS", client.t("Invalid parameters"))
+	rb.Fail("PERSISTENCE", "INVALID_PARAMS", client.t("Invalid parameters"))
 	return false
 }
 
@@ -2777,28 +2855,28 @@ func redactHandler(server *Server, client *Client, msg ircmsg.Message, rb *Respo
 	}
 
 	if canDelete == canDeleteNone {
-		rb.Add(nil, server.name, "FAIL", "REDACT", "REDACT_FORBIDDEN", utils.SafeErrorParam(target), utils.SafeErrorParam(targetmsgid), client.t("You are not authorized to delete messages"))
+		rb.Fail("REDACT", "REDACT_FORBIDDEN", client.t("You are not authorized to delete messages"), utils.SafeErrorParam(target), utils.SafeErrorParam(targetmsgid))
 		return false
 	}
 	accountName := "*"
 	if canDelete == canDeleteSelf {
 		accountName = client.AccountName()
 		if accountName == "*" {
-			rb.Add(nil, server.name, "FAIL", "REDACT", "REDACT_FORBIDDEN", utils.SafeErrorParam(target), utils.SafeErrorParam(targetmsgid), client.t("You are not authorized to delete this message"))
+			rb.Fail("REDACT", "REDACT_FORBIDDEN", client.t("You are not authorized to delete this message"), utils.SafeErrorParam(target), utils.SafeErrorParam(targetmsgid))
 			return false
 		}
 	}
 
 	err := server.DeleteMessage(target, targetmsgid, accountName)
 	if err == errNoop {
-		rb.Add(nil, server.name, "FAIL", "REDACT", "UNKNOWN_MSGID", utils.SafeErrorParam(target), utils.SafeErrorParam(targetmsgid), client.t("This message does not exist or is too old"))
+		rb.Fail("REDACT", "UNKNOWN_MSGID", client.t("This message does not exist or is too old"), utils.SafeErrorParam(target), utils.SafeErrorParam(targetmsgid))
 		return false
 	} else if err != nil {
 		isOper := client.HasRoleCapabs("history")
 		if isOper {
-			rb.Add(nil, server.name, "FAIL", "REDACT", "REDACT_FORBIDDEN", utils.SafeErrorParam(target), utils.SafeErrorParam(targetmsgid), fmt.Sprintf(client.t("Error deleting message: %v"), err))
+			rb.Fail("REDACT", "REDACT_FORBIDDEN", fmt.Sprintf(client.t("Error deleting message: %v"), err), utils.SafeErrorParam(target), utils.SafeErrorParam(targetmsgid))
 		} else {
-			rb.Add(nil, server.name, "FAIL", "REDACT", "REDACT_FORBIDDEN", utils.SafeErrorParam(target), utils.SafeErrorParam(targetmsgid), client.t("Could not delete message"))
+			rb.Fail("REDACT", "REDACT_FORBIDDEN", client.t("Could not delete message"), utils.SafeErrorParam(target), utils.SafeErrorParam(targetmsgid))
 		}
 		return false
 	}
@@ -2812,9 +2890,9 @@ func redactHandler(server *Server, client *Client, msg ircmsg.Message, rb *Respo
 			client.server.logger.Error("internal", fmt.Sprintf("Private message %s is not deletable by %s from their own buffer's even though we just deleted it from %s's. This is a bug, please report it in details.", targetmsgid, client.Nick(), target), client.Nick())
 			isOper := client.HasRoleCapabs("history")
 			if isOper {
-				rb.Add(nil, server.name, "FAIL", "REDACT", "REDACT_FORBIDDEN", utils.SafeErrorParam(target), utils.SafeErrorParam(targetmsgid), fmt.Sprintf(client.t("Error deleting message: %v"), err))
+				rb.Fail("REDACT", "REDACT_FORBIDDEN", fmt.Sprintf(client.t("Error deleting message: %v"), err), utils.SafeErrorParam(target), utils.SafeErrorParam(targetmsgid))
 			} else {
-				rb.Add(nil, server.name, "FAIL", "REDACT", "REDACT_FORBIDDEN", utils.SafeErrorParam(target), utils.SafeErrorParam(targetmsgid), client.t("Error deleting message"))
+				rb.Fail("REDACT", "REDACT_FORBIDDEN", client.t("Error deleting message"), utils.SafeErrorParam(target), utils.SafeErrorParam(targetmsgid))
 			}
 		}
 	}
@@ -2896,7 +2974,7 @@ func registerHandler(server *Server, client *Client, msg ircmsg.Message, rb *Res
 	case "*", accountName:
 		// ok
 	default:
-		rb.Add(nil, server.name, "FAIL", "REGISTER", "ACCOUNT_NAME_MUST_BE_NICK", utils.SafeErrorParam(msg.Params[0]), client.t("You may only register your nickname as your account name"))
+		rb.Fail("REGISTER", "ACCOUNT_NAME_MUST_BE_NICK", client.t("You may only register your nickname as your account name"), utils.SafeErrorParam(msg.Params[0]))
 		return
 	}
 
@@ -2904,27 +2982,27 @@ func registerHandler(server *Server, client *Client, msg ircmsg.Message, rb *Res
 	// this is necessary for us to be valid and it will prevent us from emitting invalid error lines
 	nickErrorParam := utils.SafeErrorParam(accountName)
 	if accountName == "*" || accountName != nickErrorParam {
-		rb.Add(nil, server.name, "FAIL", "REGISTER", "INVALID_USERNAME", nickErrorParam, client.t("Username invalid or not given"))
+		rb.Fail("REGISTER", "INVALID_USERNAME", client.t("Username invalid or not given"), nickErrorParam)
 		return
 	}
 
 	config := server.Config()
 	if !config.Accounts.Registration.Enabled {
-		rb.Add(nil, server.name, "FAIL", "REGISTER", "DISALLOWED", accountName, client.t("Account registration is disabled"))
+		rb.Fail("REGISTER", "DISALLOWED", client.t("Account registration is disabled"), accountName)
 		return
 	}
 	if !client.registered && !config.Accounts.Registration.AllowBeforeConnect {
-		rb.Add(nil, server.name, "FAIL", "REGISTER", "COMPLETE_CONNECTION_REQUIRED", accountName, client.t("You must complete the connection before registering your account"))
+		rb.Fail("REGISTER", "COMPLETE_CONNECTION_REQUIRED", client.t("You must complete the connection before registering your account"), accountName)
 		return
 	}
 	if client.registerCmdSent || client.Account() != "" {
-		rb.Add(nil, server.name, "FAIL", "REGISTER", "ALREADY_REGISTERED", accountName, client.t("You have already registered or attempted to register"))
+		rb.Fail("REGISTER", "ALREADY_REGISTERED", client.t("You have already registered or attempted to register"), accountName)
 		return
 	}
 
 	callbackNamespace, callbackValue, err := parseCallback(msg.Params[1], config)
 	if err != nil {
-		rb.Add(nil, server.name, "FAIL", "REGISTER", "INVALID_EMAIL", accountName, client.t("A valid e-mail address is required"))
+		rb.Fail("REGISTER", "INVALID_EMAIL", client.t("A valid e-mail address is required"), accountName)
 		return
 	}
 
@@ -2946,7 +3024,7 @@ func registerHandler(server *Server, client *Client, msg ircmsg.Message, rb *Res
 			}
 			if err != nil {
 				server.logger.Error("internal", "accounts", "failed autoverification", accountName, err.Error())
-				rb.Add(nil, server.name, "FAIL", "REGISTER", "UNKNOWN_ERROR", client.t("An error occurred"))
+				rb.Fail("REGISTER", "UNKNOWN_ERROR", client.t("An error occurred"))
 			}
 		} else {
 			rb.Add(nil, server.name, "REGISTER", "VERIFICATION_REQUIRED", accountName, fmt.Sprintf(client.t("Account created, pending verification; verification code has been sent to %s"), callbackValue))
@@ -2954,14 +3032,14 @@ func registerHandler(server *Server, client *Client, msg ircmsg.Message, rb *Res
 			announcePendingReg(client, rb, accountName)
 		}
 	case errAccountAlreadyRegistered, errAccountAlreadyUnregistered, errAccountMustHoldNick:
-		rb.Add(nil, server.name, "FAIL", "REGISTER", "USERNAME_EXISTS", accountName, client.t("Username is already registered or otherwise unavailable"))
+		rb.Fail("REGISTER", "USERNAME_EXISTS", client.t("Username is already registered or otherwise unavailable"), accountName)
 	case errAccountBadPassphrase:
-		rb.Add(nil, server.name, "FAIL", "REGISTER", "INVALID_PASSWORD", accountName, client.t("Password was invalid"))
+		rb.Fail("REGISTER", "INVALID_PASSWORD", client.t("Password was invalid"), accountName)
 	default:
 		if emailError := registrationCallbackErrorText(config, client, err); emailError != "" {
-			rb.Add(nil, server.name, "FAIL", "REGISTER", "UNACCEPTABLE_EMAIL", accountName, emailError)
+			rb.Fail("REGISTER", "UNACCEPTABLE_EMAIL", emailError, accountName)
 		} else {
-			rb.Add(nil, server.name, "FAIL", "REGISTER", "UNKNOWN_ERROR", accountName, client.t("Could not register"))
+			rb.Fail("REGISTER", "UNKNOWN_ERROR", client.t("Could not register"), accountName)
 		}
 	}
 	return
@@ -2971,15 +3049,15 @@ func registerHandler(server *Server, client *Client, msg ircmsg.Message, rb *Res
 func verifyHandler(server *Server, client *Client, msg ircmsg.Message, rb *ResponseBuffer) (exiting bool) {
 	config := server.Config()
 	if !config.Accounts.Registration.Enabled {
-		rb.Add(nil, server.name, "FAIL", "VERIFY", "DISALLOWED", client.t("Account registration is disabled"))
+		rb.Fail("VERIFY", "DISALLOWED", client.t("Account registration is disabled"))
 		return
 	}
 	if !client.registered && !config.Accounts.Registration.AllowBeforeConnect {
-		rb.Add(nil, server.name, "FAIL", "VERIFY", "DISALLOWED", client.t("You must complete the connection before verifying your account"))
+		rb.Fail("VERIFY", "DISALLOWED", client.t("You must complete the connection before verifying your account"))
 		return
 	}
 	if client.Account() != "" {
-		rb.Add(nil, server.name, "FAIL", "VERIFY", "ALREADY_REGISTERED", client.t("You have already registered or attempted to register"))
+		rb.Fail("VERIFY", "ALREADY_REGISTERED", client.t("You have already registered or attempted to register"))
 		return
 	}
 
@@ -2995,9 +3073,9 @@ func verifyHandler(server *Server, client *Client, msg ircmsg.Message, rb *Respo
 		rb.Add(nil, server.name, "VERIFY", "SUCCESS", accountName, client.t("Account successfully registered"))
 		sendSuccessfulRegResponse(nil, client, rb)
 	case errAccountVerificationInvalidCode:
-		rb.Add(nil, server.name, "FAIL", "VERIFY", "INVALID_CODE", client.t("Invalid verification code"))
+		rb.Fail("VERIFY", "INVALID_CODE", client.t("Invalid verification code"))
 	default:
-		rb.Add(nil, server.name, "FAIL", "VERIFY", "UNKNOWN_ERROR", client.t("Failed to verify account"))
+		rb.Fail("VERIFY", "UNKNOWN_ERROR", client.t("Failed to verify account"))
 	}
 
 	if err != nil && !client.registered {
@@ -3012,14 +3090,14 @@ func verifyHandler(server *Server, client *Client, msg ircmsg.Message, rb *Respo
 // MARKREAD <target> [timestamp]
 func markReadHandler(server *Server, client *Client, msg ircmsg.Message, rb *ResponseBuffer) (exiting bool) {
 	if len(msg.Params) == 0 {
-		rb.Add(nil, server.name, "FAIL", "MARKREAD", "NEED_MORE_PARAMS", client.t("Missing parameters"))
+		rb.Fail("MARKREAD", "NEED_MORE_PARAMS", client.t("Missing parameters"))
 		return
 	}
 
 	target := msg.Params[0]
 	cftarget, err := CasefoldTarget(target)
 	if err != nil {
-		rb.Add(nil, server.name, "FAIL", "MARKREAD", "INVALID_PARAMS", utils.SafeErrorParam(target), client.t("Invalid target"))
+		rb.Fail("MARKREAD", "INVALID_PARAMS", client.t("Invalid target"), utils.SafeErrorParam(target))
 		return
 	}
 	unfoldedTarget := server.UnfoldName(cftarget)
@@ -3034,7 +3112,7 @@ func markReadHandler(server *Server, client *Client, msg ircmsg.Message, rb *Res
 	readTimestamp := strings.TrimPrefix(msg.Params[1], "timestamp=")
 	readTime, err := time.Parse(IRCv3TimestampFormat, readTimestamp)
 	if err != nil {
-		rb.Add(nil, server.name, "FAIL", "MARKREAD", "INVALID_PARAMS", utils.SafeErrorParam(readTimestamp), client.t("Invalid timestamp"))
+		rb.Fail("MARKREAD", "INVALID_PARAMS", client.t("Invalid timestamp"), utils.SafeErrorParam(readTimestamp))
 		return
 	}
 	result := client.SetReadMarker(cftarget, readTime)
@@ -3063,8 +3141,9 @@ func rehashHandler(server *Server, client *Client, msg ircmsg.Message, rb *Respo
 		// we used to send RPL_REHASHING here but i don't think it really makes sense
 		// in the labeled-response world, since the intent is "rehash in progress" but
 		// it won't display until the rehash is actually complete
-		// TODO all operators should get a notice of some kind here
 		rb.Notice(client.t("Rehash complete"))
+		server.snomasks.Send(sno.LocalOpers, fmt.Sprintf(ircfmt.Unescape("Operator $c[grey][$r%s$c[grey]] rehashed the server configuration"), nick))
+		server.audit(client, "REHASH", "", "")
 	} else {
 		rb.Add(nil, server.name, ERR_UNKNOWNERROR, nick, "REHASH", ircutils.SanitizeText(err.Error(), 350))
 	}
@@ -3075,7 +3154,7 @@ func rehashHandler(server *Server, client *Client, msg ircmsg.Message, rb *Respo
 func relaymsgHandler(server *Server, client *Client, msg ircmsg.Message, rb *ResponseBuffer) (result bool) {
 	config := server.Config()
 	if !config.Server.Relaymsg.Enabled {
-		rb.Add(nil, server.name, "FAIL", "RELAYMSG", "NOT_ENABLED", client.t("RELAYMSG has been disabled"))
+		rb.Fail("RELAYMSG", "NOT_ENABLED", client.t("RELAYMSG has been disabled"))
 		return false
 	}
 
@@ -3087,13 +3166,13 @@ func relaymsgHandler(server *Server, client *Client, msg ircmsg.Message, rb *Res
 
 	allowedToRelay := client.HasRoleCapabs("relaymsg") || (config.Server.Relaymsg.AvailableToChanops && channel.ClientIsAtLeast(client, modes.ChannelOperator))
 	if !allowedToRelay {
-		rb.Add(nil, server.name, "FAIL", "RELAYMSG", "PRIVS_NEEDED", client.t("You cannot relay messages to this channel"))
+		rb.Fail("RELAYMSG", "PRIVS_NEEDED", client.t("You cannot relay messages to this channel"))
 		return false
 	}
 
 	rawMessage := msg.Params[2]
 	if strings.TrimSpace(rawMessage) == "" {
-		rb.Add(nil, server.name, "FAIL", "RELAYMSG", "BLANK_MSG", client.t("The message must not be blank"))
+		rb.Fail("RELAYMSG", "BLANK_MSG", client.t("The message must not be blank"))
 		return false
 	}
 	message := utils.MakeMessage(rawMessage)
@@ -3101,15 +3180,15 @@ func relaymsgHandler(server *Server, client *Client, msg ircmsg.Message, rb *Res
 	nick := msg.Params[1]
 	cfnick, err := CasefoldName(nick)
 	if err != nil {
-		rb.Add(nil, server.name, "FAIL", "RELAYMSG", "INVALID_NICK", client.t("Invalid nickname"))
+		rb.Fail("RELAYMSG", "INVALID_NICK", client.t("Invalid nickname"))
 		return false
 	}
 	if !config.isRelaymsgIdentifier(nick) {
-		rb.Add(nil, server.name, "FAIL", "RELAYMSG", "INVALID_NICK", fmt.Sprintf(client.t("Relayed nicknames MUST contain a relaymsg separator from this set: %s"), config.Server.Relaymsg.Separators))
+		rb.Fail("RELAYMSG", "INVALID_NICK", fmt.Sprintf(client.t("Relayed nicknames MUST contain a relaymsg separator from this set: %s"), config.Server.Relaymsg.Separators))
 		return false
 	}
 	if channel.relayNickMuted(cfnick) {
-		rb.Add(nil, server.name, "FAIL", "RELAYMSG", "BANNED", fmt.Sprintf(client.t("%s is banned from relaying to the channel"), nick))
+		rb.Fail("RELAYMSG", "BANNED", fmt.Sprintf(client.t("%s is banned from relaying to the channel"), nick))
 		return false
 	}
 
@@ -3128,47 +3207,7 @@ func relaymsgHandler(server *Server, client *Client, msg ircmsg.Message, rb *Res
 	}
 	nuh := fmt.Sprintf("%s!%s@%s", nick, ident, hostname)
 
-	channel.AddHistoryItem(history.Item{
-		Type:    history.Privmsg,
-		Message: message,
-		Nick:    nuh,
-	}, "")
-
-	// 3 possibilities for tags:
-	// no tags, the relaymsg tag only, or the relaymsg tag together with all client-only tags
-	relayTag := map[string]string{
-		caps.RelaymsgTagName: details.nick,
-	}
-	clientOnlyTags := msg.ClientOnlyTags()
-	var fullTags map[string]string
-	if len(clientOnlyTags) == 0 {
-		fullTags = relayTag
-	} else {
-		fullTags = make(map[string]string, 1+len(clientOnlyTags))
-		fullTags[caps.RelaymsgTagName] = details.nick
-		for t, v := range clientOnlyTags {
-			fullTags[t] = v
-		}
-	}
-
-	// actually send the message
-	channelName := channel.Name()
-	for _, member := range channel.Members() {
-		for _, session := range member.Sessions() {
-			var tagsToUse map[string]string
-			if session.capabilities.Has(caps.MessageTags) {
-				tagsToUse = fullTags
-			} else if session.capabilities.Has(caps.Relaymsg) {
-				tagsToUse = relayTag
-			}
-
-			if session == rb.session {
-				rb.AddSplitMessageFromClient(nuh, "*", false, tagsToUse, "PRIVMSG", channelName, message)
-			} else {
-				session.sendSplitMsgFromClientInternal(false, nuh, "*", false, tagsToUse, "PRIVMSG", channelName, message)
-			}
-		}
-	}
+	channel.relayMessage(nuh, details.nick, config.Server.clientTagDeny.Filter(msg.ClientOnlyTags()), message, rb)
 	return false
 }
 
@@ -3194,14 +3233,14 @@ func renameHandler(server *Server, client *Client, msg ircmsg.Message, rb *Respo
 
 	founder := channel.Founder()
 	if founder != "" && founder != client.Account() {
-		rb.Add(nil, server.name, "FAIL", "RENAME", "CANNOT_RENAME", oldName, utils.SafeErrorParam(newName), client.t("Only channel founders can change registered channels"))
+		rb.Fail("RENAME", "CANNOT_RENAME", client.t("Only channel founders can change registered channels"), oldName, utils.SafeErrorParam(newName))
 		return false
 	}
 
 	config := server.Config()
 	status, _, _ := channel.historyStatus(config)
 	if status == HistoryPersistent {
-		rb.Add(nil, server.name, "FAIL", "RENAME", "CANNOT_RENAME", oldName, utils.SafeErrorParam(newName), client.t("Channels with persistent history cannot be renamed"))
+		rb.Fail("RENAME", "CANNOT_RENAME", client.t("Channels with persistent history cannot be renamed"), oldName, utils.SafeErrorParam(newName))
 		return false
 	}
 
@@ -3210,9 +3249,9 @@ func renameHandler(server *Server, client *Client, msg ircmsg.Message, rb *Respo
 	if err == errInvalidChannelName {
 		rb.Add(nil, server.name, ERR_NOSUCHCHANNEL, client.Nick(), utils.SafeErrorParam(newName), client.t(err.Error()))
 	} else if err == errChannelNameInUse || err == errConfusableIdentifier {
-		rb.Add(nil, server.name, "FAIL", "RENAME", "CHANNEL_NAME_IN_USE", oldName, utils.SafeErrorParam(newName), client.t(err.Error()))
+		rb.Fail("RENAME", "CHANNEL_NAME_IN_USE", client.t(err.Error()), oldName, utils.SafeErrorParam(newName))
 	} else if err != nil {
-		rb.Add(nil, server.name, "FAIL", "RENAME", "CANNOT_RENAME", oldName, utils.SafeErrorParam(newName), client.t("Cannot rename channel"))
+		rb.Fail("RENAME", "CANNOT_RENAME", client.t("Cannot rename channel"), oldName, utils.SafeErrorParam(newName))
 	}
 	if err != nil {
 		return false
@@ -3265,13 +3304,64 @@ func sanickHandler(server *Server, client *Client, msg ircmsg.Message, rb *Respo
 	targetNick := msg.Params[0]
 	target := server.clients.Get(targetNick)
 	if target == nil {
-		rb.Add(nil, server.name, "FAIL", "SANICK", "NO_SUCH_NICKNAME", utils.SafeErrorParam(targetNick), client.t("No such nick"))
+		rb.Fail("SANICK", "NO_SUCH_NICKNAME", client.t("No such nick"), utils.SafeErrorParam(targetNick))
 		return false
 	}
+	server.audit(client, "SANICK", strings.Join(msg.Params, " "), "")
 	performNickChange(server, client, target, nil, msg.Params[1], rb)
 	return false
 }
 
+// SAPART <nick> <channel>{,<channel>} [<reason>]
+func sapartHandler(server *Server, client *Client, msg ircmsg.Message, rb *ResponseBuffer) bool {
+	target := server.clients.Get(msg.Params[0])
+	if target == nil {
+		rb.Add(nil, server.name, ERR_NOSUCHNICK, client.Nick(), utils.SafeErrorParam(msg.Params[0]), client.t("No such nick"))
+		return false
+	}
+	var reason string
+	if len(msg.Params) > 2 {
+		reason = msg.Params[2]
+	}
+
+	message := fmt.Sprintf("Operator %s ran SAPART %s", client.Oper().Name, strings.Join(msg.Params, " "))
+	server.snomasks.Send(sno.LocalOpers, message)
+	server.logger.Info("opers", message)
+	server.audit(client, "SAPART", strings.Join(msg.Params[:2], " "), reason)
+
+	// XXX as with SANICK, arbitrarily pick the first session to receive the PART
+	// as its own response; all other sessions receive it same as a friend would
+	targetRb := rb
+	if target != client {
+		if sessions := target.Sessions(); len(sessions) != 0 {
+			targetRb = NewResponseBuffer(sessions[0])
+			defer targetRb.Send(false)
+		} else {
+			// a detached always-on client: the PART goes only to the channel members
+			// (and history); this buffer is never sent
+			targetRb = NewResponseBuffer(&Session{client: target})
+		}
+	}
+
+	tnick := target.Nick()
+	for _, chname := range strings.Split(msg.Params[1], ",") {
+		if chname == "" {
+			continue
+		}
+		channel := server.channels.Get(chname)
+		if channel == nil {
+			rb.Add(nil, server.name, ERR_NOSUCHCHANNEL, client.Nick(), utils.SafeErrorParam(chname), client.t("No such channel"))
+			continue
+		}
+		if !channel.hasClient(target) {
+			rb.Add(nil, server.name, ERR_USERNOTINCHANNEL, client.Nick(), tnick, channel.Name(), client.t("They aren't on that channel"))
+			continue
+		}
+		channel.Part(target, reason, targetRb)
+	}
+	return false
+}
+
 // SCENE <target> <message>
 func sceneHandler(server *Server, client *Client, msg ircmsg.Message, rb *ResponseBuffer) bool {
 	target := msg.Params[0]
@@ -3291,7 +3381,7 @@ func setnameHandler(server *Server, client *Client, msg ircmsg.Message, rb *Resp
 		realname = strings.Join(msg.Params, " ")
 	}
 	if realname == "" {
-		rb.Add(nil, server.name, "FAIL", "SETNAME", "INVALID_REALNAME", client.t("Realname is not valid"))
+		rb.Fail("SETNAME", "INVALID_REALNAME", client.t("Realname is not valid"))
 		return false
 	}
 
@@ -3311,6 +3401,92 @@ func setnameHandler(server *Server, client *Client, msg ircmsg.Message, rb *Resp
 	return false
 }
 
+// STATS <query>
+func statsHandler(server *Server, client *Client, msg ircmsg.Message, rb *ResponseBuffer) bool {
+	nick := client.Nick()
+	isOper := client.HasMode(modes.Operator)
+
+	query := "*"
+	if len(msg.Params) != 0 && msg.Params[0] != "" {
+		// the query is a single letter; take the whole first character, so as
+		// not to split a multibyte sequence (and echo it in RPL_ENDOFSTATS)
+		r, size := utf8.DecodeRuneInString(msg.Params[0])
+		if r != utf8.RuneError {
+			query = utils.SafeErrorParam(msg.Params[0][:size])
+		}
+	}
+
+	switch query {
+	case "u":
+		uptime := time.Since(server.ctime)
+		days := int(uptime / (24 * time.Hour))
+		uptime -= time.Duration(days) * 24 * time.Hour
+		hours := int(uptime / time.Hour)
+		uptime -= time.Duration(hours) * time.Hour
+		minutes := int(uptime / time.Minute)
+		uptime -= time.Duration(minutes) * time.Minute
+		seconds := int(uptime / time.Second)
+		rb.Add(nil, server.name, RPL_STATSUPTIME, nick, fmt.Sprintf(client.t("Server Up %[1]d days %[2]d:%02[3]d:%02[4]d"), days, hours, minutes, seconds))
+	case "m":
+		counts := server.stats.GetCommandCounts()
+		commands := make([]string, 0, len(counts))
+		for command := range counts {
+			commands = append(commands, command)
+		}
+		sort.Strings(commands)
+		for _, command := range commands {
+			rb.Add(nil, server.name, RPL_STATSCOMMANDS, nick, command, strconv.FormatUint(counts[command], 10))
+		}
+	case "o":
+		if !isOper {
+			rb.Add(nil, server.name, ERR_NOPRIVILEGES, nick, client.t("Permission Denied"))
+			break
+		}
+		operators := server.Config().operators
+		names := make([]string, 0, len(operators))
+		for name := range operators {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			rb.Add(nil, server.name, RPL_STATSOLINE, nick, "O", "*", "*", name, "0", operators[name].Class.Title)
+		}
+	case "l":
+		// unprivileged users can only see their own connections; as in WHOIS,
+		// other clients' real IPs are only visible to opers with "ban"
+		targets := []*Client{client}
+		if isOper {
+			targets = server.clients.AllClients()
+		}
+		showIPs := client.HasRoleCapabs("ban")
+		now := time.Now().UTC()
+		for _, target := range targets {
+			tnick := target.Nick()
+			showIP := showIPs || target == client
+			thostname := target.Hostname()
+			sessions, _ := target.AllSessionData(nil, false)
+			for _, session := range sessions {
+				host := thostname
+				if showIP {
+					host = utils.IPStringToHostname(session.ip.String())
+				}
+				rb.Add(nil, server.name, RPL_STATSLINKINFO, nick,
+					fmt.Sprintf("%s[%s]", tnick, host),
+					strconv.Itoa(session.sendQLen),
+					strconv.FormatUint(session.traffic.SentLines, 10),
+					strconv.FormatUint(session.traffic.SentBytes/1024, 10),
+					strconv.FormatUint(session.traffic.ReceivedLines, 10),
+					strconv.FormatUint(session.traffic.ReceivedBytes/1024, 10),
+					strconv.FormatInt(int64(now.Sub(session.ctime)/time.Second), 10))
+			}
+		}
+	}
+
+	rb.Add(nil, server.name, RPL_ENDOFSTATS, nick, query, client.t("End of /STATS report"))
+	server.snomasks.Send(sno.Stats, fmt.Sprintf(ircfmt.Unescape("$c[grey][$r%[1]s$c[grey]] requested STATS %[2]s"), nick, query))
+	return false
+}
+
 // SUMMON [parameters]
 func summonHandler(server *Server, client *Client, msg ircmsg.Message, rb *ResponseBuffer) bool {
 	rb.Add(nil, server.name, ERR_SUMMONDISABLED, client.Nick(), client.t("SUMMON has been disabled"))
@@ -3369,6 +3545,7 @@ func unDLineHandler(server *Server, client *Client, msg ircmsg.Message, rb *Resp
 	hostString = hostNet.String()
 	rb.Notice(fmt.Sprintf(client.t("Removed D-Line for %s"), hostString))
 	server.snomasks.Send(sno.LocalXline, fmt.Sprintf(ircfmt.Unescape("%s$r removed D-Line for %s"), client.nick, hostString))
+	server.audit(client, "UNDLINE", hostString, "")
 	return false
 }
 
@@ -3399,6 +3576,7 @@ func unKLineHandler(server *Server, client *Client, msg ircmsg.Message, rb *Resp
 
 	rb.Notice(fmt.Sprintf(client.t("Removed K-Line for %s"), mask))
 	server.snomasks.Send(sno.LocalXline, fmt.Sprintf(ircfmt.Unescape("%s$r removed K-Line for %s"), details.nick, mask))
+	server.audit(client, "UNKLINE", mask, "")
 	return false
 }
 
@@ -3469,7 +3647,8 @@ func userhostHandler(server *Server, client *Client, msg ircmsg.Message, rb *Res
 	var tl utils.TokenLineBuilder
 	tl.Initialize(maxLastArgLength, " ")
 	for i, nickname := range msg.Params {
-		if i >= 10 {
+		// RFC 2812: "The USERHOST command takes a list of up to 5 nicknames"
+		if i >= 5 {
 			break
 		}
 
@@ -3522,6 +3701,27 @@ func versionHandler(server *Server, client *Client, msg ircmsg.Message, rb *Resp
 	return false
 }
 
+// WALLOPS <text>
+func wallopsHandler(server *Server, client *Client, msg ircmsg.Message, rb *ResponseBuffer) bool {
+	message := utils.MakeMessage(msg.Params[0])
+	details := client.Details()
+	isBot := client.HasMode(modes.Bot)
+
+	for _, tClient := range server.clients.AllClients() {
+		if !tClient.HasMode(modes.WallOps) {
+			continue
+		}
+		for _, session := range tClient.Sessions() {
+			if session == rb.session {
+				rb.AddFromClient(message.Time, message.Msgid, details.nickMask, details.accountName, isBot, nil, "WALLOPS", message.Message)
+			} else {
+				session.sendFromClientInternal(false, message.Time, message.Msgid, details.nickMask, details.accountName, isBot, nil, "WALLOPS", message.Message)
+			}
+		}
+	}
+	return false
+}
+
 // WEBIRC <password> <gateway> <hostname> <ip> [:flag1 flag2=x flag3]
 func webircHandler(server *Server, client *Client, msg ircmsg.Message, rb *ResponseBuffer) bool {
 	// only allow unregistered clients to use this command
@@ -3979,6 +4179,7 @@ func whowasHandler(server *Server, client *Client, msg ircmsg.Message, rb *Respo
 				if canSeeIP {
 					rb.Add(nil, server.name, RPL_WHOWASIP, cnick, whoWas.nick, fmt.Sprintf(client.t("was connecting from %s"), utils.IPStringToHostname(whoWas.ip.String())))
 				}
+				rb.Add(nil, server.name, RPL_WHOISSERVER, cnick, whoWas.nick, server.name, whoWas.signoff.Format(time.RFC1123))
 			}
 		}
 		rb.Add(nil, server.name, RPL_ENDOFWHOWAS, cnick, utils.SafeErrorParam(nickname), client.t("End of WHOWAS"))
@@ -4013,6 +4214,6 @@ func unknownCommandHandler(server *Server, client *Client, msg ircmsg.Message, r
 
 // fake handler for invalid utf8
 func invalidUtf8Handler(server *Server, client *Client, msg ircmsg.Message, rb *ResponseBuffer) bool {
-	rb.Add(nil, server.name, "FAIL", utils.SafeErrorParam(msg.Command), "INVALID_UTF8", client.t("Message rejected for containing invalid UTF-8"))
+	rb.Fail(utils.SafeErrorParam(msg.Command), "INVALID_UTF8", client.t("Message rejected for containing invalid UTF-8"))
 	return false
 }
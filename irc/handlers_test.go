@@ -0,0 +1,244 @@
+package irc
+
+import (
+	"io"
+	"net"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ergochat/irc-go/ircmsg"
+
+	"github.com/ergochat/ergo/irc/caps"
+	"github.com/ergochat/ergo/irc/languages"
+	"github.com/ergochat/ergo/irc/logger"
+	"github.com/ergochat/ergo/irc/modes"
+	"github.com/ergochat/ergo/irc/utils"
+)
+
+// newHandlerTestClient returns an unregistered-looking client, attached to a
+// minimal server, for testing handlers that only send numerics
+func newHandlerTestClient(t *testing.T) (*Server, *Client, *Session) {
+	lm, err := languages.NewManager(false, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger, err := logger.NewManager(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &Server{name: "ergo.test", logger: logger}
+	server.config.Store(&Config{languageManager: lm})
+	server.webhooks.server = server
+	client := &Client{server: server, nick: "alice", nickCasefolded: "alice"}
+	session := &Session{client: client}
+	client.sessions = []*Session{session}
+	return server, client, session
+}
+
+// testIRCConn is an IRCConn that records the lines written to it
+type testIRCConn struct {
+	sync.Mutex
+	lines []string
+}
+
+func (c *testIRCConn) UnderlyingConn() *utils.WrappedConn { return &utils.WrappedConn{} }
+func (c *testIRCConn) ReadLine() ([]byte, error)          { return nil, io.EOF }
+func (c *testIRCConn) Close() error                       { return nil }
+
+func (c *testIRCConn) WriteLine(line []byte) error {
+	return c.WriteLines([][]byte{line})
+}
+
+func (c *testIRCConn) WriteLines(lines [][]byte) error {
+	c.Lock()
+	defer c.Unlock()
+	for _, line := range lines {
+		c.lines = append(c.lines, strings.TrimSuffix(string(line), "\r\n"))
+	}
+	return nil
+}
+
+// Lines returns the lines written so far; since sockets write asynchronously,
+// it waits briefly for `expected` lines to arrive
+func (c *testIRCConn) Lines(expected int) []string {
+	for i := 0; i < 100; i++ {
+		c.Lock()
+		n := len(c.lines)
+		c.Unlock()
+		if n >= expected {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	c.Lock()
+	defer c.Unlock()
+	return slices.Clone(c.lines)
+}
+
+// runHandler runs `handler` with the given params, returning the numerics and
+// params it sent
+func runHandler(t *testing.T, server *Server, client *Client, session *Session, handler func(*Server, *Client, ircmsg.Message, *ResponseBuffer) bool, params ...string) (replies []ircmsg.Message) {
+	rb := NewResponseBuffer(session)
+	handler(server, client, ircmsg.MakeMessage(nil, "", "", params...), rb)
+	return rb.messages
+}
+
+func TestStatsQuery(t *testing.T) {
+	server, client, session := newHandlerTestClient(t)
+	server.stats.CountCommand("PRIVMSG")
+	server.stats.CountCommand("PRIVMSG")
+	server.stats.CountCommand("JOIN")
+	// commands that aren't in the command table share a counter
+	server.stats.CountCommand("FOO")
+	server.stats.CountCommand("BAR")
+
+	endOfStats := func(replies []ircmsg.Message) string {
+		last := replies[len(replies)-1]
+		if last.Command != RPL_ENDOFSTATS {
+			t.Fatalf("expected RPL_ENDOFSTATS, got %s", last.Command)
+		}
+		return last.Params[1]
+	}
+
+	replies := runHandler(t, server, client, session, statsHandler, "m")
+	if len(replies) != 4 || endOfStats(replies) != "m" {
+		t.Fatalf("unexpected STATS m reply: %v", replies)
+	}
+	if replies[0].Command != RPL_STATSCOMMANDS || replies[0].Params[1] != "JOIN" || replies[0].Params[2] != "1" ||
+		replies[1].Params[1] != "PRIVMSG" || replies[1].Params[2] != "2" ||
+		replies[2].Params[1] != unknownCommandCounter || replies[2].Params[2] != "2" {
+		t.Errorf("unexpected command counters: %v", replies)
+	}
+
+	replies = runHandler(t, server, client, session, statsHandler, "uptime")
+	if len(replies) != 2 || replies[0].Command != RPL_STATSUPTIME || endOfStats(replies) != "u" {
+		t.Errorf("unexpected STATS u reply: %v", replies)
+	}
+
+	// opers only
+	replies = runHandler(t, server, client, session, statsHandler, "o")
+	if len(replies) != 2 || replies[0].Command != ERR_NOPRIVILEGES {
+		t.Errorf("unexpected STATS o reply: %v", replies)
+	}
+
+	// the query is echoed as a whole character, and never as an invalid param
+	for param, query := range map[string]string{"é": "é", "🐬x": "🐬", ":": "*", "\xff": "*", "": "*"} {
+		replies = runHandler(t, server, client, session, statsHandler, param)
+		if len(replies) != 1 || endOfStats(replies) != query {
+			t.Errorf("STATS %q: unexpected reply %v", param, replies)
+		}
+	}
+}
+
+func TestAdminHandler(t *testing.T) {
+	server, client, session := newHandlerTestClient(t)
+	numerics := func() (result []string) {
+		for _, reply := range runHandler(t, server, client, session, adminHandler) {
+			result = append(result, reply.Command)
+		}
+		return
+	}
+
+	if replies := numerics(); len(replies) != 1 || replies[0] != ERR_NOADMININFO {
+		t.Errorf("unexpected reply with no admin info: %v", replies)
+	}
+	server.Config().Server.Admin.Email = "admin@example.com"
+	if replies := numerics(); len(replies) != 2 || replies[0] != RPL_ADMINME || replies[1] != RPL_ADMINEMAIL {
+		t.Errorf("unset fields should be omitted: %v", replies)
+	}
+}
+
+func TestStatsLinkInfoIPs(t *testing.T) {
+	server, client, session := newHandlerTestClient(t)
+	server.clients.Initialize()
+	session.realIP = net.ParseIP("192.0.2.1")
+	session.socket = NewSocket(nil, 0, nil)
+	bob := &Client{server: server, nick: "bob", nickCasefolded: "bob", hostname: "cloaked.irc"}
+	bob.sessions = []*Session{{client: bob, realIP: net.ParseIP("198.51.100.2"), socket: NewSocket(nil, 0, nil)}}
+	server.clients.byNick["alice"], server.clients.byNick["bob"] = client, bob
+
+	links := func() (result []string) {
+		for _, reply := range runHandler(t, server, client, session, statsHandler, "l") {
+			if reply.Command == RPL_STATSLINKINFO {
+				result = append(result, reply.Params[1])
+			}
+		}
+		sort.Strings(result)
+		return
+	}
+
+	// unprivileged users only see themselves
+	if result := links(); !reflect.DeepEqual(result, []string{"alice[192.0.2.1]"}) {
+		t.Errorf("unexpected STATS l for unprivileged user: %v", result)
+	}
+	// opers without "ban" see other clients, but not their IPs
+	client.SetMode(modes.Operator, true)
+	client.oper = &Oper{Class: &OperClass{Capabilities: utils.SetLiteral("kill")}}
+	if result := links(); !reflect.DeepEqual(result, []string{"alice[192.0.2.1]", "bob[cloaked.irc]"}) {
+		t.Errorf("unexpected STATS l for oper without ban: %v", result)
+	}
+	client.oper = &Oper{Class: &OperClass{Capabilities: utils.SetLiteral("ban")}}
+	if result := links(); !reflect.DeepEqual(result, []string{"alice[192.0.2.1]", "bob[198.51.100.2]"}) {
+		t.Errorf("unexpected STATS l for oper with ban: %v", result)
+	}
+}
+
+func TestSapartDetachedTarget(t *testing.T) {
+	server, client, session := newHandlerTestClient(t)
+	server.clients.Initialize()
+	server.channels.chans = make(map[string]*channelManagerEntry)
+	client.SetMode(modes.Operator, true)
+	client.oper = &Oper{Name: "admin", Class: &OperClass{Capabilities: utils.SetLiteral("sajoin")}}
+
+	// an always-on client with no sessions, in a channel the oper isn't in
+	bob := &Client{server: server, nick: "bob", nickCasefolded: "bob", channels: make(ChannelSet), alwaysOn: true}
+	server.clients.byNick["alice"], server.clients.byNick["bob"] = client, bob
+	channel := NewChannel(server, "#test", "#test", false, RegisteredChannel{})
+	server.channels.chans["#test"] = &channelManagerEntry{channel: channel}
+	channel.members.Add(bob)
+	bob.channels.Add(channel)
+
+	for _, reply := range runHandler(t, server, client, session, sapartHandler, "bob", "#test") {
+		t.Errorf("unexpected reply to the oper: %s %v", reply.Command, reply.Params)
+	}
+	if channel.hasClient(bob) {
+		t.Errorf("bob should have been parted from the channel")
+	}
+}
+
+func TestRelaymsgClientTagDeny(t *testing.T) {
+	server, client, session := newHandlerTestClient(t)
+	config := server.Config()
+	config.Server.Relaymsg.Enabled = true
+	config.Server.Relaymsg.Separators = "/"
+	var err error
+	if config.Server.clientTagDeny, err = parseClientTagDenyList([]string{"*", "-reply"}); err != nil {
+		t.Fatal(err)
+	}
+	server.channels.chans = make(map[string]*channelManagerEntry)
+	client.oper = &Oper{Name: "bot", Class: &OperClass{Capabilities: utils.SetLiteral("relaymsg")}}
+	client.channels = make(ChannelSet)
+	session.capabilities.Enable(caps.MessageTags)
+	channel := NewChannel(server, "#test", "#test", false, RegisteredChannel{})
+	server.channels.chans["#test"] = &channelManagerEntry{channel: channel}
+	channel.members.Add(client)
+	channel.regenerateMembersCache()
+
+	rb := NewResponseBuffer(session)
+	msg := ircmsg.MakeMessage(map[string]string{"+reply": "abc", "+typing": "active"}, "", "RELAYMSG", "#test", "bob/discord", "hi")
+	relaymsgHandler(server, client, msg, rb)
+	if len(rb.messages) != 1 || rb.messages[0].Command != "PRIVMSG" {
+		t.Fatalf("unexpected replies: %v", rb.messages)
+	}
+	if present, _ := rb.messages[0].GetTag("+typing"); present {
+		t.Errorf("denied client-only tag was relayed")
+	}
+	if _, value := rb.messages[0].GetTag("+reply"); value != "abc" {
+		t.Errorf("permitted client-only tag was not relayed")
+	}
+}
@@ -40,6 +40,8 @@ var (
 Ergo supports the following channel modes:
 
   +b  |  Client masks that are banned from the channel (e.g. *!*@127.0.0.1)
+         Extended bans are also supported: a:<account> matches an account name,
+         r:<realname> matches a realname, and m:<mask> mutes the mask instead.
   +e  |  Client masks that are exempted from bans.
   +I  |  Client masks that are exempted from the invite-only flag.
   +i  |  Invite-only mode, only invited clients can join the channel.
@@ -80,7 +82,8 @@ Ergo supports the following user modes:
   +Z  |  User is connected via TLS.
   +B  |  User is a bot.
   +E  |  User can receive roleplaying commands.
-  +T  |  CTCP messages to the user are blocked.`
+  +T  |  CTCP messages to the user are blocked.
+  +w  |  User receives WALLOPS messages from operators.`
 	snomaskHelpText = `== Server Notice Masks ==
 
 Ergo supports the following server notice masks for operators:
@@ -93,6 +96,7 @@ Ergo supports the following server notice masks for operators:
   n  |  Local nick changes.
   o  |  Local oper actions.
   q  |  Local quits.
+  r  |  Local rejected connections (bans, limits, failed auth).
   t  |  Local /STATS usage.
   u  |  Local client account actions.
   x  |  Local X-lines (DLINE/KLINE/etc).
@@ -116,11 +120,25 @@ var Help = map[string]HelpEntry{
 ACCEPT allows the target user to send you direct messages, overriding any
 restrictions that might otherwise prevent this. Currently, the only
 applicable restriction is the +R registered-only mode.`,
+	},
+	"admin": {
+		text: `ADMIN [server]
+
+Shows the administrative contact information for the server.`,
 	},
 	"ambiance": {
 		text: `AMBIANCE <target> <text to be sent>
 
 The AMBIANCE command is used to send a scene notification to the given target.`,
+	},
+	"audit": {
+		oper: true,
+		text: `AUDIT [limit]
+
+AUDIT shows the most recent privileged operator actions (KILL, DLINE, KLINE,
+UBAN, SAMODE, REHASH, account and channel overrides, etc.), up to the given
+limit (default 25). The full audit log can be kept by configuring a logger
+for the "audit" log type.`,
 	},
 	"authenticate": {
 		text: `AUTHENTICATE
@@ -187,6 +205,13 @@ spam or other hostile activity. It has five levels, which are cumulative
 		text: `DEOPER
 
 DEOPER removes the IRCop privileges granted to you by a successful /OPER.`,
+	},
+	"die": {
+		oper: true,
+		text: `DIE [reason]
+
+DIE shuts down the server. Clients are disconnected with the given reason,
+after which state is persisted and the process exits.`,
 	},
 	"dline": {
 		oper: true,
@@ -313,6 +338,12 @@ ON <server> specifies that the ban is to be set on that specific server.
 If "KLINE LIST" is sent, the server sends back a list of our current KLINEs.
 
 To remove a KLINE, use the "UNKLINE" command.`,
+	},
+	"knock": {
+		text: `KNOCK <channel> [message]
+
+Asks the operators of an invite-only channel to invite you, optionally
+including a message explaining why you want to join.`,
 	},
 	"language": {
 		text: `LANGUAGE <code>{ <code>}
@@ -471,6 +502,12 @@ For example:
 
 Forcibly joins a user to a channel, ignoring restrictions like bans, user limits
 and channel keys. If [nick] is omitted, it defaults to the operator.`,
+	},
+	"sapart": {
+		oper: true,
+		text: `SAPART <nick> #channel{,#channel} [reason]
+
+Forcibly removes a user from the given channels, with an optional part message.`,
 	},
 	"sanick": {
 		oper: true,
@@ -495,6 +532,19 @@ The SCENE command is used to send a scene notification to the given target.`,
 		text: `SETNAME <realname>
 
 The SETNAME command updates the realname to be the newly-given one.`,
+	},
+	"stats": {
+		text: `STATS <query>
+
+Shows server statistics. The following queries are supported:
+
+  u  |  Server uptime.
+  m  |  Number of times each command has been used (unrecognized commands
+        are counted together, as UNKNOWN).
+  o  |  Configured operator blocks (operators only).
+  l  |  Connection statistics, such as SendQ and traffic counts. Operators see
+        every connection (with IPs only if they have the "ban" capability);
+        other users see only their own.`,
 	},
 	"summon": {
 		text: `SUMMON [parameters]
@@ -586,7 +636,7 @@ The USERS command is not implemented.`,
 	"userhost": {
 		text: `USERHOST <nickname>{ <nickname>}
 		
-Shows information about the given users. Takes up to 10 nicknames.`,
+Shows information about the given users. Takes up to 5 nicknames.`,
 	},
 	"verify": {
 		text: `VERIFY <account> <code>
@@ -597,6 +647,12 @@ Verifies an account in accordance with the draft/account-registration capability
 		text: `VERSION [server]
 
 Views the version of software and the RPL_ISUPPORT tokens for the given server.`,
+	},
+	"wallops": {
+		oper: true,
+		text: `WALLOPS <text>
+
+Sends <text> to every user who has user mode +w set.`,
 	},
 	"webirc": {
 		oper: true, // not really, but it's restricted anyways
@@ -610,6 +666,15 @@ ircv3.net/specs/extensions/webirc.html
 the connection from the client to the gateway, such as:
 
 - tls: this flag indicates that the client->gateway connection is secure`,
+	},
+	"webpush": {
+		text: `WEBPUSH REGISTER <endpoint> <keys>
+WEBPUSH UNREGISTER <endpoint>
+
+Registers (or unregisters) a Web Push subscription for your account. While
+your always-on client has no connected sessions, direct messages and channel
+messages that mention your nickname are delivered to your subscriptions.
+<keys> contains the subscription's keys, in the form p256dh=<key>;auth=<key>.`,
 	},
 	"who": {
 		text: `WHO <name> [o]
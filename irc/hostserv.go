@@ -179,9 +179,11 @@ func hsSetHandler(service *ircService, server *Server, client *Client, command s
 	} else if vhost != "" {
 		service.Notice(rb, client.t("Successfully set vhost"))
 		server.snomasks.Send(sno.LocalVhosts, fmt.Sprintf("Operator %[1]s set vhost %[2]s on account %[3]s", oper.Name, vhost, user))
+		server.audit(client, "HS SET", user, vhost)
 	} else {
 		service.Notice(rb, client.t("Successfully cleared vhost"))
 		server.snomasks.Send(sno.LocalVhosts, fmt.Sprintf("Operator %[1]s cleared vhost on account %[2]s", oper.Name, user))
+		server.audit(client, "HS DEL", user, "")
 	}
 }
 
@@ -194,5 +196,6 @@ func hsSetCloakSecretHandler(service *ircService, server *Server, client *Client
 		return
 	}
 	StoreCloakSecret(server.dstore, secret)
+	server.audit(client, "HS SETCLOAKSECRET", "", "")
 	service.Notice(rb, client.t("Rotated the cloak secret; you must rehash or restart the server for it to take effect"))
 }
@@ -25,13 +25,19 @@ type JWTAuthConfig struct {
 }
 
 type JWTAuthTokenConfig struct {
-	Algorithm     string `yaml:"algorithm"`
-	KeyString     string `yaml:"key"`
-	KeyFile       string `yaml:"key-file"`
-	key           any
-	parser        *jwt.Parser
-	AccountClaims []string `yaml:"account-claims"`
-	StripDomain   string   `yaml:"strip-domain"`
+	Algorithm string `yaml:"algorithm"`
+	KeyString string `yaml:"key"`
+	KeyFile   string `yaml:"key-file"`
+	// for algorithm "jwks", the URL of the issuer's JWK Set
+	JWKSURL           string `yaml:"jwks-url"`
+	key               any
+	jwks              *jwksCache
+	parser            *jwt.Parser
+	Issuer            string   `yaml:"issuer"`
+	Audience          string   `yaml:"audience"`
+	RequireExpiration bool     `yaml:"require-expiration"`
+	AccountClaims     []string `yaml:"account-claims"`
+	StripDomain       string   `yaml:"strip-domain"`
 }
 
 func (j *JWTAuthConfig) Postprocess() error {
@@ -53,13 +59,17 @@ func (j *JWTAuthConfig) Postprocess() error {
 }
 
 func (j *JWTAuthTokenConfig) Postprocess() error {
-	keyBytes, err := j.keyBytes()
-	if err != nil {
-		return err
-	}
-
 	j.Algorithm = strings.ToLower(j.Algorithm)
 
+	var keyBytes []byte
+	if j.Algorithm != "jwks" {
+		var err error
+		keyBytes, err = j.keyBytes()
+		if err != nil {
+			return err
+		}
+	}
+
 	var methods []string
 	switch j.Algorithm {
 	case "hmac":
@@ -79,10 +89,28 @@ func (j *JWTAuthTokenConfig) Postprocess() error {
 		}
 		j.key = eddsaKey
 		methods = []string{"EdDSA"}
+	case "jwks":
+		if j.JWKSURL == "" {
+			return fmt.Errorf("JWT algorithm jwks requires a jwks-url")
+		}
+		// the keys are fetched on first use, so that the issuer being
+		// unreachable doesn't prevent the server from starting
+		j.jwks = newJWKSCache(j.JWKSURL)
+		methods = jwksMethods
 	default:
 		return fmt.Errorf("invalid jwt algorithm: %s", j.Algorithm)
 	}
-	j.parser = jwt.NewParser(jwt.WithValidMethods(methods))
+	options := []jwt.ParserOption{jwt.WithValidMethods(methods)}
+	if j.Issuer != "" {
+		options = append(options, jwt.WithIssuer(j.Issuer))
+	}
+	if j.Audience != "" {
+		options = append(options, jwt.WithAudience(j.Audience))
+	}
+	if j.RequireExpiration {
+		options = append(options, jwt.WithExpirationRequired())
+	}
+	j.parser = jwt.NewParser(options...)
 
 	if len(j.AccountClaims) == 0 {
 		return fmt.Errorf("JWT auth enabled, but no account-claims specified")
@@ -122,7 +150,10 @@ func (j *JWTAuthTokenConfig) keyBytes() (result []byte, err error) {
 }
 
 // implements jwt.Keyfunc
-func (j *JWTAuthTokenConfig) keyFunc(_ *jwt.Token) (interface{}, error) {
+func (j *JWTAuthTokenConfig) keyFunc(token *jwt.Token) (interface{}, error) {
+	if j.jwks != nil {
+		return j.jwks.keyFunc(token)
+	}
 	return j.key, nil
 }
 
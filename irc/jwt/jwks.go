@@ -0,0 +1,241 @@
+package jwt
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	jwksTimeout = 10 * time.Second
+	// how often to refetch the key set
+	jwksRefreshInterval = time.Hour
+	// how often to refetch the key set when a token refers to an unknown key
+	// (e.g., after the issuer has rotated its keys)
+	jwksMissRefreshInterval = time.Minute
+	maxJWKSSize             = 1 << 20
+)
+
+var (
+	ErrUnknownKey = fmt.Errorf("JWT token was not signed by a known key")
+
+	jwksMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}
+)
+
+// jsonWebKey is a public key from a JWK Set (RFC 7517); only the
+// parameters needed to verify signatures are parsed
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	// RSA
+	N string `json:"n"`
+	E string `json:"e"`
+	// EC and OKP
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+type jwksKey struct {
+	alg string
+	key any
+}
+
+// jwksCache fetches and caches the keys published at a JWKS URL
+type jwksCache struct {
+	sync.Mutex
+
+	url         string
+	keys        map[string]jwksKey
+	fetched     time.Time
+	lastAttempt time.Time
+	lastErr     error
+	// closed when the fetch in progress, if any, completes
+	fetching chan struct{}
+}
+
+func newJWKSCache(url string) *jwksCache {
+	return &jwksCache{url: url}
+}
+
+// implements jwt.Keyfunc
+func (c *jwksCache) keyFunc(token *jwt.Token) (any, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	key, ok, err := c.getKey(kid)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	if key.alg != "" && key.alg != token.Method.Alg() {
+		return nil, fmt.Errorf("JWT token algorithm %s does not match key algorithm %s", token.Method.Alg(), key.alg)
+	}
+	return key.key, nil
+}
+
+// getKey looks up a key by ID, refreshing the key set if necessary. the fetch
+// is done without holding the lock, and only one fetch is in flight at a time;
+// concurrent lookups use the cached keys, or wait for the fetch if the key is
+// missing from them.
+func (c *jwksCache) getKey(kid string) (key jwksKey, ok bool, err error) {
+	c.Lock()
+	now := time.Now()
+	key, ok = c.lookup(kid)
+	fetching := c.fetching
+	if fetching == nil && (now.Sub(c.fetched) > jwksRefreshInterval || (!ok && now.Sub(c.lastAttempt) > jwksMissRefreshInterval)) {
+		c.lastAttempt = now
+		fetching = make(chan struct{})
+		c.fetching = fetching
+		c.Unlock()
+
+		keys, err := fetchJWKS(c.url)
+
+		c.Lock()
+		if err == nil {
+			c.keys, c.fetched = keys, now
+		}
+		// otherwise, keep using the cached keys until the issuer is reachable again
+		c.lastErr = err
+		c.fetching = nil
+		close(fetching)
+	} else if fetching != nil && !ok {
+		c.Unlock()
+		<-fetching
+		c.Lock()
+	} else {
+		c.Unlock()
+		return
+	}
+	defer c.Unlock()
+	if c.keys == nil && c.lastErr != nil {
+		return key, false, c.lastErr
+	}
+	key, ok = c.lookup(kid)
+	return
+}
+
+func (c *jwksCache) lookup(kid string) (key jwksKey, ok bool) {
+	if kid == "" && len(c.keys) == 1 {
+		// a token without a key ID is acceptable if the set has only one key
+		for _, key := range c.keys {
+			return key, true
+		}
+	}
+	key, ok = c.keys[kid]
+	return
+}
+
+func fetchJWKS(url string) (keys map[string]jwksKey, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), jwksTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("JWKS endpoint returned HTTP status %d", resp.StatusCode)
+	}
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxJWKSSize)).Decode(&set); err != nil {
+		return nil, err
+	}
+	return parseJWKS(set.Keys), nil
+}
+
+// parseJWKS returns the usable signing keys from a JWK Set, by key ID;
+// keys of unsupported types are skipped
+func parseJWKS(jwks []jsonWebKey) (keys map[string]jwksKey) {
+	keys = make(map[string]jwksKey, len(jwks))
+	for _, jwk := range jwks {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			continue
+		}
+		keys[jwk.Kid] = jwksKey{alg: jwk.Alg, key: key}
+	}
+	return
+}
+
+func (jwk *jsonWebKey) publicKey() (any, error) {
+	switch jwk.Kty {
+	case "RSA":
+		n, err := decodeJWKInt(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeJWKInt(jwk.E)
+		if err != nil || !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, fmt.Errorf("invalid RSA exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %s", jwk.Crv)
+		}
+		x, err := decodeJWKInt(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeJWKInt(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, fmt.Errorf("invalid EC point")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "OKP":
+		if jwk.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %s", jwk.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid Ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %s", jwk.Kty)
+	}
+}
+
+func decodeJWKInt(value string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || len(data) == 0 {
+		return nil, fmt.Errorf("invalid JWK parameter")
+	}
+	return new(big.Int).SetBytes(data), nil
+}
@@ -0,0 +1,168 @@
+package jwt
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt/v5"
+)
+
+func TestJWKSBearerAuth(t *testing.T) {
+	rsaKey, err := jwt.ParseRSAPrivateKeyFromPEM([]byte(rsaTestPrivKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	edPub, edPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b64 := base64.RawURLEncoding.EncodeToString
+	keys := []jsonWebKey{
+		{Kty: "RSA", Kid: "rsa1", Alg: "RS256", N: b64(rsaKey.N.Bytes()), E: b64(big.NewInt(int64(rsaKey.E)).Bytes())},
+		{Kty: "OKP", Kid: "ed1", Crv: "Ed25519", X: b64(edPub)},
+		// encryption keys are ignored
+		{Kty: "OKP", Kid: "enc1", Use: "enc", Crv: "Ed25519", X: b64(edPub)},
+	}
+	var fetches atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		json.NewEncoder(w).Encode(map[string]any{"keys": keys})
+	}))
+	defer server.Close()
+
+	j := JWTAuthConfig{
+		Enabled: true,
+		Tokens: []JWTAuthTokenConfig{
+			{
+				Algorithm:         "jwks",
+				JWKSURL:           server.URL,
+				Issuer:            "https://idp.example.com",
+				Audience:          "ergo",
+				RequireExpiration: true,
+				AccountClaims:     []string{"preferred_username"},
+			},
+		},
+	}
+	if err := j.Postprocess(); err != nil {
+		t.Fatal(err)
+	}
+
+	sign := func(method jwt.SigningMethod, kid string, key any, claims jwt.MapClaims) string {
+		token := jwt.NewWithClaims(method, claims)
+		if kid != "" {
+			token.Header["kid"] = kid
+		}
+		signed, err := token.SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+	claims := func(iss, aud string) jwt.MapClaims {
+		return jwt.MapClaims{"preferred_username": "slingamn", "iss": iss, "aud": aud, "exp": 4102444800}
+	}
+	valid := claims("https://idp.example.com", "ergo")
+
+	for _, token := range []string{
+		sign(jwt.SigningMethodRS256, "rsa1", rsaKey, valid),
+		sign(jwt.SigningMethodEdDSA, "ed1", edPriv, valid),
+	} {
+		if accountName, err := j.Validate(token); err != nil || accountName != "slingamn" {
+			t.Errorf("could not validate valid token: %s %v", accountName, err)
+		}
+	}
+	if fetches.Load() != 1 {
+		t.Errorf("expected the key set to be fetched once, got %d", fetches.Load())
+	}
+
+	invalid := map[string]string{
+		"wrong issuer":      sign(jwt.SigningMethodRS256, "rsa1", rsaKey, claims("https://evil.example.com", "ergo")),
+		"wrong audience":    sign(jwt.SigningMethodRS256, "rsa1", rsaKey, claims("https://idp.example.com", "other")),
+		"no expiration":     sign(jwt.SigningMethodRS256, "rsa1", rsaKey, jwt.MapClaims{"preferred_username": "slingamn", "iss": "https://idp.example.com", "aud": "ergo"}),
+		"wrong key":         sign(jwt.SigningMethodEdDSA, "rsa1", edPriv, valid),
+		"mismatched alg":    sign(jwt.SigningMethodRS512, "rsa1", rsaKey, valid),
+		"unknown key":       sign(jwt.SigningMethodEdDSA, "ed2", edPriv, valid),
+		"encryption key":    sign(jwt.SigningMethodEdDSA, "enc1", edPriv, valid),
+		"ambiguous key":     sign(jwt.SigningMethodEdDSA, "", edPriv, valid),
+		"hmac with pub key": sign(jwt.SigningMethodHS256, "ed1", []byte(edPub), valid),
+	}
+	for name, token := range invalid {
+		if _, err := j.Validate(token); err == nil {
+			t.Errorf("validated invalid token (%s)", name)
+		}
+	}
+	// unknown key IDs trigger a refetch, but not more than once per interval
+	if fetches.Load() != 1 {
+		t.Errorf("expected the key set not to be refetched, got %d fetches", fetches.Load())
+	}
+	j.Tokens[0].jwks.lastAttempt = time.Time{}
+	j.Validate(invalid["unknown key"])
+	if fetches.Load() != 2 {
+		t.Errorf("expected the key set to be refetched, got %d fetches", fetches.Load())
+	}
+}
+
+func TestJWKSFetchWithoutLock(t *testing.T) {
+	edPub, edPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := []jsonWebKey{{Kty: "OKP", Kid: "ed1", Crv: "Ed25519", X: base64.RawURLEncoding.EncodeToString(edPub)}}
+	var fetches atomic.Int32
+	block := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fetches.Add(1) > 1 {
+			<-block
+		}
+		json.NewEncoder(w).Encode(map[string]any{"keys": keys})
+	}))
+	defer server.Close()
+	defer close(block)
+
+	j := JWTAuthConfig{
+		Enabled: true,
+		Tokens:  []JWTAuthTokenConfig{{Algorithm: "jwks", JWKSURL: server.URL, AccountClaims: []string{"preferred_username"}}},
+	}
+	if err := j.Postprocess(); err != nil {
+		t.Fatal(err)
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodEdDSA, jwt.MapClaims{"preferred_username": "slingamn"}).SignedString(edPriv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := j.Validate(token); err != nil {
+		t.Fatal(err)
+	}
+
+	// force a refresh, which blocks at the server
+	cache := j.Tokens[0].jwks
+	cache.Lock()
+	cache.fetched = time.Time{}
+	cache.Unlock()
+	go j.Validate(token)
+	for fetches.Load() != 2 {
+		time.Sleep(time.Millisecond)
+	}
+	// other validations can use the cached keys in the meantime
+	done := make(chan error)
+	go func() {
+		_, err := j.Validate(token)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("could not validate token during refresh: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("validation blocked on the refresh in progress")
+	}
+}
@@ -45,6 +45,14 @@ func NewListener(server *Server, addr string, config utils.ListenerConfig, bindM
 }
 
 func createBaseListener(server *Server, addr string, bindMode os.FileMode) (listener net.Listener, err error) {
+	// prefer a matching socket passed in by systemd socket activation, if any:
+	for i, systemdListener := range server.systemdListeners {
+		if utils.ListenerAddrMatches(addr, systemdListener.Addr()) {
+			server.systemdListeners = append(server.systemdListeners[:i], server.systemdListeners[i+1:]...)
+			return systemdListener, nil
+		}
+	}
+
 	addr = strings.TrimPrefix(addr, "unix:")
 	if strings.HasPrefix(addr, "/") {
 		// https://stackoverflow.com/a/34881585
@@ -100,6 +108,7 @@ func (nl *NetListener) serve() {
 			wConn, ok := conn.(*utils.WrappedConn)
 			if ok {
 				confirmProxyData(wConn, "", "", "", nl.server.Config())
+				nl.server.stats.CountAccept(nl.addr)
 				go nl.server.RunClient(NewIRCStreamConn(wConn))
 			} else {
 				nl.server.logger.Error("internal", "invalid connection type", nl.addr)
@@ -191,6 +200,7 @@ func (wl *WSListener) handle(w http.ResponseWriter, r *http.Request) {
 	// avoid a DoS attack from buffering excessively large messages:
 	conn.SetReadLimit(int64(maxReadQBytes()))
 
+	wl.server.stats.CountAccept(wl.addr)
 	go wl.server.RunClient(NewIRCWSConn(conn))
 }
 
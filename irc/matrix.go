@@ -0,0 +1,273 @@
+package irc
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/ergochat/irc-go/ircfmt"
+
+	"github.com/ergochat/ergo/irc/matrix"
+	"github.com/ergochat/ergo/irc/utils"
+)
+
+// the Matrix bridge relays messages between IRC channels and Matrix rooms,
+// as a Matrix application service. messages from Matrix are relayed into IRC
+// like RELAYMSG, with a nick of the form <display name><separator><nick-suffix>;
+// messages from IRC are sent by a virtual Matrix user representing the sender.
+
+const (
+	matrixQueueSize = 256
+	// value of the relaymsg tag for messages relayed from Matrix,
+	// and the hostname of their spoofed NUH
+	matrixRelayer = "matrix"
+)
+
+type matrixOutgoing struct {
+	roomID      string
+	localpart   string
+	displayname string
+	msgType     string
+	body        string
+}
+
+// the settings that require restarting the bridge when they change
+type matrixBridgeSettings struct {
+	appservice matrix.Config
+	listener   string
+}
+
+// MatrixBridge manages the bridge's application service.
+type MatrixBridge struct {
+	sync.Mutex // tier 1
+
+	server     *Server
+	settings   matrixBridgeSettings
+	appservice *matrix.AppService
+	httpServer *http.Server
+	outgoing   chan matrixOutgoing
+
+	// Matrix user IDs to display names, as learned from membership events
+	displaynames sync.Map
+}
+
+// Stop stops the bridge's listener, e.g., on server shutdown.
+func (bridge *MatrixBridge) Stop() {
+	bridge.Lock()
+	defer bridge.Unlock()
+
+	bridge.stop()
+	bridge.settings = matrixBridgeSettings{}
+}
+
+// requires the lock
+func (bridge *MatrixBridge) stop() {
+	if bridge.appservice != nil {
+		bridge.server.logger.Info("matrix", "Stopping Matrix bridge listener", bridge.httpServer.Addr)
+		bridge.httpServer.Close()
+		close(bridge.outgoing)
+		bridge.appservice, bridge.httpServer, bridge.outgoing = nil, nil, nil
+	}
+}
+
+// ApplyConfig (re)starts or stops the bridge as necessary; the room
+// mappings are read from the current config as messages are relayed.
+func (bridge *MatrixBridge) ApplyConfig(server *Server, config *Config) {
+	bridge.Lock()
+	defer bridge.Unlock()
+
+	bridge.server = server
+	var settings matrixBridgeSettings
+	if config.Matrix.Enabled {
+		settings = matrixBridgeSettings{appservice: config.Matrix.appserviceConfig(), listener: config.Matrix.Listener}
+	}
+	if settings == bridge.settings {
+		return
+	}
+
+	bridge.stop()
+	bridge.settings = settings
+	if settings.listener == "" {
+		return
+	}
+
+	var appservice *matrix.AppService
+	appservice = matrix.NewAppService(settings.appservice, server.logger, func(event *matrix.Event) {
+		bridge.relayFromMatrix(appservice, event)
+	})
+	httpServer := &http.Server{
+		Addr:    settings.listener,
+		Handler: appservice,
+	}
+	go func() {
+		if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			server.logger.Error("matrix", "Matrix bridge listener failed", err.Error())
+		}
+	}()
+	outgoing := make(chan matrixOutgoing, matrixQueueSize)
+	bridge.appservice, bridge.httpServer, bridge.outgoing = appservice, httpServer, outgoing
+	go bridge.processOutgoing(appservice, outgoing)
+	server.logger.Info("matrix", "Started Matrix bridge listener", settings.listener)
+}
+
+func (bridge *MatrixBridge) processOutgoing(appservice *matrix.AppService, outgoing chan matrixOutgoing) {
+	for item := range outgoing {
+		if err := appservice.SendMessage(item.roomID, item.localpart, item.displayname, item.msgType, item.body); err != nil {
+			bridge.server.logger.Warning("matrix", "Couldn't relay message to Matrix room", item.roomID, err.Error())
+		}
+	}
+}
+
+// RelayFromIRC sends a message sent to an IRC channel to its bridged
+// Matrix room, if it has one.
+func (bridge *MatrixBridge) RelayFromIRC(channel *Channel, nick string, message utils.SplitMessage) {
+	if bridge.server == nil {
+		return
+	}
+	config := bridge.server.Config()
+	if !config.Matrix.Enabled {
+		return
+	}
+	roomID, ok := config.Matrix.ircToMatrix[channel.NameCasefolded()]
+	if !ok {
+		return
+	}
+	msgType, body := formatMessageForMatrix(message)
+	if body == "" {
+		return
+	}
+	cfnick, err := CasefoldName(nick)
+	if err != nil {
+		return
+	}
+
+	bridge.Lock()
+	defer bridge.Unlock()
+	if bridge.outgoing == nil {
+		return
+	}
+	item := matrixOutgoing{
+		roomID:      roomID,
+		localpart:   bridge.appservice.PuppetLocalpart(cfnick),
+		displayname: nick,
+		msgType:     msgType,
+		body:        body,
+	}
+	select {
+	case bridge.outgoing <- item:
+	default:
+		bridge.server.logger.Warning("matrix", "Outgoing queue is full, dropping message for", channel.Name())
+	}
+}
+
+func (bridge *MatrixBridge) relayFromMatrix(appservice *matrix.AppService, event *matrix.Event) {
+	server := bridge.server
+	defer server.HandlePanic()
+
+	if appservice.IsOwnUser(event.Sender) {
+		return // our own user, or a message relayed from IRC
+	}
+	switch event.Type {
+	case "m.room.member":
+		var member matrix.MemberContent
+		if event.StateKey == nil || json.Unmarshal(event.Content, &member) != nil {
+			return
+		}
+		if member.Membership == "join" && member.Displayname != "" {
+			bridge.displaynames.Store(*event.StateKey, member.Displayname)
+		} else if member.Membership == "leave" || member.Membership == "ban" {
+			bridge.displaynames.Delete(*event.StateKey)
+		}
+		return
+	case "m.room.message":
+	default:
+		return
+	}
+
+	config := server.Config()
+	chname, ok := config.Matrix.matrixToIRC[event.RoomID]
+	if !ok {
+		return
+	}
+	channel := server.channels.Get(chname)
+	if channel == nil {
+		return
+	}
+	var content matrix.MessageContent
+	if err := json.Unmarshal(event.Content, &content); err != nil || content.IsEdit() {
+		return
+	}
+	text, err := matrixMessageText(appservice, &content)
+	if err != nil {
+		return
+	}
+	message, ok := makeRelayedSplitMessage(text)
+	if !ok {
+		return
+	}
+
+	var displayname string
+	if value, ok := bridge.displaynames.Load(event.Sender); ok {
+		displayname = value.(string)
+	}
+	nick := matrixRelayNick(config, event.Sender, displayname)
+	if err := bridgeRelayMessage(channel, nick, matrixRelayer, message); err != nil {
+		server.logger.Debug("matrix", "Couldn't compute a relay nick for Matrix user", event.Sender)
+	}
+}
+
+var errUnsupportedMsgType = errors.New("unsupported message type")
+
+// matrixMessageText renders the content of an m.room.message event as IRC text
+func matrixMessageText(appservice *matrix.AppService, content *matrix.MessageContent) (string, error) {
+	switch content.MsgType {
+	case matrix.MsgTypeText, matrix.MsgTypeNotice:
+		return content.Text(), nil
+	case matrix.MsgTypeEmote:
+		text := strings.ReplaceAll(content.Text(), "\n", " ")
+		return fmt.Sprintf("\x01ACTION %s\x01", text), nil
+	case "m.image", "m.file", "m.audio", "m.video":
+		mediaURL := appservice.MediaURL(content.URL)
+		if mediaURL == "" {
+			return "", errUnsupportedMsgType
+		}
+		return fmt.Sprintf("%s %s", content.Body, mediaURL), nil
+	default:
+		return "", errUnsupportedMsgType
+	}
+}
+
+// matrixRelayNick computes the relaymsg nick for a Matrix user
+func matrixRelayNick(config *Config, userID, displayname string) string {
+	localpart, _, _ := strings.Cut(strings.TrimPrefix(userID, "@"), ":")
+	hash := sha256.Sum256([]byte(userID))
+	fallback := fmt.Sprintf("u%x", hash[:4])
+	return bridgeRelayNick(config, config.Matrix.NickSuffix, fallback, displayname, localpart)
+}
+
+// formatMessageForMatrix converts an IRC channel message into the type and
+// body of a Matrix message, or returns "" if the message shouldn't be relayed
+// (e.g., non-ACTION CTCP)
+func formatMessageForMatrix(message utils.SplitMessage) (msgType, body string) {
+	text := flattenSplitMessage(message)
+	if strings.HasPrefix(text, "\x01ACTION ") {
+		action := strings.TrimSuffix(strings.TrimPrefix(text, "\x01ACTION "), "\x01")
+		return matrix.MsgTypeEmote, ircfmt.Strip(action)
+	} else if strings.HasPrefix(text, "\x01") {
+		return "", ""
+	}
+	return matrix.MsgTypeText, ircfmt.Strip(text)
+}
+
+// MatrixRegistration generates the application service registration file
+// to install on the homeserver.
+func MatrixRegistration(config *Config) ([]byte, error) {
+	if !config.Matrix.Enabled {
+		return nil, errors.New("The Matrix bridge is not enabled")
+	}
+	return matrix.Registration(config.Matrix.appserviceConfig(), config.Matrix.SenderLocalpart, config.Matrix.URL)
+}
@@ -0,0 +1,489 @@
+// Package matrix implements the small subset of the Matrix application
+// service API needed to bridge messages: the HTTP endpoints the homeserver
+// pushes events to, and the client-server API calls used to create and
+// puppet virtual users.
+package matrix
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/ergochat/ergo/irc/logger"
+	"github.com/ergochat/ergo/irc/utils"
+)
+
+const (
+	requestTimeout      = 30 * time.Second
+	maxRateLimitRetries = 3
+	maxRetryAfter       = time.Minute
+	// how many transaction IDs to remember, to detect retransmissions
+	maxSeenTransactions = 256
+	maxTransactionSize  = 4 * 1024 * 1024
+
+	MsgTypeText   = "m.text"
+	MsgTypeEmote  = "m.emote"
+	MsgTypeNotice = "m.notice"
+)
+
+var (
+	errRateLimited = errors.New("rate limited by the homeserver")
+	// the characters permitted in user ID localparts, other than the escape character
+	localpartRegexp = regexp.MustCompile(`^[a-z0-9._\-/]+$`)
+)
+
+// Config is the configuration of the application service.
+type Config struct {
+	// base URL of the homeserver's client-server API
+	Homeserver string
+	// the homeserver's server name, i.e., the domain part of its user IDs
+	ServerName string
+	// token used to authenticate to the homeserver
+	ASToken string
+	// token the homeserver uses to authenticate to us
+	HSToken string
+	// localpart of the application service's own user
+	SenderLocalpart string
+	// prefix of the localparts of the virtual users (the exclusive namespace)
+	UserPrefix string
+}
+
+// Error is an error response from the homeserver.
+type Error struct {
+	Status  int    `json:"-"`
+	ErrCode string `json:"errcode"`
+	Message string `json:"error"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("homeserver returned HTTP status %d: %s %s", e.Status, e.ErrCode, e.Message)
+}
+
+func isErrCode(err error, errCode string) bool {
+	var mErr *Error
+	return errors.As(err, &mErr) && mErr.ErrCode == errCode
+}
+
+// Event is a room event pushed to us by the homeserver.
+type Event struct {
+	Type     string          `json:"type"`
+	EventID  string          `json:"event_id"`
+	RoomID   string          `json:"room_id"`
+	Sender   string          `json:"sender"`
+	StateKey *string         `json:"state_key"`
+	Content  json.RawMessage `json:"content"`
+}
+
+// MessageContent is the content of an m.room.message event.
+type MessageContent struct {
+	MsgType   string `json:"msgtype"`
+	Body      string `json:"body"`
+	URL       string `json:"url"`
+	RelatesTo *struct {
+		RelType   string `json:"rel_type"`
+		InReplyTo *struct {
+			EventID string `json:"event_id"`
+		} `json:"m.in_reply_to"`
+	} `json:"m.relates_to"`
+}
+
+// IsEdit returns whether the message replaces (edits) a previous message.
+func (m *MessageContent) IsEdit() bool {
+	return m.RelatesTo != nil && m.RelatesTo.RelType == "m.replace"
+}
+
+// Text returns the body of the message. If the message is a reply, the
+// quotation of the original message (the "reply fallback") is removed.
+func (m *MessageContent) Text() string {
+	body := m.Body
+	if m.RelatesTo != nil && m.RelatesTo.InReplyTo != nil && strings.HasPrefix(body, "> ") {
+		lines := strings.Split(body, "\n")
+		i := 0
+		for i < len(lines) && strings.HasPrefix(lines[i], ">") {
+			i++
+		}
+		if i < len(lines) && lines[i] == "" {
+			i++
+		}
+		body = strings.Join(lines[i:], "\n")
+	}
+	return body
+}
+
+// MemberContent is the content of an m.room.member event.
+type MemberContent struct {
+	Membership  string `json:"membership"`
+	Displayname string `json:"displayname"`
+}
+
+type puppet struct {
+	registered  bool
+	displayname string
+	rooms       utils.HashSet[string]
+}
+
+// AppService is a Matrix application service.
+type AppService struct {
+	config     Config
+	logger     *logger.Manager
+	handler    func(*Event)
+	httpClient *http.Client
+	mux        *http.ServeMux
+	// transaction IDs for our own requests; the prefix prevents collisions
+	// with IDs used before a restart
+	txnPrefix  string
+	txnCounter atomic.Uint64
+
+	stateMutex sync.Mutex
+	seenTxns   utils.HashSet[string]
+	seenOrder  []string
+	puppets    map[string]*puppet
+
+	// serializes SendMessage, so that puppet setup happens only once
+	sendMutex sync.Mutex
+}
+
+// NewAppService returns a new application service; `handler` is invoked,
+// in order, for each room event the homeserver sends us.
+func NewAppService(config Config, logger *logger.Manager, handler func(*Event)) *AppService {
+	as := &AppService{
+		config:     config,
+		logger:     logger,
+		handler:    handler,
+		httpClient: &http.Client{Timeout: requestTimeout},
+		txnPrefix:  utils.GenerateSecretToken()[:8],
+		seenTxns:   make(utils.HashSet[string]),
+		puppets:    make(map[string]*puppet),
+	}
+	as.mux = http.NewServeMux()
+	for _, prefix := range []string{"/_matrix/app/v1", ""} {
+		// the unprefixed paths are the legacy versions, still used by some homeservers
+		as.mux.HandleFunc("PUT "+prefix+"/transactions/{txnId}", as.handleTransaction)
+		as.mux.HandleFunc("GET "+prefix+"/users/{userId}", as.handleNotFound)
+		as.mux.HandleFunc("GET "+prefix+"/rooms/{alias}", as.handleNotFound)
+	}
+	as.mux.HandleFunc("POST /_matrix/app/v1/ping", as.handlePing)
+	as.mux.HandleFunc("/", as.handleUnrecognized)
+	return as
+}
+
+// ServeHTTP serves the application service API to the homeserver.
+func (as *AppService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if status, errCode := as.checkAuth(r); status != http.StatusOK {
+		writeError(w, status, errCode, "invalid homeserver token")
+		return
+	}
+	as.mux.ServeHTTP(w, r)
+}
+
+func (as *AppService) checkAuth(r *http.Request) (status int, errCode string) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		token = r.URL.Query().Get("access_token")
+	}
+	if token == "" {
+		return http.StatusUnauthorized, "M_UNAUTHORIZED"
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(as.config.HSToken)) != 1 {
+		return http.StatusForbidden, "M_FORBIDDEN"
+	}
+	return http.StatusOK, ""
+}
+
+func writeError(w http.ResponseWriter, status int, errCode, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(Error{ErrCode: errCode, Message: message})
+}
+
+func writeEmpty(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	io.WriteString(w, "{}")
+}
+
+func (as *AppService) handleTransaction(w http.ResponseWriter, r *http.Request) {
+	txnID := r.PathValue("txnId")
+	var txn struct {
+		Events []Event `json:"events"`
+	}
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxTransactionSize)).Decode(&txn); err != nil {
+		writeError(w, http.StatusBadRequest, "M_NOT_JSON", "invalid transaction")
+		return
+	}
+	// the homeserver retransmits transactions until they succeed;
+	// process each transaction only once
+	if as.markTransactionSeen(txnID) {
+		for i := range txn.Events {
+			as.handleEvent(&txn.Events[i])
+		}
+	}
+	writeEmpty(w)
+}
+
+func (as *AppService) markTransactionSeen(txnID string) (isNew bool) {
+	as.stateMutex.Lock()
+	defer as.stateMutex.Unlock()
+	if as.seenTxns.Has(txnID) {
+		return false
+	}
+	as.seenTxns.Add(txnID)
+	as.seenOrder = append(as.seenOrder, txnID)
+	if maxSeenTransactions < len(as.seenOrder) {
+		delete(as.seenTxns, as.seenOrder[0])
+		as.seenOrder = as.seenOrder[1:]
+	}
+	return true
+}
+
+func (as *AppService) handleEvent(event *Event) {
+	// accept invitations for our own user, so that room admins can add the bridge
+	if event.Type == "m.room.member" && event.StateKey != nil && *event.StateKey == as.UserID(as.config.SenderLocalpart) {
+		var member MemberContent
+		if json.Unmarshal(event.Content, &member) == nil && member.Membership == "invite" {
+			go func() {
+				if err := as.joinRoom(event.RoomID, ""); err != nil {
+					as.logger.Warning("matrix", "Couldn't accept invitation to room", event.RoomID, err.Error())
+				}
+			}()
+		}
+	}
+	as.handler(event)
+}
+
+// we never create users or rooms on demand
+func (as *AppService) handleNotFound(w http.ResponseWriter, r *http.Request) {
+	writeError(w, http.StatusNotFound, "M_NOT_FOUND", "not found")
+}
+
+func (as *AppService) handleUnrecognized(w http.ResponseWriter, r *http.Request) {
+	writeError(w, http.StatusNotFound, "M_UNRECOGNIZED", "unrecognized endpoint")
+}
+
+func (as *AppService) handlePing(w http.ResponseWriter, r *http.Request) {
+	writeEmpty(w)
+}
+
+// UserID returns the full user ID of a user on the homeserver.
+func (as *AppService) UserID(localpart string) string {
+	return fmt.Sprintf("@%s:%s", localpart, as.config.ServerName)
+}
+
+// IsOwnUser returns whether the user ID belongs to the application service,
+// i.e., it is our own user or one of our virtual users.
+func (as *AppService) IsOwnUser(userID string) bool {
+	localpart, ok := strings.CutSuffix(strings.TrimPrefix(userID, "@"), ":"+as.config.ServerName)
+	return ok && (localpart == as.config.SenderLocalpart || strings.HasPrefix(localpart, as.config.UserPrefix))
+}
+
+// PuppetLocalpart returns the localpart of the virtual user representing
+// `name`, escaping characters that are not permitted in localparts.
+func (as *AppService) PuppetLocalpart(name string) string {
+	return as.config.UserPrefix + EscapeLocalpart(name)
+}
+
+// EscapeLocalpart maps an arbitrary string to a valid user ID localpart,
+// escaping disallowed bytes (and the escape character "=") as =xx.
+func EscapeLocalpart(name string) string {
+	var buf strings.Builder
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if c != '=' && localpartRegexp.Match([]byte{c}) {
+			buf.WriteByte(c)
+		} else {
+			fmt.Fprintf(&buf, "=%02x", c)
+		}
+	}
+	return buf.String()
+}
+
+// MediaURL converts an mxc:// URI into an HTTP URL on the homeserver,
+// or returns "" if it is invalid.
+func (as *AppService) MediaURL(mxc string) string {
+	serverAndID, ok := strings.CutPrefix(mxc, "mxc://")
+	if !ok {
+		return ""
+	}
+	server, mediaID, ok := strings.Cut(serverAndID, "/")
+	if !ok || server == "" || mediaID == "" || strings.Contains(mediaID, "/") {
+		return ""
+	}
+	return fmt.Sprintf("%s/_matrix/media/v3/download/%s/%s", strings.TrimSuffix(as.config.Homeserver, "/"), url.PathEscape(server), url.PathEscape(mediaID))
+}
+
+// SendMessage sends a message to a room as a virtual user, first registering
+// the user, setting its display name, and joining it to the room as necessary.
+func (as *AppService) SendMessage(roomID, localpart, displayname, msgType, body string) error {
+	as.sendMutex.Lock()
+	defer as.sendMutex.Unlock()
+
+	as.stateMutex.Lock()
+	p := as.puppets[localpart]
+	if p == nil {
+		p = &puppet{rooms: make(utils.HashSet[string])}
+		as.puppets[localpart] = p
+	}
+	as.stateMutex.Unlock()
+
+	userID := as.UserID(localpart)
+	if !p.registered {
+		if err := as.register(localpart); err != nil {
+			return err
+		}
+		p.registered = true
+	}
+	if p.displayname != displayname {
+		content := map[string]string{"displayname": displayname}
+		endpoint := fmt.Sprintf("/_matrix/client/v3/profile/%s/displayname", url.PathEscape(userID))
+		if err := as.request(http.MethodPut, endpoint, userID, content); err != nil {
+			return err
+		}
+		p.displayname = displayname
+	}
+	if !p.rooms.Has(roomID) {
+		err := as.joinRoom(roomID, userID)
+		if isErrCode(err, "M_FORBIDDEN") {
+			// the room may be invite-only: have our own user invite the puppet
+			content := map[string]string{"user_id": userID}
+			endpoint := fmt.Sprintf("/_matrix/client/v3/rooms/%s/invite", url.PathEscape(roomID))
+			if err = as.request(http.MethodPost, endpoint, "", content); err == nil {
+				err = as.joinRoom(roomID, userID)
+			}
+		}
+		if err != nil {
+			return err
+		}
+		p.rooms.Add(roomID)
+	}
+
+	content := map[string]string{"msgtype": msgType, "body": body}
+	txnID := fmt.Sprintf("%s.%d", as.txnPrefix, as.txnCounter.Add(1))
+	endpoint := fmt.Sprintf("/_matrix/client/v3/rooms/%s/send/m.room.message/%s", url.PathEscape(roomID), txnID)
+	err := as.request(http.MethodPut, endpoint, userID, content)
+	if isErrCode(err, "M_FORBIDDEN") {
+		// the puppet may have been kicked; rejoin next time
+		p.rooms.Remove(roomID)
+	}
+	return err
+}
+
+func (as *AppService) register(localpart string) error {
+	content := map[string]string{"type": "m.login.application_service", "username": localpart}
+	err := as.request(http.MethodPost, "/_matrix/client/v3/register", "", content)
+	if isErrCode(err, "M_USER_IN_USE") {
+		return nil // registered previously
+	}
+	return err
+}
+
+// joinRoom joins a room, as the virtual user `userID` or as our own user if it is empty
+func (as *AppService) joinRoom(roomID, userID string) error {
+	endpoint := fmt.Sprintf("/_matrix/client/v3/rooms/%s/join", url.PathEscape(roomID))
+	return as.request(http.MethodPost, endpoint, userID, struct{}{})
+}
+
+// request makes a client-server API request, masquerading as the virtual
+// user `userID` if it is nonempty; it retries if the homeserver rate limits us.
+func (as *AppService) request(method, endpoint, userID string, content any) (err error) {
+	body, err := json.Marshal(content)
+	if err != nil {
+		return
+	}
+	endpoint = strings.TrimSuffix(as.config.Homeserver, "/") + endpoint
+	if userID != "" {
+		endpoint += "?user_id=" + url.QueryEscape(userID)
+	}
+
+	for i := 0; i < maxRateLimitRetries; i++ {
+		var retryAfter time.Duration
+		retryAfter, err = as.doRequest(method, endpoint, body)
+		if err != errRateLimited {
+			return
+		}
+		time.Sleep(retryAfter)
+	}
+	return
+}
+
+func (as *AppService) doRequest(method, endpoint string, body []byte) (retryAfter time.Duration, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return
+	}
+	req.Header.Set("Authorization", "Bearer "+as.config.ASToken)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := as.httpClient.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 65536))
+	if 200 <= resp.StatusCode && resp.StatusCode < 300 {
+		return 0, nil
+	}
+
+	var mErr struct {
+		Error
+		RetryAfterMs int64 `json:"retry_after_ms"`
+	}
+	json.Unmarshal(respBody, &mErr)
+	if resp.StatusCode == http.StatusTooManyRequests {
+		retryAfter = time.Duration(mErr.RetryAfterMs) * time.Millisecond
+		return min(max(retryAfter, 100*time.Millisecond), maxRetryAfter), errRateLimited
+	}
+	mErr.Status = resp.StatusCode
+	return 0, &mErr.Error
+}
+
+type registrationNamespace struct {
+	Exclusive bool   `yaml:"exclusive"`
+	Regex     string `yaml:"regex"`
+}
+
+type registration struct {
+	ID              string `yaml:"id"`
+	URL             string `yaml:"url"`
+	ASToken         string `yaml:"as_token"`
+	HSToken         string `yaml:"hs_token"`
+	SenderLocalpart string `yaml:"sender_localpart"`
+	RateLimited     bool   `yaml:"rate_limited"`
+	Namespaces      struct {
+		Users   []registrationNamespace `yaml:"users"`
+		Aliases []registrationNamespace `yaml:"aliases"`
+		Rooms   []registrationNamespace `yaml:"rooms"`
+	} `yaml:"namespaces"`
+}
+
+// Registration generates the registration file to install on the homeserver;
+// `url` is the URL where the homeserver can reach the application service.
+func Registration(config Config, id, url string) ([]byte, error) {
+	reg := registration{
+		ID:              id,
+		URL:             url,
+		ASToken:         config.ASToken,
+		HSToken:         config.HSToken,
+		SenderLocalpart: config.SenderLocalpart,
+	}
+	reg.Namespaces.Users = []registrationNamespace{{
+		Exclusive: true,
+		Regex:     fmt.Sprintf("@%s.*:%s", regexp.QuoteMeta(config.UserPrefix), regexp.QuoteMeta(config.ServerName)),
+	}}
+	reg.Namespaces.Aliases = []registrationNamespace{}
+	reg.Namespaces.Rooms = []registrationNamespace{}
+	return yaml.Marshal(reg)
+}
@@ -0,0 +1,218 @@
+package matrix
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/ergochat/ergo/irc/logger"
+)
+
+func newTestLogger(t *testing.T) *logger.Manager {
+	manager, err := logger.NewManager(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return manager
+}
+
+var testConfig = Config{
+	ServerName:      "example.com",
+	ASToken:         "as-secret",
+	HSToken:         "hs-secret",
+	SenderLocalpart: "ergo",
+	UserPrefix:      "irc_",
+}
+
+func TestTransactions(t *testing.T) {
+	var events []*Event
+	as := NewAppService(testConfig, newTestLogger(t), func(e *Event) { events = append(events, e) })
+
+	do := func(method, path, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		recorder := httptest.NewRecorder()
+		as.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	txn := `{"events": [{"type": "m.room.message", "room_id": "!r:example.com", "sender": "@alice:example.com", "content": {"msgtype": "m.text", "body": "hi"}}]}`
+	if resp := do("PUT", "/_matrix/app/v1/transactions/1", "", txn); resp.Code != http.StatusUnauthorized {
+		t.Errorf("missing token: expected 401, got %d", resp.Code)
+	}
+	if resp := do("PUT", "/_matrix/app/v1/transactions/1", "wrong", txn); resp.Code != http.StatusForbidden {
+		t.Errorf("wrong token: expected 403, got %d", resp.Code)
+	}
+	if len(events) != 0 {
+		t.Fatalf("unauthenticated events were processed")
+	}
+	resp := do("PUT", "/_matrix/app/v1/transactions/1", "hs-secret", txn)
+	if resp.Code != http.StatusOK || resp.Body.String() != "{}" {
+		t.Errorf("unexpected response %d %s", resp.Code, resp.Body.String())
+	}
+	// a retransmission is acknowledged but not processed again
+	if resp := do("PUT", "/_matrix/app/v1/transactions/1", "hs-secret", txn); resp.Code != http.StatusOK {
+		t.Errorf("retransmission: unexpected status %d", resp.Code)
+	}
+	// the legacy unprefixed path, authenticated with a query parameter
+	if resp := do("PUT", "/transactions/2?access_token=hs-secret", "", txn); resp.Code != http.StatusOK {
+		t.Errorf("legacy path: unexpected status %d", resp.Code)
+	}
+	if len(events) != 2 || events[0].Sender != "@alice:example.com" {
+		t.Fatalf("unexpected events %#v", events)
+	}
+	var content MessageContent
+	if err := json.Unmarshal(events[0].Content, &content); err != nil || content.Text() != "hi" {
+		t.Errorf("unexpected content %#v", content)
+	}
+
+	if resp := do("PUT", "/_matrix/app/v1/transactions/3", "hs-secret", "garbage"); resp.Code != http.StatusBadRequest {
+		t.Errorf("invalid JSON: expected 400, got %d", resp.Code)
+	}
+	if resp := do("GET", "/_matrix/app/v1/users/@irc_bob:example.com", "hs-secret", ""); resp.Code != http.StatusNotFound {
+		t.Errorf("user query: expected 404, got %d", resp.Code)
+	}
+	if resp := do("GET", "/_matrix/app/v1/unknown", "hs-secret", ""); resp.Code != http.StatusNotFound || !strings.Contains(resp.Body.String(), "M_UNRECOGNIZED") {
+		t.Errorf("unknown endpoint: unexpected response %d %s", resp.Code, resp.Body.String())
+	}
+}
+
+func TestMessageText(t *testing.T) {
+	var content MessageContent
+	json.Unmarshal([]byte(`{
+		"msgtype": "m.text",
+		"body": "> <@bob:example.com> original\n> more\n\nmy reply",
+		"m.relates_to": {"m.in_reply_to": {"event_id": "$abc"}}
+	}`), &content)
+	if content.Text() != "my reply" {
+		t.Errorf("reply fallback was not removed: %#v", content.Text())
+	}
+	content = MessageContent{Body: "> quoting\n\nsomething"}
+	if content.Text() != content.Body {
+		t.Errorf("quotation was removed from a non-reply")
+	}
+}
+
+func TestNames(t *testing.T) {
+	as := NewAppService(testConfig, newTestLogger(t), func(*Event) {})
+	if as.PuppetLocalpart("a[b]=c") != "irc_a=5bb=5d=3dc" {
+		t.Errorf("unexpected localpart %s", as.PuppetLocalpart("a[b]=c"))
+	}
+	for userID, own := range map[string]bool{
+		"@irc_bob:example.com":   true,
+		"@ergo:example.com":      true,
+		"@alice:example.com":     false,
+		"@irc_bob:example.org":   false,
+		"@irc_bob:example.com.x": false,
+	} {
+		if as.IsOwnUser(userID) != own {
+			t.Errorf("%s: expected IsOwnUser %v", userID, own)
+		}
+	}
+
+	as.config.Homeserver = "https://matrix.example.com/"
+	if url := as.MediaURL("mxc://example.com/abc"); url != "https://matrix.example.com/_matrix/media/v3/download/example.com/abc" {
+		t.Errorf("unexpected media URL %s", url)
+	}
+	if as.MediaURL("https://example.com/abc") != "" || as.MediaURL("mxc://example.com/a/b") != "" {
+		t.Errorf("invalid mxc URIs should be rejected")
+	}
+}
+
+func TestSendMessage(t *testing.T) {
+	var mutex sync.Mutex
+	var requests []string
+	var sent map[string]string
+	joins := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if r.Header.Get("Authorization") != "Bearer as-secret" {
+			writeError(w, http.StatusUnauthorized, "M_UNKNOWN_TOKEN", "")
+			return
+		}
+		requests = append(requests, r.Method+" "+r.URL.EscapedPath()+" "+r.URL.Query().Get("user_id"))
+		switch {
+		case r.URL.Path == "/_matrix/client/v3/register":
+			writeError(w, http.StatusBadRequest, "M_USER_IN_USE", "")
+		case strings.HasSuffix(r.URL.Path, "/join") && r.URL.Query().Get("user_id") != "":
+			joins++
+			if joins == 1 {
+				writeError(w, http.StatusForbidden, "M_FORBIDDEN", "not invited")
+				return
+			}
+			writeEmpty(w)
+		case strings.Contains(r.URL.Path, "/send/"):
+			body, _ := io.ReadAll(r.Body)
+			json.Unmarshal(body, &sent)
+			writeEmpty(w)
+		default:
+			writeEmpty(w)
+		}
+	}))
+	defer server.Close()
+
+	config := testConfig
+	config.Homeserver = server.URL
+	as := NewAppService(config, newTestLogger(t), func(*Event) {})
+	if err := as.SendMessage("!r:example.com", "irc_bob", "bob", MsgTypeText, "hello"); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"POST /_matrix/client/v3/register ",
+		"PUT /_matrix/client/v3/profile/@irc_bob:example.com/displayname @irc_bob:example.com",
+		"POST /_matrix/client/v3/rooms/%21r:example.com/join @irc_bob:example.com",
+		"POST /_matrix/client/v3/rooms/%21r:example.com/invite ",
+		"POST /_matrix/client/v3/rooms/%21r:example.com/join @irc_bob:example.com",
+	}
+	if len(requests) != len(expected)+1 {
+		t.Fatalf("unexpected requests %#v", requests)
+	}
+	for i, request := range expected {
+		if requests[i] != request {
+			t.Errorf("request %d: expected %#v, got %#v", i, request, requests[i])
+		}
+	}
+	if !regexp.MustCompile(`^PUT /_matrix/client/v3/rooms/%21r:example.com/send/m.room.message/[^/]+ @irc_bob:example.com$`).MatchString(requests[len(expected)]) {
+		t.Errorf("unexpected send request %s", requests[len(expected)])
+	}
+	if sent["msgtype"] != MsgTypeText || sent["body"] != "hello" {
+		t.Errorf("unexpected message %#v", sent)
+	}
+
+	// the puppet is now set up, so only the message is sent
+	requests = nil
+	if err := as.SendMessage("!r:example.com", "irc_bob", "bob", MsgTypeEmote, "waves"); err != nil {
+		t.Fatal(err)
+	}
+	if len(requests) != 1 || sent["msgtype"] != MsgTypeEmote {
+		t.Errorf("unexpected requests %#v %#v", requests, sent)
+	}
+}
+
+func TestRegistration(t *testing.T) {
+	regYAML, err := Registration(testConfig, "ergo", "http://localhost:8009")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reg registration
+	if err := yaml.Unmarshal(regYAML, &reg); err != nil {
+		t.Fatal(err)
+	}
+	if reg.ASToken != "as-secret" || reg.HSToken != "hs-secret" || reg.URL != "http://localhost:8009" || len(reg.Namespaces.Users) != 1 {
+		t.Fatalf("unexpected registration %#v", reg)
+	}
+	userRegexp := regexp.MustCompile("^" + reg.Namespaces.Users[0].Regex + "$")
+	if !userRegexp.MatchString("@irc_bob:example.com") || userRegexp.MatchString("@alice:example.com") || userRegexp.MatchString("@irc_bob:exampleXcom") {
+		t.Errorf("unexpected user namespace %s", reg.Namespaces.Users[0].Regex)
+	}
+}
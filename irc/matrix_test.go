@@ -0,0 +1,65 @@
+package irc
+
+import (
+	"testing"
+
+	"github.com/ergochat/ergo/irc/matrix"
+	"github.com/ergochat/ergo/irc/utils"
+)
+
+func TestMatrixRelayNick(t *testing.T) {
+	config := &Config{}
+	config.Server.Relaymsg.Separators = "/"
+	config.Limits.NickLen = 16
+	config.Matrix.NickSuffix = "m"
+
+	cases := []struct {
+		userID      string
+		displayname string
+		expected    string
+	}{
+		{"@alice:example.com", "Alice", "Alice/m"},
+		{"@alice:example.com", "", "alice/m"},
+		{"@bob:example.com", "Bob (away)", "Bob_(away)/m"},
+		{"@a/b:example.com", "", "ab/m"},
+		{"@_:example.com", "", "ue68823b6/m"},
+	}
+	for _, c := range cases {
+		if nick := matrixRelayNick(config, c.userID, c.displayname); nick != c.expected {
+			t.Errorf("%s %#v: expected %#v, got %#v", c.userID, c.displayname, c.expected, nick)
+		}
+	}
+}
+
+func TestFormatMessageForMatrix(t *testing.T) {
+	cases := map[string][2]string{
+		"\x02bold\x02 text":    {matrix.MsgTypeText, "bold text"},
+		"\x01ACTION waves\x01": {matrix.MsgTypeEmote, "waves"},
+		"\x01VERSION\x01":      {"", ""},
+	}
+	for text, expected := range cases {
+		if msgType, body := formatMessageForMatrix(utils.MakeMessage(text)); msgType != expected[0] || body != expected[1] {
+			t.Errorf("%#v: expected %#v, got %#v %#v", text, expected, msgType, body)
+		}
+	}
+}
+
+func TestMatrixMessageText(t *testing.T) {
+	appservice := matrix.NewAppService(matrix.Config{Homeserver: "https://matrix.example.com"}, nil, func(*matrix.Event) {})
+	cases := []struct {
+		content  matrix.MessageContent
+		expected string
+	}{
+		{matrix.MessageContent{MsgType: matrix.MsgTypeText, Body: "hi"}, "hi"},
+		{matrix.MessageContent{MsgType: matrix.MsgTypeEmote, Body: "waves\nhello"}, "\x01ACTION waves hello\x01"},
+		{matrix.MessageContent{MsgType: "m.image", Body: "cat.png", URL: "mxc://example.com/abc"}, "cat.png https://matrix.example.com/_matrix/media/v3/download/example.com/abc"},
+		{matrix.MessageContent{MsgType: "m.image", Body: "cat.png"}, ""},
+		{matrix.MessageContent{MsgType: "m.location", Body: "somewhere"}, ""},
+	}
+	for _, c := range cases {
+		text, err := matrixMessageText(appservice, &c.content)
+		if (err != nil) != (c.expected == "") || text != c.expected {
+			t.Errorf("%#v: expected %#v, got %#v (%v)", c.content, c.expected, text, err)
+		}
+	}
+}
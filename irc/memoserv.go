@@ -0,0 +1,314 @@
+package irc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tidwall/buntdb"
+)
+
+const (
+	memoservHelp = `MemoServ lets you leave messages (memos) for other registered users,
+which they will receive the next time they log in.`
+
+	defaultMaxMemos          = 20
+	defaultMaxMemosPerSender = 5
+)
+
+var (
+	errMemoBoxFull     = errors.New("That user's memo box is full")
+	errMemoSenderLimit = errors.New("You have already left that user the maximum number of memos")
+	errNoSuchMemo      = errors.New("No such memo")
+)
+
+func memoservEnabled(config *Config) bool {
+	return config.Accounts.AuthenticationEnabled && config.Accounts.Memos.Enabled
+}
+
+var (
+	memoservCommands = map[string]*serviceCommand{
+		"send": {
+			handler: msSendHandler,
+			help: `Syntax: $bSEND <account> <message>$b
+
+SEND leaves a memo for the given account. If any of the account's clients
+are online, they will be notified immediately; otherwise, they will be
+notified the next time they log in.`,
+			helpShort:         `$bSEND$b leaves a memo for another user.`,
+			authRequired:      true,
+			enabled:           memoservEnabled,
+			minParams:         2,
+			maxParams:         2,
+			unsplitFinalParam: true,
+		},
+		"list": {
+			handler: msListHandler,
+			help: `Syntax: $bLIST$b
+
+LIST lists the memos you have received.`,
+			helpShort:    `$bLIST$b lists your memos.`,
+			authRequired: true,
+			enabled:      memoservEnabled,
+		},
+		"read": {
+			handler: msReadHandler,
+			help: `Syntax: $bREAD <number|new>$b
+
+READ displays the memo with the given number (as shown by $bLIST$b), or all
+of your unread memos, and marks them as read.`,
+			helpShort:    `$bREAD$b displays your memos.`,
+			authRequired: true,
+			enabled:      memoservEnabled,
+			minParams:    1,
+		},
+		"del": {
+			handler: msDelHandler,
+			help: `Syntax: $bDEL <number|all>$b
+
+DEL deletes the memo with the given number (as shown by $bLIST$b), or all
+of your memos.`,
+			helpShort:    `$bDEL$b deletes your memos.`,
+			authRequired: true,
+			enabled:      memoservEnabled,
+			minParams:    1,
+		},
+	}
+)
+
+// Memo is a message left by one account for another.
+type Memo struct {
+	Time   time.Time
+	Sender string // display name of the sending account
+	Text   string
+	Read   bool
+}
+
+// LoadMemos returns the memos received by `account`, oldest first.
+func (am *AccountManager) LoadMemos(account string) (memos []Memo, err error) {
+	cfaccount, err := CasefoldName(account)
+	if err != nil {
+		return nil, errAccountDoesNotExist
+	}
+	err = am.server.store.View(func(tx *buntdb.Tx) error {
+		memos, err = loadMemos(tx, cfaccount)
+		return err
+	})
+	return
+}
+
+func loadMemos(tx *buntdb.Tx, cfaccount string) (memos []Memo, err error) {
+	rawMemos, err := tx.Get(fmt.Sprintf(keyAccountMemos, cfaccount))
+	if err == buntdb.ErrNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal([]byte(rawMemos), &memos)
+	return
+}
+
+// ModifyMemos atomically loads, modifies (via munger), and stores
+// the memos received by `account`; it returns the new list.
+func (am *AccountManager) ModifyMemos(account string, munger func([]Memo) ([]Memo, error)) (memos []Memo, err error) {
+	cfaccount, err := CasefoldName(account)
+	if err != nil {
+		return nil, errAccountDoesNotExist
+	}
+	memosKey := fmt.Sprintf(keyAccountMemos, cfaccount)
+	err = am.server.store.Update(func(tx *buntdb.Tx) error {
+		memos, err = loadMemos(tx, cfaccount)
+		if err != nil {
+			return err
+		}
+		memos, err = munger(memos)
+		if err != nil {
+			return err
+		}
+		if len(memos) == 0 {
+			tx.Delete(memosKey)
+			return nil
+		}
+		newRawMemos, err := json.Marshal(memos)
+		if err != nil {
+			return err
+		}
+		_, _, err = tx.Set(memosKey, string(newRawMemos), nil)
+		return err
+	})
+	return
+}
+
+func countUnreadMemos(memos []Memo) (unread int) {
+	for _, memo := range memos {
+		if !memo.Read {
+			unread++
+		}
+	}
+	return
+}
+
+// notifyUnreadMemos tells a client that has just logged in about its unread memos
+func notifyUnreadMemos(client *Client, rb *ResponseBuffer) {
+	if !memoservEnabled(client.server.Config()) {
+		return
+	}
+	memos, err := client.server.accounts.LoadMemos(client.Account())
+	if err != nil {
+		client.server.logger.Error("internal", "couldn't load memos", client.Account(), err.Error())
+		return
+	}
+	if unread := countUnreadMemos(memos); unread != 0 {
+		memoservService.Notice(rb, fmt.Sprintf(client.t("You have %d unread memo(s). To read them, type /MSG MemoServ READ NEW"), unread))
+	}
+}
+
+func msSendHandler(service *ircService, server *Server, client *Client, command string, params []string, rb *ResponseBuffer) {
+	account, err := server.accounts.LoadAccount(params[0])
+	if err != nil || !account.Verified {
+		service.Notice(rb, client.t("No such account"))
+		return
+	}
+	target := account.Name
+
+	memoConfig := server.Config().Accounts.Memos
+	memo := Memo{
+		Time:   time.Now().UTC(),
+		Sender: client.AccountName(),
+		Text:   params[1],
+	}
+	memos, err := server.accounts.ModifyMemos(target, func(memos []Memo) ([]Memo, error) {
+		return appendMemo(memos, memo, client.Account(), &memoConfig)
+	})
+	if err == errMemoBoxFull || err == errMemoSenderLimit {
+		service.Notice(rb, client.t(err.Error()))
+		return
+	} else if err != nil {
+		server.logger.Error("internal", "couldn't store memo", target, err.Error())
+		service.Notice(rb, client.t("An error occurred"))
+		return
+	}
+	service.Notice(rb, fmt.Sprintf(client.t("Memo sent to %s"), target))
+
+	for _, recipient := range server.accounts.AccountToClients(target) {
+		recipient.Send(nil, service.prefix, "NOTICE", recipient.Nick(), fmt.Sprintf(recipient.t("You have a new memo from %[1]s. To read it, type /MSG MemoServ READ %[2]d"), memo.Sender, len(memos)))
+	}
+}
+
+// appendMemo adds a memo from the account `sender` (casefolded) to a memo box,
+// enforcing the limits on its size and on each sender's share of it (so that
+// a single sender can't fill up someone else's memo box)
+func appendMemo(memos []Memo, memo Memo, sender string, config *MemoConfig) ([]Memo, error) {
+	if len(memos) >= config.MaxMemos {
+		return nil, errMemoBoxFull
+	}
+	fromSender := 0
+	for _, existing := range memos {
+		if cfsender, err := CasefoldName(existing.Sender); err == nil && cfsender == sender {
+			fromSender++
+		}
+	}
+	if fromSender >= config.MaxMemosPerSender {
+		return nil, errMemoSenderLimit
+	}
+	return append(memos, memo), nil
+}
+
+func msListHandler(service *ircService, server *Server, client *Client, command string, params []string, rb *ResponseBuffer) {
+	memos, err := server.accounts.LoadMemos(client.Account())
+	if err != nil {
+		server.logger.Error("internal", "couldn't load memos", client.Account(), err.Error())
+		service.Notice(rb, client.t("An error occurred"))
+		return
+	}
+	if len(memos) == 0 {
+		service.Notice(rb, client.t("You have no memos"))
+		return
+	}
+	service.Notice(rb, fmt.Sprintf(client.t("You have %[1]d memo(s), %[2]d unread:"), len(memos), countUnreadMemos(memos)))
+	for i, memo := range memos {
+		status := ""
+		if !memo.Read {
+			status = client.t(" (unread)")
+		}
+		service.Notice(rb, fmt.Sprintf(client.t("%[1]d. From %[2]s at %[3]s%[4]s"), i+1, memo.Sender, memo.Time.Format(time.RFC1123), status))
+	}
+}
+
+// parseMemoNumber parses a 1-based memo number, as displayed by LIST
+func parseMemoNumber(param string, memos []Memo) (index int, err error) {
+	number, err := strconv.Atoi(param)
+	if err != nil || number < 1 || number > len(memos) {
+		return 0, errNoSuchMemo
+	}
+	return number - 1, nil
+}
+
+func msReadHandler(service *ircService, server *Server, client *Client, command string, params []string, rb *ResponseBuffer) {
+	readAll := strings.ToLower(params[0]) == "new"
+	var toRead []int
+	memos, err := server.accounts.ModifyMemos(client.Account(), func(memos []Memo) ([]Memo, error) {
+		toRead = nil
+		if readAll {
+			for i := range memos {
+				if !memos[i].Read {
+					toRead = append(toRead, i)
+				}
+			}
+		} else {
+			index, err := parseMemoNumber(params[0], memos)
+			if err != nil {
+				return nil, err
+			}
+			toRead = append(toRead, index)
+		}
+		for _, index := range toRead {
+			memos[index].Read = true
+		}
+		return memos, nil
+	})
+	if err == errNoSuchMemo {
+		service.Notice(rb, client.t(err.Error()))
+		return
+	} else if err != nil {
+		server.logger.Error("internal", "couldn't load memos", client.Account(), err.Error())
+		service.Notice(rb, client.t("An error occurred"))
+		return
+	}
+
+	if len(toRead) == 0 {
+		service.Notice(rb, client.t("You have no unread memos"))
+	}
+	for _, index := range toRead {
+		memo := memos[index]
+		service.Notice(rb, fmt.Sprintf(client.t("Memo %[1]d from %[2]s at %[3]s:"), index+1, memo.Sender, memo.Time.Format(time.RFC1123)))
+		service.Notice(rb, memo.Text)
+	}
+}
+
+func msDelHandler(service *ircService, server *Server, client *Client, command string, params []string, rb *ResponseBuffer) {
+	deleteAll := strings.ToLower(params[0]) == "all"
+	_, err := server.accounts.ModifyMemos(client.Account(), func(memos []Memo) ([]Memo, error) {
+		if deleteAll {
+			return nil, nil
+		}
+		index, err := parseMemoNumber(params[0], memos)
+		if err != nil {
+			return nil, err
+		}
+		return append(memos[:index], memos[index+1:]...), nil
+	})
+	if err == errNoSuchMemo {
+		service.Notice(rb, client.t(err.Error()))
+	} else if err != nil {
+		server.logger.Error("internal", "couldn't delete memos", client.Account(), err.Error())
+		service.Notice(rb, client.t("An error occurred"))
+	} else if deleteAll {
+		service.Notice(rb, client.t("Deleted all of your memos"))
+	} else {
+		service.Notice(rb, client.t("Memo deleted"))
+	}
+}
@@ -0,0 +1,102 @@
+package irc
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/tidwall/buntdb"
+)
+
+func TestAppendMemo(t *testing.T) {
+	config := MemoConfig{MaxMemos: 4, MaxMemosPerSender: 2}
+	var memos []Memo
+	var err error
+	for _, sender := range []string{"Alice", "Bob", "alice"} {
+		memos, err = appendMemo(memos, Memo{Sender: sender}, strings.ToLower(sender), &config)
+		if err != nil {
+			t.Fatalf("unexpected error adding memo from %s: %v", sender, err)
+		}
+	}
+	if len(memos) != 3 {
+		t.Fatalf("expected 3 memos, got %d", len(memos))
+	}
+	// alice has used up her share of the memo box
+	if _, err := appendMemo(memos, Memo{Sender: "Alice"}, "alice", &config); err != errMemoSenderLimit {
+		t.Errorf("expected errMemoSenderLimit, got %v", err)
+	}
+	memos, err = appendMemo(memos, Memo{Sender: "Carol"}, "carol", &config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := appendMemo(memos, Memo{Sender: "Dave"}, "dave", &config); err != errMemoBoxFull {
+		t.Errorf("expected errMemoBoxFull, got %v", err)
+	}
+}
+
+func TestParseMemoNumber(t *testing.T) {
+	memos := make([]Memo, 3)
+	if index, err := parseMemoNumber("3", memos); err != nil || index != 2 {
+		t.Errorf("unexpected result %d %v", index, err)
+	}
+	for _, param := range []string{"0", "4", "-1", "new", ""} {
+		if _, err := parseMemoNumber(param, memos); err != errNoSuchMemo {
+			t.Errorf("%q should not be a valid memo number", param)
+		}
+	}
+}
+
+func TestMemoServHandlers(t *testing.T) {
+	server, client, session := newHandlerTestClient(t)
+	store, err := buntdb.Open(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	server.store = store
+	server.accounts.server = server
+	client.account, client.accountName = "alice", "Alice"
+
+	_, err = server.accounts.ModifyMemos("alice", func(memos []Memo) ([]Memo, error) {
+		for _, sender := range []string{"Bob", "Carol"} {
+			memos = append(memos, Memo{Sender: sender, Text: fmt.Sprintf("hi from %s", sender)})
+		}
+		return memos, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	run := func(handler func(*ircService, *Server, *Client, string, []string, *ResponseBuffer), params ...string) (notices []string) {
+		rb := NewResponseBuffer(session)
+		handler(memoservService, server, client, "", params, rb)
+		for _, message := range rb.messages {
+			notices = append(notices, message.Params[1])
+		}
+		return
+	}
+
+	if notices := run(msListHandler); len(notices) != 3 || notices[0] != "You have 2 memo(s), 2 unread:" {
+		t.Errorf("unexpected LIST output: %v", notices)
+	}
+	if notices := run(msReadHandler, "2"); len(notices) != 2 || notices[1] != "hi from Carol" {
+		t.Errorf("unexpected READ output: %v", notices)
+	}
+	if notices := run(msReadHandler, "new"); len(notices) != 2 || notices[1] != "hi from Bob" {
+		t.Errorf("unexpected READ NEW output: %v", notices)
+	}
+	if notices := run(msReadHandler, "new"); len(notices) != 1 || notices[0] != "You have no unread memos" {
+		t.Errorf("unexpected READ NEW output: %v", notices)
+	}
+	if notices := run(msDelHandler, "3"); len(notices) != 1 || notices[0] != errNoSuchMemo.Error() {
+		t.Errorf("unexpected DEL output: %v", notices)
+	}
+	run(msDelHandler, "1")
+	if memos, err := server.accounts.LoadMemos("alice"); err != nil || len(memos) != 1 || memos[0].Sender != "Carol" {
+		t.Errorf("unexpected memos after DEL: %v %v", memos, err)
+	}
+	run(msDelHandler, "all")
+	if notices := run(msListHandler); len(notices) != 1 || notices[0] != "You have no memos" {
+		t.Errorf("unexpected LIST output after DEL ALL: %v", notices)
+	}
+}
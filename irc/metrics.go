@@ -0,0 +1,87 @@
+package irc
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+)
+
+// metrics in the Prometheus text exposition format, served by debug.metrics-listener
+
+func (server *Server) setupMetricsListener(config *Config) {
+	metricsListener := config.Debug.MetricsListener
+	if server.metricsServer != nil {
+		if metricsListener == "" || (metricsListener != server.metricsServer.Addr) {
+			server.logger.Info("server", "Stopping metrics listener", server.metricsServer.Addr)
+			server.metricsServer.Close()
+			server.metricsServer = nil
+		}
+	}
+	if metricsListener != "" && server.metricsServer == nil {
+		mux := http.NewServeMux()
+		mux.HandleFunc("/metrics", server.serveMetrics)
+		ms := http.Server{
+			Addr:    metricsListener,
+			Handler: mux,
+		}
+		go func() {
+			if err := ms.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				server.logger.Error("server", "metrics listener failed", err.Error())
+			}
+		}()
+		server.metricsServer = &ms
+		server.logger.Info("server", "Started metrics listener", server.metricsServer.Addr)
+	}
+}
+
+func (server *Server) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	buf := bufio.NewWriter(w)
+	defer buf.Flush()
+	server.writeMetrics(buf)
+}
+
+func (server *Server) writeMetrics(buf *bufio.Writer) {
+	metric := func(name, metricType, help string) {
+		fmt.Fprintf(buf, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+	}
+	labeled := func(name, label string, values map[string]uint64) {
+		keys := make([]string, 0, len(values))
+		for key := range values {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			fmt.Fprintf(buf, "%s{%s=%s} %d\n", name, label, strconv.Quote(key), values[key])
+		}
+	}
+
+	stats := server.stats.GetValues()
+	metric("ergo_clients", "gauge", "Number of registered clients.")
+	fmt.Fprintf(buf, "ergo_clients %d\n", stats.Total)
+	metric("ergo_clients_unregistered", "gauge", "Number of connections that have not completed registration.")
+	fmt.Fprintf(buf, "ergo_clients_unregistered %d\n", stats.Unknown)
+	metric("ergo_clients_max", "gauge", "High-water mark of registered clients.")
+	fmt.Fprintf(buf, "ergo_clients_max %d\n", stats.Max)
+	metric("ergo_operators", "gauge", "Number of clients with operator status.")
+	fmt.Fprintf(buf, "ergo_operators %d\n", stats.Operators)
+	metric("ergo_channels", "gauge", "Number of channels.")
+	fmt.Fprintf(buf, "ergo_channels %d\n", server.channels.Len())
+
+	metric("ergo_commands_total", "counter", "Number of commands processed, by command.")
+	labeled("ergo_commands_total", "command", server.stats.GetCommandCounts())
+
+	sentBytes, receivedBytes := server.stats.GetTraffic()
+	metric("ergo_sent_bytes_total", "counter", "Bytes sent to clients.")
+	fmt.Fprintf(buf, "ergo_sent_bytes_total %d\n", sentBytes)
+	metric("ergo_received_bytes_total", "counter", "Bytes received from clients.")
+	fmt.Fprintf(buf, "ergo_received_bytes_total %d\n", receivedBytes)
+
+	metric("ergo_registration_failures_total", "counter", "Connections rejected or disconnected before completing registration.")
+	fmt.Fprintf(buf, "ergo_registration_failures_total %d\n", server.stats.GetRegistrationFailures())
+
+	metric("ergo_accepted_connections_total", "counter", "Connections accepted, by listener.")
+	labeled("ergo_accepted_connections_total", "listener", server.stats.GetAcceptCounts())
+}
@@ -39,7 +39,7 @@ func performNickChange(server *Server, client *Client, target *Client, session *
 		if !isSanick {
 			rb.Add(nil, server.name, ERR_NICKNAMEINUSE, details.nick, utils.SafeErrorParam(nickname), client.t("Nickname is already in use"))
 		} else {
-			rb.Add(nil, server.name, "FAIL", "SANICK", "NICKNAME_IN_USE", utils.SafeErrorParam(nickname), client.t("Nickname is already in use"))
+			rb.Fail("SANICK", "NICKNAME_IN_USE", client.t("Nickname is already in use"), utils.SafeErrorParam(nickname))
 		}
 	} else if err == errNicknameReserved {
 		if !isSanick {
@@ -48,15 +48,15 @@ func performNickChange(server *Server, client *Client, target *Client, session *
 			if !client.registered {
 				rb.Add(nil, server.name, ERR_NICKNAMEINUSE, details.nick, utils.SafeErrorParam(nickname), client.t("Nickname is reserved by a different account"))
 			}
-			rb.Add(nil, server.name, "FAIL", "NICK", "NICKNAME_RESERVED", utils.SafeErrorParam(nickname), client.t("Nickname is reserved by a different account"))
+			rb.Fail("NICK", "NICKNAME_RESERVED", client.t("Nickname is reserved by a different account"), utils.SafeErrorParam(nickname))
 		} else {
-			rb.Add(nil, server.name, "FAIL", "SANICK", "NICKNAME_RESERVED", utils.SafeErrorParam(nickname), client.t("Nickname is reserved by a different account"))
+			rb.Fail("SANICK", "NICKNAME_RESERVED", client.t("Nickname is reserved by a different account"), utils.SafeErrorParam(nickname))
 		}
 	} else if err == errNicknameInvalid {
 		if !isSanick {
 			rb.Add(nil, server.name, ERR_ERRONEUSNICKNAME, details.nick, utils.SafeErrorParam(nickname), client.t("Erroneous nickname"))
 		} else {
-			rb.Add(nil, server.name, "FAIL", "SANICK", "NICKNAME_INVALID", utils.SafeErrorParam(nickname), client.t("Erroneous nickname"))
+			rb.Fail("SANICK", "NICKNAME_INVALID", client.t("Erroneous nickname"), utils.SafeErrorParam(nickname))
 		}
 	} else if err == errNickAccountMismatch {
 		// this used to use ERR_NICKNAMEINUSE, but it displayed poorly in some clients;
@@ -64,26 +64,26 @@ func performNickChange(server *Server, client *Client, target *Client, session *
 		if !isSanick {
 			rb.Add(nil, server.name, ERR_UNKNOWNERROR, details.nick, "NICK", client.t("You must use your account name as your nickname"))
 		} else {
-			rb.Add(nil, server.name, "FAIL", "SANICK", "UNKNOWN_ERROR", utils.SafeErrorParam(nickname), client.t("This user's nickname and account name need to be equal"))
+			rb.Fail("SANICK", "UNKNOWN_ERROR", client.t("This user's nickname and account name need to be equal"), utils.SafeErrorParam(nickname))
 		}
 	} else if err == errNickMissing {
 		if !isSanick {
 			rb.Add(nil, server.name, ERR_NONICKNAMEGIVEN, details.nick, client.t("No nickname given"))
 		} else {
-			rb.Add(nil, server.name, "FAIL", "SANICK", "NICKNAME_INVALID", utils.SafeErrorParam(nickname), client.t("No nickname given"))
+			rb.Fail("SANICK", "NICKNAME_INVALID", client.t("No nickname given"), utils.SafeErrorParam(nickname))
 		}
 	} else if err == errNoop {
 		if !isSanick {
 			// no message
 		} else {
-			rb.Add(nil, server.name, "NOTE", "SANICK", "NOOP", utils.SafeErrorParam(nickname), client.t("Client already had the desired nickname"))
+			rb.Note("SANICK", "NOOP", client.t("Client already had the desired nickname"), utils.SafeErrorParam(nickname))
 		}
 	} else if err != nil {
 		client.server.logger.Error("internal", "couldn't change nick", nickname, err.Error())
 		if !isSanick {
 			rb.Add(nil, server.name, ERR_UNKNOWNERROR, details.nick, "NICK", client.t("Could not set or change nickname"))
 		} else {
-			rb.Add(nil, server.name, "FAIL", "SANICK", "UNKNOWN_ERROR", utils.SafeErrorParam(nickname), client.t("Could not set or change nickname"))
+			rb.Fail("SANICK", "UNKNOWN_ERROR", client.t("Could not set or change nickname"), utils.SafeErrorParam(nickname))
 		}
 	}
 	if err != nil {
@@ -171,6 +171,7 @@ func fixupNickEqualsAccount(client *Client, rb *ResponseBuffer, config *Config,
 	err := performNickChange(client.server, client, client, rb.session, client.AccountName(), rb)
 	if err != nil && err != errNoop {
 		client.server.accounts.Logout(client)
+		client.sendAccountNotify(rb, "*")
 		if source == "" {
 			source = client.server.name
 		}
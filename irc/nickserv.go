@@ -131,6 +131,17 @@ If no regex is provided, all registered nicknames are returned.`,
 INFO gives you information about the given (or your own) user account.`,
 			helpShort: `$bINFO$b gives you information on a user account.`,
 		},
+		"regain": {
+			handler: nsRegainHandler,
+			help: `Syntax: $bREGAIN <nickname>$b
+
+REGAIN disconnects the given user from the network, as with $bGHOST$b, then
+changes your nickname to the one you reclaimed.`,
+			helpShort:    `$bREGAIN$b reclaims your nickname and switches to it.`,
+			enabled:      servCmdRequiresNickRes,
+			authRequired: true,
+			minParams:    1,
+		},
 		"register": {
 			handler: nsRegisterHandler,
 			// TODO: "email" is an oversimplification here; it's actually any callback, e.g.,
@@ -774,9 +785,16 @@ func nsGhostHandler(service *ircService, server *Server, client *Client, command
 	} else if ghost == client {
 		service.Notice(rb, client.t("You can't GHOST yourself (try /QUIT instead)"))
 		return
-	} else if ghost.AlwaysOn() {
+	}
+	nsGhost(service, server, client, ghost, nick, rb)
+}
+
+// nsGhost disconnects `ghost`, which is using `nick`, if the client is authorized
+// to do so; it returns whether the ghost was disconnected
+func nsGhost(service *ircService, server *Server, client, ghost *Client, nick string, rb *ResponseBuffer) (success bool) {
+	if ghost.AlwaysOn() {
 		service.Notice(rb, client.t("You can't GHOST an always-on client"))
-		return
+		return false
 	}
 
 	authorized := false
@@ -787,11 +805,32 @@ func nsGhostHandler(service *ircService, server *Server, client *Client, command
 	}
 	if !authorized {
 		service.Notice(rb, client.t("You don't own that nick"))
-		return
+		return false
 	}
 
 	ghost.Quit(fmt.Sprintf(ghost.t("GHOSTed by %s"), client.Nick()), nil)
 	ghost.destroy(nil)
+	return true
+}
+
+func nsRegainHandler(service *ircService, server *Server, client *Client, command string, params []string, rb *ResponseBuffer) {
+	nick := params[0]
+
+	ghost := server.clients.Get(nick)
+	if ghost == client {
+		service.Notice(rb, client.t("You're already using that nickname"))
+		return
+	}
+	// don't disconnect the current user of a nick that is reserved by some other
+	// account, since we wouldn't be able to take it afterwards
+	if owner := server.accounts.NickToAccount(nick); owner != "" && owner != client.Account() {
+		service.Notice(rb, client.t("That nickname is reserved by another account"))
+		return
+	}
+	if ghost != nil && !nsGhost(service, server, client, ghost, nick, rb) {
+		return
+	}
+	performNickChange(server, client, client, rb.session, nick, rb)
 }
 
 func nsGroupHandler(service *ircService, server *Server, client *Client, command string, params []string, rb *ResponseBuffer) {
@@ -1057,7 +1096,7 @@ func nsSaregisterHandler(service *ircService, server *Server, client *Client, co
 		}
 		service.Notice(rb, errMsg)
 		if registerCap {
-			rb.Add(nil, server.name, "FAIL", "REGISTER", failCode, utils.SafeErrorParam(account), err.Error())
+			rb.Fail("REGISTER", failCode, err.Error(), utils.SafeErrorParam(account))
 		}
 	} else {
 		service.Notice(rb, fmt.Sprintf(client.t("Successfully registered account %s"), account))
@@ -1065,6 +1104,7 @@ func nsSaregisterHandler(service *ircService, server *Server, client *Client, co
 			rb.Add(nil, server.name, "REGISTER", "SUCCESS", account, client.t("Account successfully registered"))
 		}
 		server.snomasks.Send(sno.LocalAccounts, fmt.Sprintf(ircfmt.Unescape("Operator $c[grey][$r%s$c[grey]] registered account $c[grey][$r%s$c[grey]] with SAREGISTER"), client.Oper().Name, account))
+		server.audit(client, "NS SAREGISTER", account, "")
 	}
 }
 
@@ -1073,6 +1113,7 @@ func nsSaverifyHandler(service *ircService, server *Server, client *Client, comm
 	err := server.accounts.Verify(nil, account, "", true)
 	if err == nil {
 		service.Notice(rb, fmt.Sprintf(client.t("Successfully verified account %s"), account))
+		server.audit(client, "NS SAVERIFY", account, "")
 	} else {
 		service.Notice(rb, fmt.Sprintf(client.t("Failed to verify account %s: %v"), account, err.Error()))
 	}
@@ -1141,6 +1182,9 @@ func nsUnregisterHandler(service *ircService, server *Server, client *Client, co
 		service.Notice(rb, fmt.Sprintf(client.t("Successfully unregistered account %s"), accountName))
 		server.logger.Info("accounts", "client", client.Nick(), "unregistered account", accountName)
 		client.server.snomasks.Send(sno.LocalAccounts, fmt.Sprintf(ircfmt.Unescape("Client $c[grey][$r%s$c[grey]] unregistered account $c[grey][$r%s$c[grey]]"), client.NickMaskString(), accountName))
+		if accountName != client.AccountName() {
+			server.audit(client, "NS "+strings.ToUpper(command), accountName, "")
+		}
 	}
 }
 
@@ -1205,6 +1249,7 @@ func nsPasswdHandler(service *ircService, server *Server, client *Client, comman
 			message := fmt.Sprintf("Operator %s ran NS PASSWD for account %s", oper.Name, target)
 			server.snomasks.Send(sno.LocalOpers, message)
 			server.logger.Info("opers", message)
+			server.audit(client, "NS PASSWD", target, "")
 		}
 	case 3:
 		target = client.Account()
@@ -1531,6 +1576,7 @@ func nsSuspendAddHandler(service *ircService, server *Server, client *Client, co
 	switch err {
 	case nil:
 		service.Notice(rb, fmt.Sprintf(client.t("Successfully suspended account %s"), account))
+		server.audit(client, "NS SUSPEND", account, reason)
 	case errAccountDoesNotExist:
 		service.Notice(rb, client.t("No such account"))
 	default:
@@ -1548,6 +1594,7 @@ func nsSuspendRemoveHandler(service *ircService, server *Server, client *Client,
 	switch err {
 	case nil:
 		service.Notice(rb, fmt.Sprintf(client.t("Successfully un-suspended account %s"), params[0]))
+		server.audit(client, "NS UNSUSPEND", params[0], "")
 	case errAccountDoesNotExist:
 		service.Notice(rb, client.t("No such account"))
 	case errNoop:
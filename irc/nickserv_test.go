@@ -0,0 +1,50 @@
+package irc
+
+import (
+	"testing"
+
+	"github.com/ergochat/ergo/irc/connection_limits"
+)
+
+func TestNickServRegain(t *testing.T) {
+	server, client, session := newHandlerTestClient(t)
+	server.clients.Initialize()
+	server.accounts.server = server
+	server.connectionLimiter.ApplyConfig(&connection_limits.LimiterConfig{})
+	server.semaphores.Initialize()
+	server.accounts.nickToAccount = map[string]string{"reserved": "carol"}
+	server.Config().Limits.NickLen = 32
+	client.account, client.accountName = "alice", "alice"
+	server.clients.byNick["alice"] = client
+
+	squatter := &Client{server: server, nick: "reserved", nickCasefolded: "reserved", account: "alice", accountName: "alice"}
+	server.clients.byNick["reserved"] = squatter
+
+	run := func(nick string) (notices []string) {
+		rb := NewResponseBuffer(session)
+		nsRegainHandler(nickservService, server, client, "REGAIN", []string{nick}, rb)
+		for _, message := range rb.messages {
+			notices = append(notices, message.Command+" "+message.Params[len(message.Params)-1])
+		}
+		return
+	}
+
+	// the nick is reserved by another account: the squatter (even though it's
+	// logged into the caller's account) must not be disconnected
+	if notices := run("reserved"); len(notices) != 1 || notices[0] != "NOTICE That nickname is reserved by another account" {
+		t.Errorf("unexpected REGAIN output: %v", notices)
+	}
+	if server.clients.Get("reserved") != squatter {
+		t.Errorf("REGAIN of a nick reserved by another account disconnected its user")
+	}
+
+	// the nick is held by another client of the caller's account
+	ghost := &Client{server: server, nick: "alice_", nickCasefolded: "alice_", account: "alice", accountName: "alice"}
+	ghostConn := &testIRCConn{}
+	ghost.sessions = []*Session{{client: ghost, socket: NewSocket(ghostConn, 0, nil)}}
+	server.clients.byNick["alice_"] = ghost
+	notices := run("alice_")
+	if server.clients.Get("alice_") != client || client.Nick() != "alice_" {
+		t.Errorf("REGAIN did not take the nick: %v", notices)
+	}
+}
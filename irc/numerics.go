@@ -177,6 +177,11 @@ const (
 	RPL_HELPSTART          = "704"
 	RPL_HELPTXT            = "705"
 	RPL_ENDOFHELP          = "706"
+	RPL_KNOCK              = "710"
+	RPL_KNOCKDLVR          = "711"
+	ERR_TOOMANYKNOCK       = "712"
+	ERR_CHANOPEN           = "713"
+	ERR_KNOCKONCHAN        = "714"
 	ERR_NOPRIVS            = "723"
 	RPL_MONONLINE          = "730"
 	RPL_MONOFFLINE         = "731"
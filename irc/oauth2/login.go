@@ -0,0 +1,270 @@
+package oauth2
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// how long a user has to complete the login at the provider
+	loginTimeout     = 10 * time.Minute
+	maxPendingLogins = 4096
+	maxTokenResponse = 1 << 20
+)
+
+// LoginConfig configures a web login flow (the OAuth 2.0 authorization code
+// grant, with PKCE), with which users can log in at the identity provider and
+// obtain an access token to present to Ergo via SASL OAUTHBEARER.
+type LoginConfig struct {
+	Enabled  bool   `yaml:"enabled"`
+	Listener string `yaml:"listener"`
+	TLS      struct {
+		Cert string `yaml:"cert"`
+		Key  string `yaml:"key"`
+	} `yaml:"tls"`
+	AuthorizationURL string   `yaml:"authorization-url"`
+	TokenURL         string   `yaml:"token-url"`
+	RedirectURL      string   `yaml:"redirect-url"`
+	Scopes           []string `yaml:"scopes"`
+}
+
+func (l *LoginConfig) Postprocess(clientID string) error {
+	if !l.Enabled {
+		return nil
+	}
+	if l.Listener == "" {
+		return fmt.Errorf("oauth2 login is enabled, but no listener is configured")
+	}
+	if (l.TLS.Cert == "") != (l.TLS.Key == "") {
+		return fmt.Errorf("oauth2 login requires both a TLS certificate and key, or neither")
+	}
+	if clientID == "" {
+		return fmt.Errorf("oauth2 login requires a client-id")
+	}
+	for name, value := range map[string]string{"authorization-url": l.AuthorizationURL, "token-url": l.TokenURL, "redirect-url": l.RedirectURL} {
+		if u, err := url.Parse(value); err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("oauth2 login requires a valid %s", name)
+		}
+	}
+	return nil
+}
+
+// LoginCompleter validates the access token obtained by a login (provisioning
+// an account if necessary), returning the user's account name.
+type LoginCompleter func(r *http.Request, token string) (accountName string, err error)
+
+type pendingLogin struct {
+	verifier string
+	expires  time.Time
+}
+
+// LoginHandler serves the web login flow: GET /login redirects to the
+// identity provider, which redirects back to GET /callback.
+type LoginHandler struct {
+	sync.Mutex
+
+	config   func() *OAuth2BearerConfig
+	complete LoginCompleter
+	pending  map[string]pendingLogin
+	mux      *http.ServeMux
+}
+
+// NewLoginHandler returns a LoginHandler; `config` returns the current
+// configuration, so that it can change on rehash.
+func NewLoginHandler(config func() *OAuth2BearerConfig, complete LoginCompleter) *LoginHandler {
+	h := &LoginHandler{
+		config:   config,
+		complete: complete,
+		pending:  make(map[string]pendingLogin),
+		mux:      http.NewServeMux(),
+	}
+	h.mux.HandleFunc("GET /login", h.serveLogin)
+	h.mux.HandleFunc("GET /callback", h.serveCallback)
+	return h
+}
+
+func (h *LoginHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, r)
+}
+
+func randomToken() string {
+	buf := make([]byte, 32)
+	rand.Read(buf)
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+// addPending records a new login, returning its state parameter
+func (h *LoginHandler) addPending(verifier string) (state string, ok bool) {
+	h.Lock()
+	defer h.Unlock()
+
+	now := time.Now()
+	for state, login := range h.pending {
+		if now.After(login.expires) {
+			delete(h.pending, state)
+		}
+	}
+	if len(h.pending) >= maxPendingLogins {
+		return "", false
+	}
+	state = randomToken()
+	h.pending[state] = pendingLogin{verifier: verifier, expires: now.Add(loginTimeout)}
+	return state, true
+}
+
+func (h *LoginHandler) removePending(state string) (verifier string, ok bool) {
+	h.Lock()
+	defer h.Unlock()
+
+	login, ok := h.pending[state]
+	if !ok || time.Now().After(login.expires) {
+		return "", false
+	}
+	delete(h.pending, state)
+	return login.verifier, true
+}
+
+func (h *LoginHandler) serveLogin(w http.ResponseWriter, r *http.Request) {
+	config := h.config()
+	if !config.Enabled || !config.Login.Enabled {
+		http.NotFound(w, r)
+		return
+	}
+	verifier := randomToken()
+	state, ok := h.addPending(verifier)
+	if !ok {
+		http.Error(w, "Too many logins in progress; try again later", http.StatusServiceUnavailable)
+		return
+	}
+	challenge := sha256.Sum256([]byte(verifier))
+	params := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {config.ClientID},
+		"redirect_uri":          {config.Login.RedirectURL},
+		"state":                 {state},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	if len(config.Login.Scopes) != 0 {
+		params.Set("scope", strings.Join(config.Login.Scopes, " "))
+	}
+	authorizationURL := config.Login.AuthorizationURL
+	if strings.Contains(authorizationURL, "?") {
+		authorizationURL += "&" + params.Encode()
+	} else {
+		authorizationURL += "?" + params.Encode()
+	}
+	http.Redirect(w, r, authorizationURL, http.StatusFound)
+}
+
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int    `json:"expires_in"`
+	Error       string `json:"error"`
+}
+
+var loginSuccessTemplate = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Logged in</title></head>
+<body>
+<p>You are logged in as <b>{{.Account}}</b>.</p>
+<p>To log in from your IRC client, configure it to use SASL with the OAUTHBEARER mechanism and this token{{if .Expires}} (valid until {{.Expires}}){{end}}:</p>
+<pre>{{.Token}}</pre>
+</body>
+</html>
+`))
+
+func (h *LoginHandler) serveCallback(w http.ResponseWriter, r *http.Request) {
+	config := h.config()
+	if !config.Enabled || !config.Login.Enabled {
+		http.NotFound(w, r)
+		return
+	}
+	query := r.URL.Query()
+	verifier, ok := h.removePending(query.Get("state"))
+	if !ok {
+		http.Error(w, "Invalid or expired login; please try again", http.StatusBadRequest)
+		return
+	}
+	if errCode := query.Get("error"); errCode != "" {
+		http.Error(w, fmt.Sprintf("Login failed: %s", errCode), http.StatusForbidden)
+		return
+	}
+	code := query.Get("code")
+	if code == "" {
+		http.Error(w, "Missing authorization code", http.StatusBadRequest)
+		return
+	}
+
+	token, err := exchangeCode(r.Context(), config, code, verifier)
+	if err != nil {
+		http.Error(w, "Could not obtain a token from the identity provider", http.StatusBadGateway)
+		return
+	}
+	accountName, err := h.complete(r, token.AccessToken)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Could not log in: %v", err), http.StatusForbidden)
+		return
+	}
+
+	var expires string
+	if token.ExpiresIn > 0 {
+		expires = time.Now().Add(time.Duration(token.ExpiresIn) * time.Second).UTC().Format(time.RFC1123)
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	loginSuccessTemplate.Execute(w, map[string]string{"Account": accountName, "Token": token.AccessToken, "Expires": expires})
+}
+
+// exchangeCode redeems an authorization code at the token endpoint
+func exchangeCode(ctx context.Context, config *OAuth2BearerConfig, code, verifier string) (result tokenResponse, err error) {
+	ctx, cancel := context.WithTimeout(ctx, config.IntrospectionTimeout)
+	defer cancel()
+
+	params := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {config.Login.RedirectURL},
+		"code_verifier": {verifier},
+	}
+	if config.ClientSecret == "" {
+		params.Set("client_id", config.ClientID)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, config.Login.TokenURL, strings.NewReader(params.Encode()))
+	if err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if config.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(config.ClientID), url.QueryEscape(config.ClientSecret))
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	if err = json.NewDecoder(io.LimitReader(resp.Body, maxTokenResponse)).Decode(&result); err != nil {
+		return
+	}
+	if resp.StatusCode != http.StatusOK || result.Error != "" {
+		return result, fmt.Errorf("OAuth 2.0 token error: %v %s", resp.Status, result.Error)
+	}
+	if result.AccessToken == "" {
+		return result, fmt.Errorf("missing access_token in OAuth 2.0 token response")
+	}
+	return
+}
@@ -0,0 +1,105 @@
+package oauth2
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoginFlow(t *testing.T) {
+	// fake identity provider token endpoint
+	var challenge string
+	provider := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		verifier := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+		if r.PostForm.Get("code") != "goodcode" || base64.RawURLEncoding.EncodeToString(verifier[:]) != challenge || r.PostForm.Get("client_id") != "ergo" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{"access_token": "tok123", "token_type": "Bearer", "expires_in": 3600})
+	}))
+	defer provider.Close()
+
+	config := OAuth2BearerConfig{
+		Enabled:              true,
+		IntrospectionTimeout: time.Second,
+		ClientID:             "ergo",
+		Login: LoginConfig{
+			Enabled:          true,
+			Listener:         "127.0.0.1:0",
+			AuthorizationURL: "https://idp.example.com/authorize",
+			TokenURL:         provider.URL,
+			RedirectURL:      "https://irc.example.com/callback",
+			Scopes:           []string{"openid", "profile"},
+		},
+	}
+	if err := config.Postprocess(); err != nil {
+		t.Fatal(err)
+	}
+	var completedToken string
+	handler := NewLoginHandler(func() *OAuth2BearerConfig { return &config }, func(r *http.Request, token string) (string, error) {
+		completedToken = token
+		return "alice", nil
+	})
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/login", nil))
+	if w.Code != http.StatusFound {
+		t.Fatalf("expected redirect, got %d", w.Code)
+	}
+	location, err := url.Parse(w.Header().Get("Location"))
+	if err != nil || location.Host != "idp.example.com" {
+		t.Fatalf("unexpected redirect %s", w.Header().Get("Location"))
+	}
+	params := location.Query()
+	if params.Get("client_id") != "ergo" || params.Get("scope") != "openid profile" || params.Get("code_challenge_method") != "S256" {
+		t.Errorf("unexpected authorization parameters %v", params)
+	}
+	challenge = params.Get("code_challenge")
+	state := params.Get("state")
+
+	// unknown state
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/callback?code=goodcode&state=bogus", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for unknown state, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/callback?code=goodcode&state="+url.QueryEscape(state), nil))
+	if w.Code != http.StatusOK || completedToken != "tok123" || !strings.Contains(w.Body.String(), "tok123") || !strings.Contains(w.Body.String(), "alice") {
+		t.Errorf("unexpected callback response %d %s", w.Code, w.Body.String())
+	}
+
+	// states can't be reused
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/callback?code=goodcode&state="+url.QueryEscape(state), nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for reused state, got %d", w.Code)
+	}
+}
+
+func TestLoginConfigTLS(t *testing.T) {
+	config := LoginConfig{
+		Enabled:          true,
+		Listener:         "127.0.0.1:0",
+		AuthorizationURL: "https://idp.example.com/authorize",
+		TokenURL:         "https://idp.example.com/token",
+		RedirectURL:      "https://irc.example.com/callback",
+	}
+	config.TLS.Cert = "fullchain.pem"
+	if err := config.Postprocess("ergo"); err == nil {
+		t.Errorf("accepted a TLS certificate without a key")
+	}
+	config.TLS.Key = "privkey.pem"
+	if err := config.Postprocess("ergo"); err != nil {
+		t.Errorf("rejected a TLS certificate and key: %v", err)
+	}
+}
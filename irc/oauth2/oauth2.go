@@ -33,6 +33,8 @@ type OAuth2BearerConfig struct {
 	// omit for `none`, required for `client_secret_basic`
 	ClientID     string `yaml:"client-id"`
 	ClientSecret string `yaml:"client-secret"`
+	// optional web login flow for obtaining tokens
+	Login LoginConfig `yaml:"login"`
 }
 
 func (o *OAuth2BearerConfig) Postprocess() error {
@@ -48,7 +50,7 @@ func (o *OAuth2BearerConfig) Postprocess() error {
 		return fmt.Errorf("invalid introspection-url: %w", err)
 	}
 
-	return nil
+	return o.Login.Postprocess(o.ClientID)
 }
 
 func (o *OAuth2BearerConfig) Introspect(ctx context.Context, token string) (username string, err error) {
@@ -0,0 +1,77 @@
+package irc
+
+import (
+	"net/http"
+
+	"github.com/ergochat/ergo/irc/oauth2"
+	"github.com/ergochat/ergo/irc/utils"
+)
+
+// the oauth2 login listener serves a web login flow, with which users log in
+// at the identity provider and obtain an access token for SASL OAUTHBEARER;
+// see oauth2.LoginHandler.
+
+func (server *Server) setupOAuth2Login(config *Config) {
+	var settings apiSettings
+	if login := &config.Accounts.OAuth2.Login; config.Accounts.OAuth2.Enabled && login.Enabled {
+		settings = apiSettings{listener: login.Listener, cert: login.TLS.Cert, key: login.TLS.Key}
+	}
+	if server.oauth2LoginServer != nil {
+		if settings == server.oauth2LoginSettings {
+			return
+		}
+		server.logger.Info("server", "Stopping oauth2 login listener", server.oauth2LoginServer.Addr)
+		server.oauth2LoginServer.Close()
+		server.oauth2LoginServer = nil
+	}
+	server.oauth2LoginSettings = settings
+	if settings.listener == "" {
+		return
+	}
+
+	handler := oauth2.NewLoginHandler(func() *oauth2.OAuth2BearerConfig {
+		return &server.Config().Accounts.OAuth2
+	}, server.accounts.completeOAuth2Login)
+	ls := http.Server{
+		Addr:    settings.listener,
+		Handler: handler,
+	}
+	go func() {
+		var err error
+		if settings.cert != "" {
+			err = ls.ListenAndServeTLS(settings.cert, settings.key)
+		} else {
+			err = ls.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
+			server.logger.Error("server", "oauth2 login listener failed", err.Error())
+		}
+	}()
+	server.oauth2LoginServer = &ls
+	server.logger.Info("server", "Started oauth2 login listener", settings.listener)
+}
+
+// completeOAuth2Login validates the token obtained by the oauth2 web login
+// flow, creating the user's account on first login if autocreate is enabled.
+func (am *AccountManager) completeOAuth2Login(r *http.Request, token string) (accountName string, err error) {
+	defer am.server.HandlePanic()
+
+	config := am.server.Config()
+	// behind a reverse proxy, the user's IP is taken from X-Forwarded-For,
+	// as with websockets
+	var ip string
+	if proxiedIP := utils.HandleXForwardedFor(r.RemoteAddr, r.Header.Get("X-Forwarded-For"), config.Server.proxyAllowedFromNets); proxiedIP != nil {
+		ip = proxiedIP.String()
+	}
+	username, err := am.oauth2Username(config, oauth2.OAuthBearerOptions{Token: token}, ip)
+	if err != nil {
+		am.server.logger.Debug("accounts", "invalid token from oauth2 login", err.Error())
+		return "", errAccountInvalidCredentials
+	}
+	account, err := am.loadWithAutocreation(username, config.Accounts.OAuth2.Autocreate)
+	if err != nil {
+		return "", err
+	}
+	am.server.logger.Info("accounts", "oauth2 web login for account", account.Name)
+	return account.Name, nil
+}
@@ -310,3 +310,27 @@ func (rb *ResponseBuffer) flushInternal(final bool, blocking bool) error {
 func (rb *ResponseBuffer) Notice(text string) {
 	rb.Add(nil, rb.target.server.name, "NOTICE", rb.target.Nick(), text)
 }
+
+// Fail sends the client an IRCv3 FAIL standard reply, in the form
+// `FAIL <command> <code> [<context>...] :<description>`.
+func (rb *ResponseBuffer) Fail(command, code, description string, context ...string) {
+	rb.addStandardReply("FAIL", command, code, description, context)
+}
+
+// Warn sends the client an IRCv3 WARN standard reply.
+func (rb *ResponseBuffer) Warn(command, code, description string, context ...string) {
+	rb.addStandardReply("WARN", command, code, description, context)
+}
+
+// Note sends the client an IRCv3 NOTE standard reply.
+func (rb *ResponseBuffer) Note(command, code, description string, context ...string) {
+	rb.addStandardReply("NOTE", command, code, description, context)
+}
+
+func (rb *ResponseBuffer) addStandardReply(replyType, command, code, description string, context []string) {
+	params := make([]string, 0, 3+len(context))
+	params = append(params, command, code)
+	params = append(params, context...)
+	params = append(params, description)
+	rb.Add(nil, rb.target.server.name, replyType, params...)
+}
@@ -23,6 +23,7 @@ import (
 	"github.com/ergochat/irc-go/ircfmt"
 	"github.com/okzk/sdnotify"
 	"github.com/tidwall/buntdb"
+	"golang.org/x/crypto/acme/autocert"
 
 	"github.com/ergochat/ergo/irc/bunt"
 	"github.com/ergochat/ergo/irc/caps"
@@ -40,6 +41,8 @@ import (
 
 const (
 	alwaysOnMaintenanceInterval = 30 * time.Minute
+	// how long to wait on shutdown for disconnected clients' sendqs to drain
+	defaultShutdownTimeout = 5 * time.Second
 )
 
 var (
@@ -65,37 +68,55 @@ var (
 
 // Server is the main Oragono server.
 type Server struct {
-	accepts           AcceptManager
-	accounts          AccountManager
-	channels          ChannelManager
-	clients           ClientManager
-	config            atomic.Pointer[Config]
-	configFilename    string
-	connectionLimiter connection_limits.Limiter
-	ctime             time.Time
-	dlines            *DLineManager
-	helpIndexManager  HelpIndexManager
-	klines            *KLineManager
-	listeners         map[string]IRCListener
-	logger            *logger.Manager
-	monitorManager    MonitorManager
-	name              string
-	nameCasefolded    string
-	rehashMutex       sync.Mutex // tier 4
-	rehashSignal      chan os.Signal
-	pprofServer       *http.Server
-	exitSignals       chan os.Signal
-	tracebackSignal   chan os.Signal
-	snomasks          SnoManager
-	store             *buntdb.DB
-	dstore            datastore.Datastore
-	historyDB         mysql.MySQL
-	torLimiter        connection_limits.TorLimiter
-	whoWas            WhoWasList
-	stats             Stats
-	semaphores        ServerSemaphores
-	flock             flock.Flocker
-	defcon            atomic.Uint32
+	accepts             AcceptManager
+	accounts            AccountManager
+	channels            ChannelManager
+	clients             ClientManager
+	config              atomic.Pointer[Config]
+	configFilename      string
+	connectionLimiter   connection_limits.Limiter
+	ctime               time.Time
+	dlines              *DLineManager
+	dnsblCache          DNSBLCache
+	helpIndexManager    HelpIndexManager
+	klines              *KLineManager
+	listeners           map[string]IRCListener
+	systemdListeners    []net.Listener // inherited via socket activation, not yet in use
+	logger              *logger.Manager
+	monitorManager      MonitorManager
+	name                string
+	nameCasefolded      string
+	rehashMutex         sync.Mutex // tier 4
+	rehashSignal        chan os.Signal
+	pprofServer         *http.Server
+	metricsServer       *http.Server
+	apiServer           *http.Server
+	apiSettings         apiSettings
+	controlServer       *http.Server
+	controlSocket       string
+	oauth2LoginServer   *http.Server
+	oauth2LoginSettings apiSettings
+	acmeConfig          ACMEConfig
+	acmeManager         *autocert.Manager
+	acmeServer          *http.Server
+	exitSignals         chan os.Signal
+	dieRequests         chan string
+	tracebackSignal     chan os.Signal
+	snomasks            SnoManager
+	store               *buntdb.DB
+	dstore              datastore.Datastore
+	historyDB           mysql.MySQL
+	torLimiter          connection_limits.TorLimiter
+	whoWas              WhoWasList
+	stats               Stats
+	auditLog            AuditLog
+	webPush             WebPushManager
+	webhooks            WebhookManager
+	discord             DiscordBridge
+	matrix              MatrixBridge
+	semaphores          ServerSemaphores
+	flock               flock.Flocker
+	defcon              atomic.Uint32
 }
 
 // NewServer returns a new Oragono server.
@@ -111,6 +132,7 @@ func NewServer(config *Config, logger *logger.Manager) (*Server, error) {
 		logger:          logger,
 		rehashSignal:    make(chan os.Signal, 1),
 		exitSignals:     make(chan os.Signal, len(utils.ServerExitSignals)),
+		dieRequests:     make(chan string, 1),
 		tracebackSignal: make(chan os.Signal, len(utils.ServerTracebackSignals)),
 	}
 	server.defcon.Store(5)
@@ -121,6 +143,14 @@ func NewServer(config *Config, logger *logger.Manager) (*Server, error) {
 	server.whoWas.Initialize(config.Limits.WhowasEntries)
 	server.monitorManager.Initialize()
 	server.snomasks.Initialize()
+	server.webPush.Initialize(server)
+	server.webhooks.Initialize(server)
+
+	systemdListeners, err := utils.SystemdListeners()
+	if err != nil {
+		server.logger.Error("listeners", "could not use all sockets passed by systemd", err.Error())
+	}
+	server.systemdListeners = systemdListeners
 
 	if err := server.applyConfig(config); err != nil {
 		return nil, err
@@ -139,13 +169,40 @@ func NewServer(config *Config, logger *logger.Manager) (*Server, error) {
 }
 
 // Shutdown shuts down the server.
-func (server *Server) Shutdown() {
+func (server *Server) Shutdown(quitMessage string) {
 	sdnotify.Stopping()
 	server.logger.Info("server", "Stopping server")
 
-	//TODO(dan): Make sure we disallow new nicks
+	config := server.Config()
+
+	// stop accepting new connections:
+	server.rehashMutex.Lock()
+	for addr, listener := range server.listeners {
+		listener.Stop()
+		delete(server.listeners, addr)
+	}
+	server.rehashMutex.Unlock()
+
+	// disconnect everyone, then give their sendqs a chance to drain
+	// before we close the datastore out from under them:
+	var sockets []*Socket
 	for _, client := range server.clients.AllClients() {
-		client.Notice("Server is shutting down")
+		client.Notice(quitMessage)
+		client.Quit(quitMessage, nil)
+		for _, session := range client.Sessions() {
+			session.socket.Close()
+			sockets = append(sockets, session.socket)
+		}
+	}
+	deadline := time.NewTimer(config.Server.ShutdownTimeout)
+	defer deadline.Stop()
+drain:
+	for _, socket := range sockets {
+		select {
+		case <-socket.Finalized():
+		case <-deadline.C:
+			break drain
+		}
 	}
 
 	// flush data associated with always-on clients:
@@ -156,17 +213,59 @@ func (server *Server) Shutdown() {
 	}
 
 	server.historyDB.Close()
+	server.stopHTTPListeners()
 	server.logger.Info("server", fmt.Sprintf("%s exiting", Ver))
 }
 
+// stopHTTPListeners closes all the auxiliary HTTP listeners, on shutdown
+func (server *Server) stopHTTPListeners() {
+	server.rehashMutex.Lock()
+	defer server.rehashMutex.Unlock()
+
+	for _, httpServer := range []*http.Server{server.pprofServer, server.metricsServer, server.acmeServer,
+		server.apiServer, server.controlServer, server.oauth2LoginServer} {
+		if httpServer != nil {
+			httpServer.Close()
+		}
+	}
+	server.webhooks.Stop()
+	server.matrix.Stop()
+}
+
+// Die requests that the server shut down, disconnecting clients with the given reason.
+func (server *Server) Die(reason string) {
+	select {
+	case server.dieRequests <- reason:
+	default:
+		// a shutdown is already pending
+	}
+}
+
 // Run starts the server.
 func (server *Server) Run() {
-	defer server.Shutdown()
+	quitMessage := "Server is shutting down"
+	defer func() {
+		server.Shutdown(quitMessage)
+	}()
+
+	var watchdog <-chan time.Time
+	if interval := utils.SystemdWatchdogInterval(); interval != 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		watchdog = ticker.C
+	}
 
 	for {
 		select {
+		case <-watchdog:
+			sdnotify.Watchdog()
 		case <-server.exitSignals:
 			return
+		case reason := <-server.dieRequests:
+			if reason != "" {
+				quitMessage = fmt.Sprintf("Server is shutting down: %s", reason)
+			}
+			return
 		case <-server.rehashSignal:
 			server.logger.Info("server", "Rehashing due to SIGHUP")
 			go server.rehash()
@@ -311,6 +410,26 @@ func (server *Server) checkBanScriptExemptSASL(config *Config, session *Session)
 	return authSuccess
 }
 
+// collects the result of the DNSBL lookup started when the client connected
+// (see startDNSBLLookup), waiting for it if necessary
+func (server *Server) checkDNSBLResult(session *Session) (outcome AuthOutcome, quitMessage string) {
+	result := session.dnsbl.Wait()
+	if result.host == "" {
+		return authSuccess, ""
+	}
+	ipaddr := session.IP().String()
+	if result.action == dnsblRequireSASL {
+		if session.client.Account() != "" {
+			return authSuccess, ""
+		}
+		server.logger.Info("connect-ip", "Requiring SASL from client due to DNSBL", ipaddr, result.host)
+		session.client.requireSASLMessage = result.reason
+		return authFailSaslRequired, ""
+	}
+	server.logger.Info("connect-ip", "Rejected client due to DNSBL", ipaddr, result.host)
+	return authFailBanned, result.reason
+}
+
 func (server *Server) tryRegister(c *Client, session *Session) (exiting bool) {
 	// XXX PROXY or WEBIRC MUST be sent as the first line of the session;
 	// if we are here at all that means we have the final value of the IP
@@ -337,6 +456,9 @@ func (server *Server) tryRegister(c *Client, session *Session) (exiting bool) {
 		authOutcome = server.checkBanScriptExemptSASL(config, session)
 	}
 	var quitMessage string
+	if authOutcome == authSuccess && session.dnsbl != nil {
+		authOutcome, quitMessage = server.checkDNSBLResult(session)
+	}
 	switch authOutcome {
 	case authFailPass:
 		quitMessage = c.t("Password incorrect")
@@ -349,6 +471,8 @@ func (server *Server) tryRegister(c *Client, session *Session) (exiting bool) {
 		c.Send(nil, c.server.name, "FAIL", "*", "ACCOUNT_REQUIRED", quitMessage)
 	}
 	if authOutcome != authSuccess {
+		server.stats.CountRegistrationFailure()
+		server.snomasks.Send(sno.LocalRejects, fmt.Sprintf("Registration rejected [%s] [ip:%s]: %s", c.preregNick, session.IP().String(), quitMessage))
 		c.Quit(quitMessage, nil)
 		return true
 	}
@@ -387,6 +511,7 @@ func (server *Server) tryRegister(c *Client, session *Session) (exiting bool) {
 			c.setKlined()
 			c.Quit(info.BanMessage(c.t("You are banned from this server (%s)")), nil)
 			server.logger.Info("connect", "Client rejected by k-line", c.NickMaskString())
+			server.snomasks.Send(sno.LocalRejects, fmt.Sprintf("Client rejected by K-line [%s] [ip:%s]: %s", c.NickMaskString(), session.IP().String(), info.Reason))
 			return true
 		}
 	}
@@ -442,6 +567,9 @@ func (server *Server) playRegistrationBurst(session *Session) {
 	}
 	server.Lusers(c, rb)
 	server.MOTD(c, rb)
+	if d.account != "" {
+		notifyUnreadMemos(c, rb)
+	}
 	rb.Send(true)
 
 	modestring := c.ModeString()
@@ -680,6 +808,10 @@ func (server *Server) applyConfig(config *Config) (err error) {
 	sendRawOutputNotice := !wasLoggingRawIO && nowLoggingRawIO
 
 	server.connectionLimiter.ApplyConfig(&config.Server.IPLimits)
+	if !initial {
+		// the lists or their settings may have changed
+		server.dnsblCache.Clear()
+	}
 
 	tlConf := &config.Server.TorListeners
 	server.torLimiter.Configure(tlConf.MaxConnections, tlConf.ThrottleDuration, tlConf.MaxConnectionsPerDuration)
@@ -743,8 +875,23 @@ func (server *Server) applyConfig(config *Config) (err error) {
 	}
 	config.Server.Cloaks.SetSecret(cloakSecret)
 
+	// likewise for the VAPID keypair, which is advertised in ISUPPORT
+	if config.WebPush.Enabled {
+		config.WebPush.vapidKeys, err = LoadVAPIDKeys(server.dstore)
+		if err != nil {
+			return fmt.Errorf("Could not load VAPID keys: %w", err)
+		}
+		config.Server.isupport.Add("VAPID", config.WebPush.vapidKeys.PublicKeyString())
+		if err = config.Server.isupport.RegenerateCachedReply(); err != nil {
+			return err
+		}
+	}
+
 	// activate the new config
 	server.config.Store(config)
+	server.discord.ApplyConfig(server, config)
+	server.matrix.ApplyConfig(server, config)
+	server.webhooks.ApplyConfig(config)
 
 	// load [dk]-lines, registered users and channels, etc.
 	if initial {
@@ -783,6 +930,10 @@ func (server *Server) applyConfig(config *Config) (err error) {
 	}
 
 	server.setupPprofListener(config)
+	server.setupMetricsListener(config)
+	server.setupAPIListener(config)
+	server.setupControlSocket(config)
+	server.setupOAuth2Login(config)
 
 	// set RPL_ISUPPORT
 	var newISupportReplies [][]string
@@ -794,10 +945,17 @@ func (server *Server) applyConfig(config *Config) (err error) {
 		server.logger.Info("server", "Proxied IPs will be accepted from", strings.Join(config.Server.ProxyAllowedFrom, ", "))
 	}
 
+	if err = server.setupACME(config); err != nil {
+		return err
+	}
+
 	// we are now ready to receive connections:
 	err = server.setupListeners(config)
 
 	if initial && err == nil {
+		for _, listener := range server.systemdListeners {
+			server.logger.Warning("listeners", "socket passed by systemd does not match any configured listener", listener.Addr().String())
+		}
 		server.logger.Info("server", "Server running")
 		sdnotify.Ready()
 	}
@@ -839,11 +997,16 @@ func (server *Server) setupPprofListener(config *Config) {
 		}
 	}
 	if pprofListener != "" && server.pprofServer == nil {
+		mux := http.NewServeMux()
+		// net/http/pprof registers its handlers with the default mux:
+		mux.Handle("/debug/pprof/", http.DefaultServeMux)
+		mux.HandleFunc("/debug/state", server.serveDebugState)
 		ps := http.Server{
-			Addr: pprofListener,
+			Addr:    pprofListener,
+			Handler: mux,
 		}
 		go func() {
-			if err := ps.ListenAndServe(); err != nil {
+			if err := ps.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 				server.logger.Error("server", "pprof listener failed", err.Error())
 			}
 		}()
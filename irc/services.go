@@ -86,6 +86,13 @@ var (
 		Commands:       hostservCommands,
 		HelpBanner:     hostservHelp,
 	}
+	memoservService = &ircService{
+		Name:           "MemoServ",
+		ShortName:      "MS",
+		CommandAliases: []string{"MEMOSERV", "MS"},
+		Commands:       memoservCommands,
+		HelpBanner:     memoservHelp,
+	}
 	histservService = &ircService{
 		Name:           "HistServ",
 		ShortName:      "HISTSERV",
@@ -101,6 +108,7 @@ var ErgoServices = map[string]*ircService{
 	"chanserv": chanservService,
 	"hostserv": hostservService,
 	"histserv": histservService,
+	"memoserv": memoservService,
 }
 
 func (service *ircService) Notice(rb *ResponseBuffer, text string) {
@@ -19,6 +19,7 @@ const (
 	LocalNicks         Mask = 'n'
 	LocalOpers         Mask = 'o'
 	LocalQuits         Mask = 'q'
+	LocalRejects       Mask = 'r'
 	Stats              Mask = 't'
 	LocalAccounts      Mask = 'u'
 	LocalVhosts        Mask = 'v'
@@ -36,6 +37,7 @@ var (
 		LocalNicks:         "NICK",
 		LocalOpers:         "OPER",
 		LocalQuits:         "QUIT",
+		LocalRejects:       "REJECT",
 		Stats:              "STATS",
 		LocalAccounts:      "ACCOUNT",
 		LocalXline:         "XLINE",
@@ -52,6 +54,7 @@ var (
 		LocalNicks,
 		LocalOpers,
 		LocalQuits,
+		LocalRejects,
 		Stats,
 		LocalAccounts,
 		LocalVhosts,
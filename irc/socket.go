@@ -8,6 +8,7 @@ import (
 	"errors"
 	"io"
 	"sync"
+	"sync/atomic"
 )
 
 var (
@@ -33,13 +34,31 @@ type Socket struct {
 	sendQExceeded bool
 	finalData     []byte // what to send when we die
 	finalized     bool
+	done          chan struct{} // closed once finalized and the connection is closed
+
+	// traffic counters, reported by STATS l:
+	sentLines     atomic.Uint64
+	sentBytes     atomic.Uint64
+	receivedLines atomic.Uint64
+	receivedBytes atomic.Uint64
+	serverStats   *Stats // server-wide totals, may be nil
+}
+
+// SocketStats is a snapshot of the traffic counters of a Socket.
+type SocketStats struct {
+	SentLines     uint64
+	SentBytes     uint64
+	ReceivedLines uint64
+	ReceivedBytes uint64
 }
 
 // NewSocket returns a new Socket.
-func NewSocket(conn IRCConn, maxSendQBytes int) *Socket {
+func NewSocket(conn IRCConn, maxSendQBytes int, serverStats *Stats) *Socket {
 	result := Socket{
 		conn:          conn,
 		maxSendQBytes: maxSendQBytes,
+		serverStats:   serverStats,
+		done:          make(chan struct{}),
 	}
 	return &result
 }
@@ -63,6 +82,9 @@ func (socket *Socket) Read() (string, error) {
 
 	lineBytes, err := socket.conn.ReadLine()
 	line := string(lineBytes)
+	if err == nil || err == errInvalidUtf8 {
+		socket.countReceived(len(lineBytes))
+	}
 
 	if err == io.EOF {
 		socket.Close()
@@ -137,6 +159,8 @@ func (socket *Socket) BlockingWrite(data []byte) (err error) {
 	err = socket.conn.WriteLine(data)
 	if err != nil {
 		socket.finalize()
+	} else {
+		socket.countSent(1, len(data))
 	}
 	return
 }
@@ -157,6 +181,39 @@ func (socket *Socket) SetFinalData(data []byte) {
 	socket.finalData = data
 }
 
+func (socket *Socket) countReceived(bytes int) {
+	socket.receivedLines.Add(1)
+	socket.receivedBytes.Add(uint64(bytes))
+	if socket.serverStats != nil {
+		socket.serverStats.receivedBytes.Add(uint64(bytes))
+	}
+}
+
+func (socket *Socket) countSent(lines, bytes int) {
+	socket.sentLines.Add(uint64(lines))
+	socket.sentBytes.Add(uint64(bytes))
+	if socket.serverStats != nil {
+		socket.serverStats.sentBytes.Add(uint64(bytes))
+	}
+}
+
+// Stats returns the current values of the socket's traffic counters.
+func (socket *Socket) Stats() SocketStats {
+	return SocketStats{
+		SentLines:     socket.sentLines.Load(),
+		SentBytes:     socket.sentBytes.Load(),
+		ReceivedLines: socket.receivedLines.Load(),
+		ReceivedBytes: socket.receivedBytes.Load(),
+	}
+}
+
+// SendQLen returns the number of bytes currently buffered for sending.
+func (socket *Socket) SendQLen() int {
+	socket.Lock()
+	defer socket.Unlock()
+	return socket.totalLength
+}
+
 // IsClosed returns whether the socket is closed.
 func (socket *Socket) IsClosed() bool {
 	socket.Lock()
@@ -164,6 +221,12 @@ func (socket *Socket) IsClosed() bool {
 	return socket.closed
 }
 
+// Finalized returns a channel that is closed once the socket has flushed its
+// final data and closed the connection.
+func (socket *Socket) Finalized() <-chan struct{} {
+	return socket.done
+}
+
 // is there data to write?
 func (socket *Socket) readyToWrite() bool {
 	socket.Lock()
@@ -199,6 +262,7 @@ func (socket *Socket) performWrite() (closed bool) {
 	// retrieve the buffered data, clear the buffer
 	socket.Lock()
 	buffers := socket.buffers
+	totalLength := socket.totalLength
 	socket.buffers = nil
 	socket.totalLength = 0
 	closed = socket.closed
@@ -207,6 +271,9 @@ func (socket *Socket) performWrite() (closed bool) {
 	var err error
 	if 0 < len(buffers) {
 		err = socket.conn.WriteLines(buffers)
+		if err == nil {
+			socket.countSent(len(buffers), totalLength)
+		}
 	}
 
 	closed = closed || err != nil
@@ -239,4 +306,5 @@ func (socket *Socket) finalize() {
 
 	// close the connection
 	socket.conn.Close()
+	close(socket.done)
 }
@@ -2,6 +2,12 @@ package irc
 
 import (
 	"sync"
+	"sync/atomic"
+)
+
+const (
+	// STATS m counter for commands that aren't in the command table
+	unknownCommandCounter = "UNKNOWN"
 )
 
 type StatsValues struct {
@@ -16,6 +22,18 @@ type StatsValues struct {
 type Stats struct {
 	StatsValues
 
+	// command name to number of uses, for STATS m; the map is built once
+	// (from the command table) and then only read, so counting is lock-free
+	commandCounts     map[string]*atomic.Uint64
+	commandCountsOnce sync.Once
+
+	acceptCounts         map[string]uint64 // listener address to number of accepted connections
+	registrationFailures uint64
+
+	// server-wide traffic counters, updated by every Socket:
+	sentBytes     atomic.Uint64
+	receivedBytes atomic.Uint64
+
 	mutex sync.Mutex
 }
 
@@ -96,3 +114,75 @@ func (s *Stats) GetValues() (result StatsValues) {
 	s.mutex.Unlock()
 	return
 }
+
+func (s *Stats) initCommandCounts() {
+	s.commandCountsOnce.Do(func() {
+		s.commandCounts = make(map[string]*atomic.Uint64, len(Commands)+1)
+		for command := range Commands {
+			s.commandCounts[command] = new(atomic.Uint64)
+		}
+		s.commandCounts[unknownCommandCounter] = new(atomic.Uint64)
+	})
+}
+
+// Records one use of the given command
+func (s *Stats) CountCommand(command string) {
+	s.initCommandCounts()
+	counter, ok := s.commandCounts[command]
+	if !ok {
+		counter = s.commandCounts[unknownCommandCounter]
+	}
+	counter.Add(1)
+}
+
+// GetCommandCounts returns a copy of the nonzero per-command usage counters
+func (s *Stats) GetCommandCounts() (result map[string]uint64) {
+	s.initCommandCounts()
+	result = make(map[string]uint64)
+	for command, counter := range s.commandCounts {
+		if count := counter.Load(); count != 0 {
+			result[command] = count
+		}
+	}
+	return
+}
+
+// Records a connection accepted on the listener with the given address
+func (s *Stats) CountAccept(listener string) {
+	s.mutex.Lock()
+	if s.acceptCounts == nil {
+		s.acceptCounts = make(map[string]uint64)
+	}
+	s.acceptCounts[listener] += 1
+	s.mutex.Unlock()
+}
+
+// GetAcceptCounts returns a copy of the per-listener accept counters
+func (s *Stats) GetAcceptCounts() (result map[string]uint64) {
+	s.mutex.Lock()
+	result = make(map[string]uint64, len(s.acceptCounts))
+	for listener, count := range s.acceptCounts {
+		result[listener] = count
+	}
+	s.mutex.Unlock()
+	return
+}
+
+// Records a connection that was rejected or disconnected before completing registration
+func (s *Stats) CountRegistrationFailure() {
+	s.mutex.Lock()
+	s.registrationFailures += 1
+	s.mutex.Unlock()
+}
+
+func (s *Stats) GetRegistrationFailures() (result uint64) {
+	s.mutex.Lock()
+	result = s.registrationFailures
+	s.mutex.Unlock()
+	return
+}
+
+// GetTraffic returns the total number of bytes sent and received by all sockets
+func (s *Stats) GetTraffic() (sentBytes, receivedBytes uint64) {
+	return s.sentBytes.Load(), s.receivedBytes.Load()
+}
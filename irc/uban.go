@@ -105,13 +105,13 @@ func ubanHandler(server *Server, client *Client, msg ircmsg.Message, rb *Respons
 	var target ubanTarget
 	if subcommand != "list" {
 		if len(msg.Params) == 1 {
-			rb.Add(nil, client.server.name, "FAIL", "UBAN", "INVALID_PARAMS", client.t("Not enough parameters"))
+			rb.Fail("UBAN", "INVALID_PARAMS", client.t("Not enough parameters"))
 			return false
 		}
 		var parseErr error
 		target, parseErr = parseUbanTarget(params[0])
 		if parseErr != nil {
-			rb.Add(nil, client.server.name, "FAIL", "UBAN", "INVALID_PARAMS", client.t("Couldn't parse ban target"))
+			rb.Fail("UBAN", "INVALID_PARAMS", client.t("Couldn't parse ban target"))
 			return false
 		}
 		params = params[1:]
@@ -127,7 +127,7 @@ func ubanHandler(server *Server, client *Client, msg ircmsg.Message, rb *Respons
 	case "info":
 		return ubanInfoHandler(client, target, params, rb)
 	default:
-		rb.Add(nil, server.name, "FAIL", "UBAN", "UNKNOWN_COMMAND", client.t("Unknown command"))
+		rb.Fail("UBAN", "UNKNOWN_COMMAND", client.t("Unknown command"))
 		return false
 	}
 }
@@ -215,6 +215,18 @@ func announceUban(client *Client, add bool, target ubanTarget, duration time.Dur
 	line := buf.String()
 	client.server.snomasks.Send(sno.LocalXline, line)
 	client.server.logger.Info("opers", line)
+	action := "UBAN DEL"
+	if add {
+		action = "UBAN ADD"
+	}
+	var targetString string
+	switch target.banType {
+	case ubanCIDR:
+		targetString = target.cidr.HumanReadableString()
+	case ubanNickmask, ubanNick:
+		targetString = target.nickOrMask
+	}
+	client.server.audit(client, action, targetString, operReason)
 }
 
 func ubanAddCIDR(client *Client, target ubanTarget, duration time.Duration, requireSASL bool, operReason string, rb *ResponseBuffer) (err error) {
@@ -28,6 +28,7 @@ type UserMaskSet struct {
 	masks                  map[string]MaskInfo
 	regexp                 atomic.Pointer[regexp.Regexp]
 	muteRegexp             atomic.Pointer[regexp.Regexp]
+	extbanRegexps          atomic.Pointer[map[byte]*regexp.Regexp]
 }
 
 func NewUserMaskSet() *UserMaskSet {
@@ -36,7 +37,7 @@ func NewUserMaskSet() *UserMaskSet {
 
 // Add adds the given mask to this set.
 func (set *UserMaskSet) Add(mask, creatorNickmask, creatorAccount string) (maskAdded string, err error) {
-	casefoldedMask, err := CanonicalizeMaskWildcard(mask)
+	casefoldedMask, err := canonicalizeListMask(mask)
 	if err != nil {
 		return
 	}
@@ -67,7 +68,7 @@ func (set *UserMaskSet) Add(mask, creatorNickmask, creatorAccount string) (maskA
 
 // Remove removes the given mask from this set.
 func (set *UserMaskSet) Remove(mask string) (maskRemoved string, err error) {
-	mask, err = CanonicalizeMaskWildcard(mask)
+	mask, err = canonicalizeListMask(mask)
 	if err != nil {
 		return
 	}
@@ -117,6 +118,23 @@ func (set *UserMaskSet) Match(userhost string) bool {
 	return regexp.MatchString(userhost)
 }
 
+// MatchClient matches the given client against the standard bans and the extbans.
+func (set *UserMaskSet) MatchClient(details *ClientDetails) bool {
+	if set.Match(details.nickMaskCasefolded) {
+		return true
+	}
+	extbanRegexps := set.extbanRegexps.Load()
+	if extbanRegexps == nil {
+		return false
+	}
+	for letter, re := range *extbanRegexps {
+		if property := extbanTypes[letter].property(details); property != "" && re.MatchString(property) {
+			return true
+		}
+	}
+	return false
+}
+
 // MatchMute matches the given NUH against the mute extbans.
 func (set *UserMaskSet) MatchMute(userhost string) bool {
 	regexp := set.MuteRegexp()
@@ -141,9 +159,15 @@ func (set *UserMaskSet) setRegexp() {
 	set.RLock()
 	maskExprs := make([]string, 0, len(set.masks))
 	var muteExprs []string
+	var extbanExprs map[byte][]string
 	for mask := range set.masks {
 		if strings.HasPrefix(mask, "m:") {
 			muteExprs = append(muteExprs, mask[2:])
+		} else if letter, pattern, ok := splitExtban(mask); ok {
+			if extbanExprs == nil {
+				extbanExprs = make(map[byte][]string)
+			}
+			extbanExprs[letter] = append(extbanExprs[letter], pattern)
 		} else {
 			maskExprs = append(maskExprs, mask)
 		}
@@ -160,7 +184,21 @@ func (set *UserMaskSet) setRegexp() {
 
 	re := compileMasks(maskExprs)
 	muteRe := compileMasks(muteExprs)
+	var extbanRes map[byte]*regexp.Regexp
+	if len(extbanExprs) != 0 {
+		extbanRes = make(map[byte]*regexp.Regexp, len(extbanExprs))
+		for letter, exprs := range extbanExprs {
+			if extbanRe := compileMasks(exprs); extbanRe != nil {
+				extbanRes[letter] = extbanRe
+			}
+		}
+	}
 
 	set.regexp.Store(re)
 	set.muteRegexp.Store(muteRe)
+	if extbanRes == nil {
+		set.extbanRegexps.Store(nil)
+	} else {
+		set.extbanRegexps.Store(&extbanRes)
+	}
 }
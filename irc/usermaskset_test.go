@@ -34,3 +34,39 @@ func TestUserMaskSet(t *testing.T) {
 		t.Errorf("unexpected MatchMute() succeeded")
 	}
 }
+
+func TestUserMaskSetExtbans(t *testing.T) {
+	s := NewUserMaskSet()
+
+	evan := ClientDetails{nickMaskCasefolded: "horse!~evan@tor-network.onion"}
+	evan.account = "evan"
+	evan.realname = "Evan the Horse"
+	anon := ClientDetails{nickMaskCasefolded: "horse!~evan@tor-network.onion"}
+
+	if added, err := s.Add("a:Evan", "", ""); added != "a:evan" || err != nil {
+		t.Errorf("unexpected result adding account extban: %s, %v", added, err)
+	}
+	if s.Match(evan.nickMaskCasefolded) {
+		t.Errorf("account extbans should not Match(), only MatchClient()")
+	}
+	if !s.MatchClient(&evan) {
+		t.Errorf("expected account extban match failed")
+	}
+	if s.MatchClient(&anon) {
+		t.Errorf("account extban should not match unauthenticated clients")
+	}
+
+	s.Remove("a:evan")
+	s.Add("r:*the horse", "", "")
+	if !s.MatchClient(&evan) {
+		t.Errorf("expected realname extban match failed")
+	}
+	if s.MatchClient(&anon) {
+		t.Errorf("unexpected realname extban match succeeded")
+	}
+
+	s.Remove("r:*THE HORSE")
+	if s.MatchClient(&evan) || s.Length() != 0 {
+		t.Errorf("removing the realname extban failed")
+	}
+}
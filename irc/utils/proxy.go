@@ -52,6 +52,7 @@ type ListenerConfig struct {
 	STSOnly   bool
 	WebSocket bool
 	HideSTS   bool
+	ACME      bool // TLSConfig gets its certificates from the ACME manager
 }
 
 // read a PROXY header (either v1 or v2), ensuring we don't read anything beyond
@@ -0,0 +1,87 @@
+package utils
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	systemdListenFDsStart = 3 // SD_LISTEN_FDS_START
+)
+
+// SystemdListeners returns the listening sockets passed to the process by systemd
+// socket activation (see sd_listen_fds(3)), or nil if there are none.
+func SystemdListeners() (listeners []net.Listener, err error) {
+	if pid, pidErr := strconv.Atoi(os.Getenv("LISTEN_PID")); pidErr != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	count, countErr := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if countErr != nil || count <= 0 {
+		return nil, nil
+	}
+	// don't pass these on to child processes (e.g., auth scripts):
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	for fd := systemdListenFDsStart; fd < systemdListenFDsStart+count; fd++ {
+		file := os.NewFile(uintptr(fd), fmt.Sprintf("systemd-fd-%d", fd))
+		listener, listenErr := net.FileListener(file)
+		// FileListener dups the descriptor, so the original can be closed:
+		file.Close()
+		if listenErr != nil {
+			err = listenErr
+			continue
+		}
+		listeners = append(listeners, listener)
+	}
+	return
+}
+
+// ListenerAddrMatches returns whether a socket bound to `actual` can serve the
+// configured listener address `configured` (e.g., ":6667", "127.0.0.1:6667",
+// or "unix:/path/to/socket").
+func ListenerAddrMatches(configured string, actual net.Addr) bool {
+	configured = strings.TrimPrefix(configured, "unix:")
+	if strings.HasPrefix(configured, "/") {
+		unixAddr, ok := actual.(*net.UnixAddr)
+		return ok && unixAddr.Name == configured
+	}
+
+	tcpAddr, ok := actual.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	host, portStr, err := net.SplitHostPort(configured)
+	if err != nil {
+		return false
+	}
+	if port, err := strconv.Atoi(portStr); err != nil || port != tcpAddr.Port {
+		return false
+	}
+	if host == "" {
+		return tcpAddr.IP.IsUnspecified()
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.Equal(tcpAddr.IP)
+}
+
+// SystemdWatchdogInterval returns how often to send WATCHDOG=1 to systemd,
+// or 0 if the watchdog is not enabled for this process (see sd_watchdog_enabled(3)).
+func SystemdWatchdogInterval() time.Duration {
+	if pidStr := os.Getenv("WATCHDOG_PID"); pidStr != "" {
+		if pid, err := strconv.Atoi(pidStr); err != nil || pid != os.Getpid() {
+			return 0
+		}
+	}
+	usec, err := strconv.ParseUint(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec == 0 {
+		return 0
+	}
+	// ping at half the timeout, as recommended by sd_watchdog_enabled(3):
+	return time.Duration(usec) * time.Microsecond / 2
+}
@@ -0,0 +1,34 @@
+package utils
+
+import (
+	"net"
+	"testing"
+)
+
+func TestListenerAddrMatches(t *testing.T) {
+	wildcard := &net.TCPAddr{IP: net.IPv6zero, Port: 6667}
+	loopback := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 6697}
+	unixAddr := &net.UnixAddr{Name: "/run/ergo/ergo.sock", Net: "unix"}
+
+	cases := []struct {
+		configured string
+		actual     net.Addr
+		expected   bool
+	}{
+		{":6667", wildcard, true},
+		{":6668", wildcard, false},
+		{"127.0.0.1:6667", wildcard, false},
+		{"127.0.0.1:6697", loopback, true},
+		{":6697", loopback, false},
+		{"[::1]:6697", loopback, false},
+		{"unix:/run/ergo/ergo.sock", unixAddr, true},
+		{"/run/ergo/ergo.sock", unixAddr, true},
+		{"unix:/run/ergo/other.sock", unixAddr, false},
+		{":6667", unixAddr, false},
+	}
+	for _, c := range cases {
+		if ListenerAddrMatches(c.configured, c.actual) != c.expected {
+			t.Errorf("ListenerAddrMatches(%s, %s) should be %t", c.configured, c.actual, c.expected)
+		}
+	}
+}
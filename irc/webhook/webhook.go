@@ -0,0 +1,123 @@
+// Package webhook delivers signed JSON payloads to HTTP endpoints.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// SignatureHeader carries the HMAC-SHA256 of the timestamp, a period,
+	// and the request body, keyed with the endpoint's secret
+	SignatureHeader = "X-Ergo-Signature"
+	// TimestampHeader carries the UNIX time at which the request was signed
+	TimestampHeader = "X-Ergo-Timestamp"
+	// EventHeader carries the type of the event
+	EventHeader = "X-Ergo-Event"
+	// DeliveryHeader carries a unique ID for the delivery, which is
+	// unchanged across retries
+	DeliveryHeader = "X-Ergo-Delivery"
+
+	initialBackoff = time.Second
+)
+
+var (
+	errPermanent = errors.New("endpoint rejected the delivery")
+)
+
+// Sign computes the value of the signature header for a request body.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte{'.'})
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks the value of the signature header for a request body.
+func Verify(secret string, timestamp int64, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, timestamp, body)), []byte(signature))
+}
+
+// Delivery is a single payload to be delivered to an endpoint.
+type Delivery struct {
+	URL       string
+	Secret    string
+	EventType string
+	ID        string
+	Body      []byte
+}
+
+// Sender delivers payloads, retrying failed deliveries.
+type Sender struct {
+	client *http.Client
+	// for testing
+	backoff time.Duration
+}
+
+func NewSender() *Sender {
+	return &Sender{
+		client: &http.Client{
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		backoff: initialBackoff,
+	}
+}
+
+// Send delivers the payload, making up to `maxRetries` additional attempts
+// (with exponential backoff) if the endpoint is unreachable or returns a
+// server error. each attempt is limited to `timeout`.
+func (s *Sender) Send(delivery Delivery, timeout time.Duration, maxRetries int) (err error) {
+	backoff := s.backoff
+	for attempt := 0; ; attempt++ {
+		err = s.attempt(delivery, timeout)
+		if err == nil || errors.Is(err, errPermanent) || maxRetries <= attempt {
+			return
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+func (s *Sender) attempt(delivery Delivery, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(delivery.Body))
+	if err != nil {
+		return fmt.Errorf("%w: %w", errPermanent, err)
+	}
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "ergo-webhook")
+	req.Header.Set(EventHeader, delivery.EventType)
+	req.Header.Set(DeliveryHeader, delivery.ID)
+	req.Header.Set(TimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(SignatureHeader, Sign(delivery.Secret, timestamp, delivery.Body))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 65536))
+
+	switch {
+	case 200 <= resp.StatusCode && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusTooManyRequests || 500 <= resp.StatusCode:
+		return fmt.Errorf("endpoint returned HTTP status %d", resp.StatusCode)
+	default:
+		return fmt.Errorf("%w: HTTP status %d", errPermanent, resp.StatusCode)
+	}
+}
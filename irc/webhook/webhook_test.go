@@ -0,0 +1,74 @@
+package webhook
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSign(t *testing.T) {
+	body := []byte(`{"type":"join"}`)
+	signature := Sign("secret", 1700000000, body)
+	if signature != "sha256=aa76302011d02b8e22548ba92746a88fe1522c1b9b892d36f6cbb6c8aa3db9b2" {
+		t.Errorf("unexpected signature %s", signature)
+	}
+	if !Verify("secret", 1700000000, body, signature) {
+		t.Errorf("signature did not verify")
+	}
+	if Verify("secret", 1700000001, body, signature) || Verify("other", 1700000000, body, signature) {
+		t.Errorf("signature verified with the wrong timestamp or secret")
+	}
+}
+
+func TestSend(t *testing.T) {
+	var requests atomic.Int32
+	var failures int32
+	status := http.StatusNoContent
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := requests.Add(1)
+		body, _ := io.ReadAll(r.Body)
+		timestamp, _ := strconv.ParseInt(r.Header.Get(TimestampHeader), 10, 64)
+		if !Verify("secret", timestamp, body, r.Header.Get(SignatureHeader)) || r.Header.Get(EventHeader) != "join" || r.Header.Get(DeliveryHeader) != "abc" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if n <= failures {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	sender := NewSender()
+	sender.backoff = time.Millisecond
+	delivery := Delivery{URL: server.URL, Secret: "secret", EventType: "join", ID: "abc", Body: []byte(`{}`)}
+
+	if err := sender.Send(delivery, time.Second, 3); err != nil || requests.Load() != 1 {
+		t.Errorf("unexpected result: %v after %d requests", err, requests.Load())
+	}
+
+	// transient failures are retried
+	requests.Store(0)
+	failures = 2
+	if err := sender.Send(delivery, time.Second, 3); err != nil || requests.Load() != 3 {
+		t.Errorf("unexpected result: %v after %d requests", err, requests.Load())
+	}
+	requests.Store(0)
+	failures = 10
+	if err := sender.Send(delivery, time.Second, 3); err == nil || requests.Load() != 4 {
+		t.Errorf("unexpected result: %v after %d requests", err, requests.Load())
+	}
+
+	// client errors are not
+	requests.Store(0)
+	failures = 0
+	status = http.StatusBadRequest
+	if err := sender.Send(delivery, time.Second, 3); err == nil || requests.Load() != 1 {
+		t.Errorf("unexpected result: %v after %d requests", err, requests.Load())
+	}
+}
@@ -0,0 +1,337 @@
+package irc
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ergochat/ergo/irc/connection_limits"
+	"github.com/ergochat/ergo/irc/utils"
+	"github.com/ergochat/ergo/irc/webhook"
+)
+
+// outgoing webhooks POST a JSON description of channel and server events
+// to the configured endpoints, for moderation tooling and analytics.
+// deliveries are signed with the endpoint's secret; see the webhook package.
+// each endpoint has its own queue and worker, so that retries to an endpoint
+// that is down don't delay (or cause drops of) deliveries to the others.
+//
+// incoming webhooks let external systems (CI, monitoring) post messages to
+// channels with an HTTP request. the messages are relayed like RELAYMSG, from
+// a nick of the form <nick><separator><nick-suffix>.
+
+const (
+	// per endpoint
+	webhookQueueSize = 1024
+
+	webhookEventMessage = "message"
+	webhookEventJoin    = "join"
+	webhookEventPart    = "part"
+	webhookEventKick    = "kick"
+	webhookEventTopic   = "topic"
+	webhookEventOper    = "oper"
+
+	// value of the relaymsg tag for messages posted by incoming webhooks,
+	// and the hostname of their spoofed NUH
+	incomingWebhookRelayer = "webhook"
+	maxIncomingWebhookSize = 16384
+)
+
+var (
+	webhookEventTypes = []string{webhookEventMessage, webhookEventJoin, webhookEventPart, webhookEventKick, webhookEventTopic, webhookEventOper}
+)
+
+// WebhookEvent is the payload of a webhook delivery; fields that don't
+// apply to the event type are omitted.
+type WebhookEvent struct {
+	Type    string    `json:"type"`
+	Time    time.Time `json:"time"`
+	Channel string    `json:"channel,omitempty"`
+	// the nick!user@host of the client that caused the event
+	Source  string `json:"source,omitempty"`
+	Account string `json:"account,omitempty"`
+	Msgid   string `json:"msgid,omitempty"`
+	// PRIVMSG or NOTICE, for messages
+	Command string `json:"command,omitempty"`
+	// the message, topic, or part/kick/oper reason
+	Text string `json:"text,omitempty"`
+	// the kicked nick, or the target of an operator action
+	Target string `json:"target,omitempty"`
+	// the operator action, e.g., KLINE, and the name of the operator block
+	Action string `json:"action,omitempty"`
+	Oper   string `json:"oper,omitempty"`
+	// the name of the HTTP API token used for the operator action, if any
+	APIToken string `json:"api_token,omitempty"`
+}
+
+func makeWebhookEvent(eventType, chname string, details ClientDetails, message utils.SplitMessage) WebhookEvent {
+	event := WebhookEvent{
+		Type:    eventType,
+		Time:    message.Time,
+		Channel: chname,
+		Source:  details.nickMask,
+		Msgid:   message.Msgid,
+		Text:    flattenSplitMessage(message),
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now().UTC()
+	}
+	if details.accountName != "*" {
+		event.Account = details.accountName
+	}
+	return event
+}
+
+// WebhookManager queues and delivers outgoing webhook events,
+// and serves incoming webhooks.
+type WebhookManager struct {
+	sync.Mutex // tier 1
+
+	server *Server
+	sender *webhook.Sender
+	// endpoint URL to the queue processed by its worker
+	queues     map[string]chan webhook.Delivery
+	httpServer *http.Server
+	settings   apiSettings // of the incoming webhook listener
+	throttles  map[string]*connection_limits.GenericThrottle
+}
+
+func (wm *WebhookManager) Initialize(server *Server) {
+	wm.server = server
+	wm.sender = webhook.NewSender()
+	wm.queues = make(map[string]chan webhook.Delivery)
+	wm.throttles = make(map[string]*connection_limits.GenericThrottle)
+}
+
+// enqueue queues a delivery for its endpoint's worker (starting the worker
+// if necessary), without blocking
+func (wm *WebhookManager) enqueue(delivery webhook.Delivery) {
+	wm.Lock()
+	defer wm.Unlock()
+
+	queue, ok := wm.queues[delivery.URL]
+	if !ok {
+		queue = make(chan webhook.Delivery, webhookQueueSize)
+		wm.queues[delivery.URL] = queue
+		go wm.processQueue(queue)
+	}
+	select {
+	case queue <- delivery:
+	default:
+		wm.server.logger.Warning("webhooks", "webhook queue is full, dropping event for", delivery.URL)
+	}
+}
+
+// Dispatch queues `event` for delivery to every endpoint subscribed to it;
+// it does not block.
+func (wm *WebhookManager) Dispatch(event WebhookEvent) {
+	config := wm.server.Config()
+	if !config.Webhooks.Enabled {
+		return
+	}
+	var body []byte
+	var id string
+	var cfchname string
+	if event.Channel != "" {
+		cfchname, _ = CasefoldChannel(event.Channel)
+	}
+	for _, endpoint := range config.Webhooks.Endpoints {
+		if !endpoint.events.Has(event.Type) {
+			continue
+		}
+		// server events (e.g., oper actions) are not subject to channel filtering
+		if event.Channel != "" && endpoint.channels != nil && !endpoint.channels.Has(cfchname) {
+			continue
+		}
+		if body == nil {
+			var err error
+			body, err = json.Marshal(event)
+			if err != nil {
+				wm.server.logger.Error("webhooks", "couldn't serialize event", err.Error())
+				return
+			}
+			id = utils.GenerateSecretToken()
+		}
+		wm.enqueue(webhook.Delivery{
+			URL:       endpoint.URL,
+			Secret:    endpoint.Secret,
+			EventType: event.Type,
+			ID:        id,
+			Body:      body,
+		})
+	}
+}
+
+func (wm *WebhookManager) processQueue(queue chan webhook.Delivery) {
+	for delivery := range queue {
+		config := wm.server.Config()
+		err := wm.sender.Send(delivery, config.Webhooks.Timeout, config.Webhooks.MaxRetries)
+		if err != nil {
+			wm.server.logger.Warning("webhooks", "couldn't deliver webhook", delivery.URL, delivery.EventType, err.Error())
+		}
+	}
+}
+
+// Stop closes the incoming webhook listener, if any.
+func (wm *WebhookManager) Stop() {
+	wm.Lock()
+	defer wm.Unlock()
+
+	if wm.httpServer != nil {
+		wm.httpServer.Close()
+		wm.httpServer = nil
+	}
+}
+
+// ApplyConfig starts, stops, or moves the incoming webhook listener as necessary.
+func (wm *WebhookManager) ApplyConfig(config *Config) {
+	wm.Lock()
+	defer wm.Unlock()
+
+	// stop the workers of endpoints that were removed; their queued
+	// deliveries are still attempted
+	for url, queue := range wm.queues {
+		if !slices.ContainsFunc(config.Webhooks.Endpoints, func(e WebhookEndpointConfig) bool { return e.URL == url }) || !config.Webhooks.Enabled {
+			close(queue)
+			delete(wm.queues, url)
+		}
+	}
+
+	var settings apiSettings
+	if incoming := &config.Webhooks.Incoming; incoming.Enabled {
+		settings = apiSettings{listener: incoming.Listener, cert: incoming.TLS.Cert, key: incoming.TLS.Key}
+	}
+	if wm.httpServer != nil {
+		if settings == wm.settings {
+			return
+		}
+		wm.server.logger.Info("webhooks", "Stopping incoming webhook listener", wm.httpServer.Addr)
+		wm.httpServer.Close()
+		wm.httpServer = nil
+	}
+	wm.settings = settings
+	if settings.listener == "" {
+		return
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /webhook", wm.serveIncoming)
+	httpServer := &http.Server{
+		Addr:    settings.listener,
+		Handler: mux,
+	}
+	go func() {
+		var err error
+		if settings.cert != "" {
+			err = httpServer.ListenAndServeTLS(settings.cert, settings.key)
+		} else {
+			err = httpServer.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
+			wm.server.logger.Error("webhooks", "incoming webhook listener failed", err.Error())
+		}
+	}()
+	wm.httpServer = httpServer
+	wm.server.logger.Info("webhooks", "Started incoming webhook listener", settings.listener)
+}
+
+// throttled checks (and updates) the rate limit on a webhook
+func (wm *WebhookManager) throttled(name string, config *Config) (throttled bool, remaining time.Duration) {
+	wm.Lock()
+	defer wm.Unlock()
+
+	throttle, ok := wm.throttles[name]
+	if !ok {
+		throttle = new(connection_limits.GenericThrottle)
+		wm.throttles[name] = throttle
+	}
+	throttle.Duration = config.Webhooks.Incoming.Throttling.Duration
+	throttle.Limit = config.Webhooks.Incoming.Throttling.MaxAttempts
+	return throttle.Touch()
+}
+
+// lookupIncomingWebhook returns the name and configuration of the webhook
+// with the bearer token presented in the request, if there is one
+func lookupIncomingWebhook(config *Config, r *http.Request) (name string, hook *IncomingWebhookConfig) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return
+	}
+	for hookName, hookConfig := range config.Webhooks.Incoming.Tokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(hookConfig.Token)) == 1 {
+			return hookName, &hookConfig
+		}
+	}
+	return
+}
+
+type incomingWebhookRequest struct {
+	Channel string `json:"channel"`
+	Text    string `json:"text"`
+}
+
+type incomingWebhookResponse struct {
+	Msgid string `json:"msgid,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+func writeWebhookResponse(w http.ResponseWriter, status int, response incomingWebhookResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
+
+// POST /webhook {"channel": "#chan", "text": "..."}
+func (wm *WebhookManager) serveIncoming(w http.ResponseWriter, r *http.Request) {
+	server := wm.server
+	defer server.HandlePanic()
+
+	config := server.Config()
+	if !config.Webhooks.Incoming.Enabled {
+		writeWebhookResponse(w, http.StatusNotFound, incomingWebhookResponse{Error: "incoming webhooks are disabled"})
+		return
+	}
+	name, hook := lookupIncomingWebhook(config, r)
+	if hook == nil {
+		writeWebhookResponse(w, http.StatusUnauthorized, incomingWebhookResponse{Error: "invalid token"})
+		return
+	}
+	var request incomingWebhookRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxIncomingWebhookSize)).Decode(&request); err != nil {
+		writeWebhookResponse(w, http.StatusBadRequest, incomingWebhookResponse{Error: "invalid request body"})
+		return
+	}
+	cfchname, err := CasefoldChannel(request.Channel)
+	if err != nil || !hook.channels.Has(cfchname) {
+		writeWebhookResponse(w, http.StatusForbidden, incomingWebhookResponse{Error: "this webhook may not post to that channel"})
+		return
+	}
+	message, ok := makeRelayedSplitMessage(request.Text)
+	if !ok {
+		writeWebhookResponse(w, http.StatusBadRequest, incomingWebhookResponse{Error: "empty message"})
+		return
+	}
+	channel := server.channels.Get(cfchname)
+	if channel == nil {
+		writeWebhookResponse(w, http.StatusNotFound, incomingWebhookResponse{Error: "no such channel"})
+		return
+	}
+	if throttled, remaining := wm.throttled(name, config); throttled {
+		w.Header().Set("Retry-After", fmt.Sprintf("%d", int(math.Ceil(remaining.Seconds()))))
+		writeWebhookResponse(w, http.StatusTooManyRequests, incomingWebhookResponse{Error: "rate limit exceeded"})
+		return
+	}
+
+	if err := bridgeRelayMessage(channel, hook.nick, incomingWebhookRelayer, message); err != nil {
+		writeWebhookResponse(w, http.StatusInternalServerError, incomingWebhookResponse{Error: "invalid nick"})
+		return
+	}
+	server.logger.Debug("webhooks", "incoming webhook posted to channel", name, channel.Name())
+	writeWebhookResponse(w, http.StatusOK, incomingWebhookResponse{Msgid: message.Msgid})
+}
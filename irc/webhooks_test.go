@@ -0,0 +1,104 @@
+package irc
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ergochat/ergo/irc/logger"
+	"github.com/ergochat/ergo/irc/utils"
+)
+
+func TestMakeWebhookEvent(t *testing.T) {
+	var details ClientDetails
+	details.nickMask = "alice!u@h"
+	details.accountName = "*"
+	message := utils.SplitMessage{Msgid: "abc", Time: time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC)}
+	message.Append("hello", false)
+	message.Append("world", false)
+
+	event := makeWebhookEvent(webhookEventMessage, "#chat", details, message)
+	event.Command = "PRIVMSG"
+	serialized, err := json.Marshal(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"type":"message","time":"2026-10-14T12:00:00Z","channel":"#chat","source":"alice!u@h","msgid":"abc","command":"PRIVMSG","text":"hello\nworld"}`
+	if string(serialized) != expected {
+		t.Errorf("unexpected serialization %s", serialized)
+	}
+
+	details.accountName = "alice"
+	event = makeWebhookEvent(webhookEventJoin, "#chat", details, utils.MakeMessage(""))
+	if event.Account != "alice" || event.Time.IsZero() || event.Msgid == "" {
+		t.Errorf("unexpected event %#v", event)
+	}
+}
+
+func TestLookupIncomingWebhook(t *testing.T) {
+	config := &Config{}
+	config.Webhooks.Incoming.Tokens = map[string]IncomingWebhookConfig{
+		"ci":      {Token: "0123456789abcdef"},
+		"monitor": {Token: "fedcba9876543210"},
+	}
+	for header, expected := range map[string]string{
+		"Bearer 0123456789abcdef": "ci",
+		"Bearer fedcba9876543210": "monitor",
+		"Bearer 0123456789abcdeX": "",
+		"0123456789abcdef":        "",
+		"Bearer ":                 "",
+		"":                        "",
+	} {
+		r := httptest.NewRequest("POST", "/webhook", nil)
+		r.Header.Set("Authorization", header)
+		name, hook := lookupIncomingWebhook(config, r)
+		if name != expected || (hook == nil) != (expected == "") {
+			t.Errorf("%#v: expected %#v, got %#v", header, expected, name)
+		}
+	}
+}
+
+func TestWebhookQueuePerEndpoint(t *testing.T) {
+	unblock := make(chan struct{})
+	dead := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-unblock
+	}))
+	defer dead.Close()
+	defer close(unblock)
+	var delivered atomic.Int32
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		delivered.Add(1)
+	}))
+	defer healthy.Close()
+
+	logger, err := logger.NewManager(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &Server{logger: logger}
+	config := &Config{}
+	config.Webhooks.Enabled = true
+	config.Webhooks.Timeout = time.Minute
+	config.Webhooks.Endpoints = []WebhookEndpointConfig{
+		{URL: dead.URL, events: utils.SetLiteral(webhookEventJoin)},
+		{URL: healthy.URL, events: utils.SetLiteral(webhookEventJoin)},
+	}
+	server.config.Store(config)
+	server.webhooks.Initialize(server)
+
+	// deliveries to the healthy endpoint aren't held up by the dead one
+	const numEvents = 20
+	for i := 0; i < numEvents; i++ {
+		server.webhooks.Dispatch(WebhookEvent{Type: webhookEventJoin})
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for delivered.Load() != numEvents && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if delivered.Load() != numEvents {
+		t.Errorf("expected %d deliveries to the healthy endpoint, got %d", numEvents, delivered.Load())
+	}
+}
@@ -0,0 +1,382 @@
+package irc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ergochat/irc-go/ircmsg"
+	"github.com/tidwall/buntdb"
+
+	"github.com/ergochat/ergo/irc/connection_limits"
+	"github.com/ergochat/ergo/irc/utils"
+	"github.com/ergochat/ergo/irc/webpush"
+)
+
+// draft/webpush: an always-on client can register Web Push subscriptions
+// for its account with the WEBPUSH command. while the client has no attached
+// sessions, direct messages and channel highlights are delivered to those
+// subscriptions; the payload of each notification is the IRC line the
+// client would otherwise have received.
+
+const (
+	webpushQueueSize  = 1024
+	webpushNumWorkers = 4
+	// how long the push service should retain an undelivered notification
+	webpushTTL = 24 * time.Hour
+)
+
+var (
+	errTooManyPushSubscriptions = errors.New("Too many push subscriptions")
+)
+
+// PushSubscription is a Web Push subscription registered by a client.
+type PushSubscription struct {
+	Endpoint    string
+	P256DH      string // base64url-encoded, as supplied by the client
+	Auth        string
+	LastRefresh time.Time
+}
+
+// LoadPushSubscriptions returns the push subscriptions registered for `account`.
+func (am *AccountManager) LoadPushSubscriptions(account string) (subscriptions []PushSubscription, err error) {
+	cfaccount, err := CasefoldName(account)
+	if err != nil {
+		return nil, errAccountDoesNotExist
+	}
+	err = am.server.store.View(func(tx *buntdb.Tx) error {
+		subscriptions, err = loadPushSubscriptions(tx, cfaccount)
+		return err
+	})
+	return
+}
+
+func loadPushSubscriptions(tx *buntdb.Tx, cfaccount string) (subscriptions []PushSubscription, err error) {
+	rawSubscriptions, err := tx.Get(fmt.Sprintf(keyAccountWebPush, cfaccount))
+	if err == buntdb.ErrNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal([]byte(rawSubscriptions), &subscriptions)
+	return
+}
+
+// ModifyPushSubscriptions atomically loads, modifies (via munger), and stores
+// the push subscriptions registered for `account`.
+func (am *AccountManager) ModifyPushSubscriptions(account string, munger func([]PushSubscription) ([]PushSubscription, error)) (err error) {
+	cfaccount, err := CasefoldName(account)
+	if err != nil {
+		return errAccountDoesNotExist
+	}
+	subscriptionsKey := fmt.Sprintf(keyAccountWebPush, cfaccount)
+	return am.server.store.Update(func(tx *buntdb.Tx) error {
+		subscriptions, err := loadPushSubscriptions(tx, cfaccount)
+		if err != nil {
+			return err
+		}
+		subscriptions, err = munger(subscriptions)
+		if err != nil {
+			return err
+		}
+		if len(subscriptions) == 0 {
+			tx.Delete(subscriptionsKey)
+			return nil
+		}
+		newRawSubscriptions, err := json.Marshal(subscriptions)
+		if err != nil {
+			return err
+		}
+		_, _, err = tx.Set(subscriptionsKey, string(newRawSubscriptions), nil)
+		return err
+	})
+}
+
+func removePushSubscriptions(subscriptions []PushSubscription, endpoints utils.HashSet[string]) []PushSubscription {
+	result := subscriptions[:0]
+	for _, subscription := range subscriptions {
+		if !endpoints.Has(subscription.Endpoint) {
+			result = append(result, subscription)
+		}
+	}
+	return result
+}
+
+type pushJob struct {
+	account       string
+	subscriptions []PushSubscription
+	message       []byte
+}
+
+// WebPushManager throttles and delivers push notifications; delivery
+// happens asynchronously, on a fixed pool of worker goroutines.
+type WebPushManager struct {
+	sync.Mutex // tier 1
+
+	server    *Server
+	sender    *webpush.Sender
+	queue     chan pushJob
+	throttles map[string]*connection_limits.GenericThrottle
+}
+
+func (wm *WebPushManager) Initialize(server *Server) {
+	wm.server = server
+	wm.sender = webpush.NewSender()
+	wm.queue = make(chan pushJob, webpushQueueSize)
+	wm.throttles = make(map[string]*connection_limits.GenericThrottle)
+	for i := 0; i < webpushNumWorkers; i++ {
+		go wm.processQueue()
+	}
+}
+
+// throttled checks (and updates) the per-account rate limit on notifications
+func (wm *WebPushManager) throttled(cfaccount string, config *Config) bool {
+	wm.Lock()
+	defer wm.Unlock()
+
+	throttle, ok := wm.throttles[cfaccount]
+	if !ok {
+		throttle = new(connection_limits.GenericThrottle)
+		wm.throttles[cfaccount] = throttle
+	}
+	throttle.Duration = config.WebPush.Throttling.Duration
+	throttle.Limit = config.WebPush.Throttling.MaxAttempts
+	throttled, _ := throttle.Touch()
+	return throttled
+}
+
+// Notify pushes `message` to the subscriptions of the account of `client`,
+// if there are any; it does not block.
+func (wm *WebPushManager) Notify(client *Client, message ircmsg.Message) {
+	config := wm.server.Config()
+	if !config.WebPush.Enabled || config.WebPush.vapidKeys == nil {
+		return
+	}
+	account := client.Account()
+	if account == "" {
+		return
+	}
+	subscriptions, err := wm.server.accounts.LoadPushSubscriptions(account)
+	if err != nil {
+		wm.server.logger.Error("webpush", "couldn't load push subscriptions", account, err.Error())
+		return
+	}
+	if len(subscriptions) == 0 || wm.throttled(account, config) {
+		return
+	}
+	line, err := message.LineBytesStrict(false, MaxLineLen)
+	if err != nil && err != ircmsg.ErrorBodyTooLong {
+		wm.server.logger.Error("webpush", "couldn't serialize push message", account, err.Error())
+		return
+	}
+	select {
+	case wm.queue <- pushJob{account: account, subscriptions: subscriptions, message: trimCRLF(line)}:
+	default:
+		wm.server.logger.Warning("webpush", "push queue is full, dropping notification for", account)
+	}
+}
+
+func trimCRLF(line []byte) []byte {
+	for len(line) != 0 && (line[len(line)-1] == '\n' || line[len(line)-1] == '\r') {
+		line = line[:len(line)-1]
+	}
+	return line
+}
+
+func (wm *WebPushManager) processQueue() {
+	for job := range wm.queue {
+		wm.deliver(job)
+	}
+}
+
+func (wm *WebPushManager) deliver(job pushJob) {
+	defer wm.server.HandlePanic()
+
+	config := wm.server.Config()
+	vapidKeys := config.WebPush.vapidKeys
+	if !config.WebPush.Enabled || vapidKeys == nil {
+		return
+	}
+
+	expired := make(utils.HashSet[string])
+	now := time.Now().UTC()
+	for _, subscription := range job.subscriptions {
+		if time.Duration(config.WebPush.Expiration) < now.Sub(subscription.LastRefresh) {
+			expired.Add(subscription.Endpoint)
+			continue
+		}
+		keys, err := webpush.DecodeKeys(subscription.P256DH, subscription.Auth)
+		if err != nil {
+			expired.Add(subscription.Endpoint)
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), config.WebPush.Timeout)
+		err = wm.sender.Send(ctx, subscription.Endpoint, keys, vapidKeys, config.WebPush.Subscriber, webpushTTL, job.message)
+		cancel()
+		if err == webpush.ErrSubscriptionGone {
+			expired.Add(subscription.Endpoint)
+		} else if err != nil {
+			wm.server.logger.Debug("webpush", "couldn't deliver push notification", job.account, subscription.Endpoint, err.Error())
+		}
+	}
+
+	if len(expired) != 0 {
+		wm.server.logger.Debug("webpush", "deleting expired push subscriptions for", job.account)
+		err := wm.server.accounts.ModifyPushSubscriptions(job.account, func(subscriptions []PushSubscription) ([]PushSubscription, error) {
+			return removePushSubscriptions(subscriptions, expired), nil
+		})
+		if err != nil {
+			wm.server.logger.Error("webpush", "couldn't delete push subscriptions", job.account, err.Error())
+		}
+	}
+}
+
+// makePushMessage composes the line a client would have received for `message`,
+// with the tags that are relevant to a notification
+func makePushMessage(nickmask, accountName, command, target string, message utils.SplitMessage) (msg ircmsg.Message) {
+	text := message.Message
+	if !message.Is512() {
+		var buf strings.Builder
+		for i, messagePair := range message.Split {
+			if i != 0 && !messagePair.Concat {
+				buf.WriteByte(' ')
+			}
+			buf.WriteString(messagePair.Message)
+		}
+		text = buf.String()
+	}
+	msg = ircmsg.MakeMessage(nil, nickmask, command, target, text)
+	msg.SetTag("time", message.Time.Format(IRCv3TimestampFormat))
+	if message.Msgid != "" {
+		msg.SetTag("msgid", message.Msgid)
+	}
+	if accountName != "*" && accountName != "" {
+		msg.SetTag("account", accountName)
+	}
+	return
+}
+
+// messageHighlights returns whether `message` mentions `nick`, i.e., whether
+// it contains `nick` (case-insensitively) not adjacent to other nickname characters
+func messageHighlights(nick string, message utils.SplitMessage) bool {
+	if message.Is512() {
+		return textHighlights(nick, message.Message)
+	}
+	for _, messagePair := range message.Split {
+		if textHighlights(nick, messagePair.Message) {
+			return true
+		}
+	}
+	return false
+}
+
+func textHighlights(nick, text string) bool {
+	if nick == "" {
+		return false
+	}
+	nick = strings.ToLower(nick)
+	text = strings.ToLower(text)
+	for offset := 0; offset < len(text); {
+		index := strings.Index(text[offset:], nick)
+		if index == -1 {
+			return false
+		}
+		start := offset + index
+		end := start + len(nick)
+		if (start == 0 || !isNickChar(text[start-1])) && (end == len(text) || !isNickChar(text[end])) {
+			return true
+		}
+		offset = start + 1
+	}
+	return false
+}
+
+func isNickChar(c byte) bool {
+	return ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') || strings.IndexByte("[]\\`_^{|}-", c) != -1 || 0x80 <= c
+}
+
+// WEBPUSH REGISTER <endpoint> <keys>
+// WEBPUSH UNREGISTER <endpoint>
+func webpushHandler(server *Server, client *Client, msg ircmsg.Message, rb *ResponseBuffer) bool {
+	config := server.Config()
+	subcommand := strings.ToUpper(msg.Params[0])
+	if !config.WebPush.Enabled {
+		rb.Fail("WEBPUSH", "FORBIDDEN", client.t("Push notifications are disabled"), subcommand)
+		return false
+	}
+	account := client.Account()
+	if account == "" || !client.AlwaysOn() {
+		rb.Fail("WEBPUSH", "FORBIDDEN", client.t("You must be logged in to an always-on client to receive push notifications"), subcommand)
+		return false
+	}
+
+	endpoint := msg.Params[1]
+	var err error
+	switch subcommand {
+	case "REGISTER":
+		if len(msg.Params) < 3 {
+			rb.Fail("WEBPUSH", "INVALID_PARAMS", client.t("Not enough parameters"), subcommand)
+			return false
+		}
+		if _, err = webpush.ValidateEndpoint(endpoint); err != nil {
+			rb.Fail("WEBPUSH", "INVALID_PARAMS", client.t("Invalid push endpoint"), subcommand)
+			return false
+		}
+		// the keys are encoded like message tags: p256dh=...;auth=...
+		var p256dh, auth string
+		for _, item := range strings.Split(msg.Params[2], ";") {
+			key, value, _ := strings.Cut(item, "=")
+			switch key {
+			case "p256dh":
+				p256dh = value
+			case "auth":
+				auth = value
+			}
+		}
+		if _, err = webpush.DecodeKeys(p256dh, auth); err != nil {
+			rb.Fail("WEBPUSH", "INVALID_PARAMS", client.t("Invalid push subscription keys"), subcommand)
+			return false
+		}
+		subscription := PushSubscription{
+			Endpoint:    endpoint,
+			P256DH:      p256dh,
+			Auth:        auth,
+			LastRefresh: time.Now().UTC(),
+		}
+		err = server.accounts.ModifyPushSubscriptions(account, func(subscriptions []PushSubscription) ([]PushSubscription, error) {
+			for i := range subscriptions {
+				if subscriptions[i].Endpoint == endpoint {
+					// re-registering an existing endpoint refreshes it
+					subscriptions[i] = subscription
+					return subscriptions, nil
+				}
+			}
+			if config.WebPush.MaxSubscriptions <= len(subscriptions) {
+				return nil, errTooManyPushSubscriptions
+			}
+			return append(subscriptions, subscription), nil
+		})
+	case "UNREGISTER":
+		err = server.accounts.ModifyPushSubscriptions(account, func(subscriptions []PushSubscription) ([]PushSubscription, error) {
+			return removePushSubscriptions(subscriptions, utils.SetLiteral(endpoint)), nil
+		})
+	default:
+		rb.Fail("WEBPUSH", "INVALID_PARAMS", client.t("Invalid subcommand"), subcommand)
+		return false
+	}
+
+	switch err {
+	case nil:
+		rb.Add(nil, server.name, "WEBPUSH", subcommand, endpoint)
+	case errTooManyPushSubscriptions:
+		rb.Fail("WEBPUSH", "MAX_REGISTRATIONS", client.t("You have too many push subscriptions"), subcommand)
+	default:
+		server.logger.Error("webpush", "couldn't modify push subscriptions", account, err.Error())
+		rb.Fail("WEBPUSH", "INTERNAL_ERROR", client.t("An error occurred"), subcommand)
+	}
+	return false
+}
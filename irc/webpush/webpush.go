@@ -0,0 +1,332 @@
+// Package webpush implements the sending side of Web Push notifications:
+// message encryption (RFC 8291), VAPID authentication (RFC 8292),
+// and delivery to a push service (RFC 8030).
+package webpush
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"syscall"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	// the push service is only required to accept payloads of up to 4096 bytes;
+	// this is the maximum plaintext that fits in a single record of that size
+	// (4096 - header - padding delimiter - AEAD tag)
+	MaxPlaintextLen = recordSize - headerLen - 1 - 16
+
+	recordSize = 4096
+	saltLen    = 16
+	headerLen  = saltLen + 4 + 1 + 65
+
+	// VAPID tokens may be valid for at most 24 hours
+	vapidTokenLifetime = 12 * time.Hour
+
+	dialTimeout = 10 * time.Second
+)
+
+var (
+	ErrPayloadTooLarge = errors.New("push payload is too large")
+	ErrInvalidKeys     = errors.New("invalid push subscription keys")
+	ErrInvalidEndpoint = errors.New("invalid push endpoint")
+	// ErrSubscriptionGone indicates that the push service no longer recognizes
+	// the subscription, which should therefore be deleted
+	ErrSubscriptionGone = errors.New("push subscription has expired or been unsubscribed")
+
+	errForbiddenAddress = errors.New("push endpoint resolves to a forbidden address")
+
+	b64 = base64.RawURLEncoding
+)
+
+// Keys are the client's keys for a push subscription.
+type Keys struct {
+	P256DH []byte // the user agent's ECDH public key, in uncompressed form
+	Auth   []byte // the authentication secret
+}
+
+// DecodeKeys decodes the base64url-encoded `p256dh` and `auth` keys of a subscription.
+func DecodeKeys(p256dh, auth string) (keys Keys, err error) {
+	keys.P256DH, err = b64.DecodeString(p256dh)
+	if err != nil {
+		return keys, ErrInvalidKeys
+	}
+	keys.Auth, err = b64.DecodeString(auth)
+	if err != nil || len(keys.Auth) != 16 {
+		return keys, ErrInvalidKeys
+	}
+	if _, err = ecdh.P256().NewPublicKey(keys.P256DH); err != nil {
+		return keys, ErrInvalidKeys
+	}
+	return keys, nil
+}
+
+// Encrypt encrypts `plaintext` for the subscription `keys`, producing
+// a request body with the aes128gcm content encoding.
+func Encrypt(keys Keys, plaintext []byte) (body []byte, err error) {
+	asPrivate, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return
+	}
+	salt := make([]byte, saltLen)
+	if _, err = rand.Read(salt); err != nil {
+		return
+	}
+	return encrypt(keys, plaintext, asPrivate, salt)
+}
+
+func encrypt(keys Keys, plaintext []byte, asPrivate *ecdh.PrivateKey, salt []byte) (body []byte, err error) {
+	if MaxPlaintextLen < len(plaintext) {
+		return nil, ErrPayloadTooLarge
+	}
+	uaPublic, err := ecdh.P256().NewPublicKey(keys.P256DH)
+	if err != nil {
+		return nil, ErrInvalidKeys
+	}
+	ecdhSecret, err := asPrivate.ECDH(uaPublic)
+	if err != nil {
+		return nil, ErrInvalidKeys
+	}
+	asPublic := asPrivate.PublicKey().Bytes()
+
+	// RFC 8291, section 3.4: combine the shared secret with the auth secret
+	keyInfo := make([]byte, 0, 144)
+	keyInfo = append(keyInfo, "WebPush: info\x00"...)
+	keyInfo = append(keyInfo, keys.P256DH...)
+	keyInfo = append(keyInfo, asPublic...)
+	ikm := hkdf(keys.Auth, ecdhSecret, keyInfo, 32)
+
+	// RFC 8188, section 2.2: derive the content encryption key and nonce
+	cek := hkdf(salt, ikm, []byte("Content-Encoding: aes128gcm\x00"), 16)
+	nonce := hkdf(salt, ikm, []byte("Content-Encoding: nonce\x00"), 12)
+
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return
+	}
+
+	body = make([]byte, headerLen, recordSize)
+	copy(body, salt)
+	binary.BigEndian.PutUint32(body[saltLen:], recordSize)
+	body[saltLen+4] = byte(len(asPublic))
+	copy(body[saltLen+5:], asPublic)
+
+	// a single record, terminated by the last-record padding delimiter
+	record := make([]byte, 0, len(plaintext)+1)
+	record = append(record, plaintext...)
+	record = append(record, 2)
+	return gcm.Seal(body, nonce, record, nil), nil
+}
+
+// hkdf is HKDF (RFC 5869) with SHA-256, for output lengths of at most 32 bytes
+func hkdf(salt, secret, info []byte, length int) []byte {
+	extract := hmac.New(sha256.New, salt)
+	extract.Write(secret)
+	prk := extract.Sum(nil)
+
+	expand := hmac.New(sha256.New, prk)
+	expand.Write(info)
+	expand.Write([]byte{1})
+	return expand.Sum(nil)[:length]
+}
+
+// VAPIDKeys is the server's keypair for identifying itself to push services.
+type VAPIDKeys struct {
+	publicKey  []byte // uncompressed form
+	privateKey *ecdsa.PrivateKey
+}
+
+// GenerateVAPIDKeys generates a new VAPID keypair.
+func GenerateVAPIDKeys() (*VAPIDKeys, error) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return newVAPIDKeys(privateKey)
+}
+
+func newVAPIDKeys(privateKey *ecdsa.PrivateKey) (*VAPIDKeys, error) {
+	ecdhKey, err := privateKey.PublicKey.ECDH()
+	if err != nil {
+		return nil, err
+	}
+	return &VAPIDKeys{
+		publicKey:  ecdhKey.Bytes(),
+		privateKey: privateKey,
+	}, nil
+}
+
+// PublicKeyString returns the base64url-encoded public key, as expected
+// by the `applicationServerKey` option of the browser Push API.
+func (v *VAPIDKeys) PublicKeyString() string {
+	return b64.EncodeToString(v.publicKey)
+}
+
+type vapidKeysSerialized struct {
+	PrivateKey []byte // SEC 1, ASN.1 DER form
+}
+
+func (v *VAPIDKeys) MarshalJSON() ([]byte, error) {
+	der, err := x509.MarshalECPrivateKey(v.privateKey)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(vapidKeysSerialized{PrivateKey: der})
+}
+
+func (v *VAPIDKeys) UnmarshalJSON(data []byte) (err error) {
+	var serialized vapidKeysSerialized
+	if err = json.Unmarshal(data, &serialized); err != nil {
+		return
+	}
+	privateKey, err := x509.ParseECPrivateKey(serialized.PrivateKey)
+	if err != nil {
+		return
+	}
+	keys, err := newVAPIDKeys(privateKey)
+	if err != nil {
+		return
+	}
+	*v = *keys
+	return nil
+}
+
+// authorization computes the value of the Authorization header for a
+// request to `endpoint`; `subscriber` is a contact URI (mailto: or https:)
+// for the server operator.
+func (v *VAPIDKeys) authorization(endpoint *url.URL, subscriber string) (string, error) {
+	claims := jwt.MapClaims{
+		"aud": fmt.Sprintf("%s://%s", endpoint.Scheme, endpoint.Host),
+		"exp": time.Now().Add(vapidTokenLifetime).Unix(),
+	}
+	if subscriber != "" {
+		claims["sub"] = subscriber
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodES256, claims).SignedString(v.privateKey)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("vapid t=%s, k=%s", token, v.PublicKeyString()), nil
+}
+
+// ValidateEndpoint checks that a push endpoint is an https URL.
+func ValidateEndpoint(endpoint string) (*url.URL, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Scheme != "https" || u.Host == "" || u.User != nil {
+		return nil, ErrInvalidEndpoint
+	}
+	return u, nil
+}
+
+// Sender delivers push messages. Since push endpoints are supplied by
+// untrusted clients, it refuses to connect to loopback, private, or
+// otherwise non-public addresses.
+type Sender struct {
+	client *http.Client
+}
+
+// NewSender returns a new Sender; the caller is responsible for bounding
+// the duration of each request via its context.
+func NewSender() *Sender {
+	dialer := &net.Dialer{
+		Timeout: dialTimeout,
+		Control: checkDialAddress,
+	}
+	transport := &http.Transport{
+		Proxy:               nil,
+		DialContext:         dialer.DialContext,
+		TLSHandshakeTimeout: dialTimeout,
+		MaxIdleConns:        16,
+		IdleConnTimeout:     time.Minute,
+	}
+	return &Sender{
+		client: &http.Client{
+			Transport: transport,
+			// push services have no reason to redirect
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
+func checkDialAddress(network, address string, _ syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return err
+	}
+	addr := addrPort.Addr().Unmap()
+	if !addr.IsGlobalUnicast() || addr.IsPrivate() {
+		return errForbiddenAddress
+	}
+	return nil
+}
+
+// Send encrypts `message` and delivers it to the subscription at `endpoint`.
+// It returns ErrSubscriptionGone if the subscription should be deleted.
+func (s *Sender) Send(ctx context.Context, endpoint string, keys Keys, vapid *VAPIDKeys, subscriber string, ttl time.Duration, message []byte) (err error) {
+	u, err := ValidateEndpoint(endpoint)
+	if err != nil {
+		return
+	}
+	body, err := Encrypt(keys, message)
+	if err != nil {
+		return
+	}
+	authorization, err := vapid.authorization(u, subscriber)
+	if err != nil {
+		return
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return
+	}
+	req.Header.Set("Authorization", authorization)
+	req.Header.Set("Content-Encoding", "aes128gcm")
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("TTL", strconv.FormatInt(int64(ttl/time.Second), 10))
+	req.Header.Set("Urgency", "high")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		return ErrSubscriptionGone
+	case 200 <= resp.StatusCode && resp.StatusCode < 300:
+		return nil
+	default:
+		return fmt.Errorf("push service returned HTTP status %d", resp.StatusCode)
+	}
+}
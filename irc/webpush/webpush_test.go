@@ -0,0 +1,170 @@
+package webpush
+
+import (
+	"context"
+	"crypto/ecdh"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt/v5"
+)
+
+func mustDecode(t *testing.T, s string) []byte {
+	result, err := b64.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+// test vector from RFC 8291, appendix A
+func TestEncrypt(t *testing.T) {
+	keys, err := DecodeKeys(
+		"BCVxsr7N_eNgVRqvHtD0zTZsEc6-VV-JvLexhqUzORcxaOzi6-AYWXvTBHm4bjyPjs7Vd8pZGH6SRpkNtoIAiw4",
+		"BTBZMqHH6r4Tts7J_aSIgg",
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	asPrivate, err := ecdh.P256().NewPrivateKey(mustDecode(t, "yfWPiYE-n46HLnH0KqZOF1fJJU3MYrct3AELtAQ-oRw"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	salt := mustDecode(t, "DGv6ra1nlYgDCS1FRnbzlw")
+
+	body, err := encrypt(keys, []byte("When I grow up, I want to be a watermelon"), asPrivate, salt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "DGv6ra1nlYgDCS1FRnbzlwAAEABBBP4z9KsN6nGRTbVYI_c7VJSPQTBtkgcy27mlmlMoZIIgDll6e3vCYLocInmYWAmS6TlzAC8wEqKK6PBru3jl7A_yl95bQpu6cVPTpK4Mqgkf1CXztLVBSt2Ks3oZwbuwXPXLWyouBWLVWGNWQexSgSxsj_Qulcy4a-fN"
+	if b64.EncodeToString(body) != expected {
+		t.Errorf("unexpected ciphertext %s", b64.EncodeToString(body))
+	}
+
+	if _, err := encrypt(keys, make([]byte, MaxPlaintextLen+1), asPrivate, salt); err != ErrPayloadTooLarge {
+		t.Errorf("expected oversized payload to be rejected, got %v", err)
+	}
+	body, err = encrypt(keys, make([]byte, MaxPlaintextLen), asPrivate, salt)
+	if err != nil || len(body) != recordSize {
+		t.Errorf("maximum payload should fill a record exactly: %d %v", len(body), err)
+	}
+}
+
+func TestDecodeKeys(t *testing.T) {
+	if _, err := DecodeKeys("BCVxsr7N", "BTBZMqHH6r4Tts7J_aSIgg"); err != ErrInvalidKeys {
+		t.Errorf("invalid p256dh key should be rejected")
+	}
+	if _, err := DecodeKeys("BCVxsr7N_eNgVRqvHtD0zTZsEc6-VV-JvLexhqUzORcxaOzi6-AYWXvTBHm4bjyPjs7Vd8pZGH6SRpkNtoIAiw4", "BTBZ"); err != ErrInvalidKeys {
+		t.Errorf("invalid auth secret should be rejected")
+	}
+}
+
+func TestVAPID(t *testing.T) {
+	keys, err := GenerateVAPIDKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	serialized, err := json.Marshal(keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var deserialized VAPIDKeys
+	if err := json.Unmarshal(serialized, &deserialized); err != nil {
+		t.Fatal(err)
+	}
+	if deserialized.PublicKeyString() != keys.PublicKeyString() {
+		t.Errorf("public key changed across serialization")
+	}
+
+	endpoint, _ := url.Parse("https://push.example.com/send/abc")
+	authorization, err := deserialized.authorization(endpoint, "mailto:admin@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokenString := strings.TrimSuffix(strings.TrimPrefix(authorization, "vapid t="), ", k="+keys.PublicKeyString())
+	claims := jwt.MapClaims{}
+	_, err = jwt.ParseWithClaims(tokenString, claims, func(*jwt.Token) (interface{}, error) {
+		return &keys.privateKey.PublicKey, nil
+	}, jwt.WithValidMethods([]string{"ES256"}), jwt.WithAudience("https://push.example.com"))
+	if err != nil {
+		t.Errorf("invalid VAPID token: %v", err)
+	}
+	if claims["sub"] != "mailto:admin@example.com" {
+		t.Errorf("unexpected subscriber claim %v", claims["sub"])
+	}
+}
+
+func TestValidateEndpoint(t *testing.T) {
+	for _, endpoint := range []string{"http://push.example.com/", "https://", "https://user@push.example.com/", "push.example.com"} {
+		if _, err := ValidateEndpoint(endpoint); err == nil {
+			t.Errorf("%s should have been rejected", endpoint)
+		}
+	}
+	if _, err := ValidateEndpoint("https://push.example.com/send/abc"); err != nil {
+		t.Errorf("valid endpoint rejected: %v", err)
+	}
+}
+
+func TestCheckDialAddress(t *testing.T) {
+	for _, address := range []string{"127.0.0.1:443", "10.0.0.1:443", "[::1]:443", "[fd00::1]:443", "169.254.169.254:80", "[::ffff:192.168.1.1]:443"} {
+		if checkDialAddress("tcp", address, nil) == nil {
+			t.Errorf("%s should have been forbidden", address)
+		}
+	}
+	if err := checkDialAddress("tcp", "8.8.8.8:443", nil); err != nil {
+		t.Errorf("public address forbidden: %v", err)
+	}
+}
+
+func TestSend(t *testing.T) {
+	status := http.StatusCreated
+	var received *http.Request
+	var receivedBody []byte
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r
+		receivedBody, _ = io.ReadAll(r.Body)
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	keys, err := DecodeKeys(
+		"BCVxsr7N_eNgVRqvHtD0zTZsEc6-VV-JvLexhqUzORcxaOzi6-AYWXvTBHm4bjyPjs7Vd8pZGH6SRpkNtoIAiw4",
+		"BTBZMqHH6r4Tts7J_aSIgg",
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vapid, err := GenerateVAPIDKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the test server listens on loopback, so bypass the address check
+	sender := &Sender{client: server.Client()}
+
+	err = sender.Send(context.Background(), server.URL+"/push", keys, vapid, "", time.Hour, []byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if received.Header.Get("Content-Encoding") != "aes128gcm" || received.Header.Get("TTL") != "3600" ||
+		!strings.HasPrefix(received.Header.Get("Authorization"), "vapid t=") {
+		t.Errorf("unexpected headers %v", received.Header)
+	}
+	if len(receivedBody) != headerLen+len("hello")+1+16 {
+		t.Errorf("unexpected body length %d", len(receivedBody))
+	}
+
+	status = http.StatusGone
+	if err := sender.Send(context.Background(), server.URL+"/push", keys, vapid, "", time.Hour, []byte("hello")); err != ErrSubscriptionGone {
+		t.Errorf("expected ErrSubscriptionGone, got %v", err)
+	}
+	status = http.StatusInternalServerError
+	if err := sender.Send(context.Background(), server.URL+"/push", keys, vapid, "", time.Hour, []byte("hello")); err == nil {
+		t.Errorf("expected an error for HTTP 500")
+	}
+}
@@ -0,0 +1,49 @@
+package irc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ergochat/ergo/irc/utils"
+)
+
+func TestTextHighlights(t *testing.T) {
+	cases := []struct {
+		text      string
+		highlight bool
+	}{
+		{"alice: hi", true},
+		{"hi ALICE", true},
+		{"(alice)", true},
+		{"alice", true},
+		{"malice aforethought", false},
+		{"alice_ is someone else", false},
+		{"ask alice-bot", false},
+		{"alicealice, alice!", true},
+		{"", false},
+	}
+	for _, c := range cases {
+		if textHighlights("Alice", c.text) != c.highlight {
+			t.Errorf("%#v: expected highlight %v", c.text, c.highlight)
+		}
+	}
+}
+
+func TestMakePushMessage(t *testing.T) {
+	message := utils.SplitMessage{
+		Msgid: "abc",
+		Time:  time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC),
+	}
+	message.Append("hello", false)
+	message.Append(" world", true)
+	message.Append("second line", false)
+	msg := makePushMessage("bob!u@h", "bob", "PRIVMSG", "alice", message)
+	if msg.Source != "bob!u@h" || msg.Command != "PRIVMSG" || len(msg.Params) != 2 || msg.Params[1] != "hello world second line" {
+		t.Errorf("unexpected push message %#v", msg)
+	}
+	for tag, value := range map[string]string{"account": "bob", "msgid": "abc", "time": "2026-10-14T12:00:00.000Z"} {
+		if present, actual := msg.GetTag(tag); !present || actual != value {
+			t.Errorf("expected tag %s=%s, got %#v", tag, value, actual)
+		}
+	}
+}
@@ -6,6 +6,7 @@ package irc
 
 import (
 	"sync"
+	"time"
 )
 
 // WhoWasList holds our list of prior clients (for use with the WHOWAS command).
@@ -29,8 +30,10 @@ func (list *WhoWasList) Initialize(size int) {
 	list.end = -1
 }
 
-// Append adds an entry to the WhoWasList.
+// Append adds an entry to the WhoWasList, recording the current time as its signoff time.
 func (list *WhoWasList) Append(whowas WhoWas) {
+	whowas.signoff = time.Now().UTC()
+
 	list.accessMutex.Lock()
 	defer list.accessMutex.Unlock()
 
@@ -40,6 +40,9 @@ func TestWhoWas(t *testing.T) {
 	if len(results) != 1 || results[0].nick != "dan-" {
 		t.Fatalf("incorrect whowas results: %v", results)
 	}
+	if results[0].signoff.IsZero() {
+		t.Fatalf("whowas entry has no signoff time: %v", results[0])
+	}
 
 	wwl.Append(makeTestWhowas("slingamn"))
 	results = wwl.Find("slingamN", 10)